@@ -0,0 +1,101 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CourseMember is a teacher or a student enrolled in a course
+type CourseMember struct {
+	// Username identifies the tenant of the member, whose namespace is tenant-<username>.
+	Username string `json:"username"`
+	// +optional
+	Email string `json:"email,omitempty"`
+	// +optional
+	FirstName string `json:"firstName,omitempty"`
+	// +optional
+	LastName string `json:"lastName,omitempty"`
+}
+
+// CourseLaboratory is a laboratory of a course, from which a LabTemplate named <course>-lab<number> is generated
+type CourseLaboratory struct {
+	// +kubebuilder:validation:Minimum=1
+	Number int32  `json:"number"`
+	Image  string `json:"image"`
+	// +kubebuilder:validation:Minimum=1
+	Cpu uint32 `json:"cpu"`
+	// Memory is the memory of the VM (e.g. 4G).
+	Memory resource.Quantity `json:"memory"`
+	// +kubebuilder:validation:Enum="GUI";"CLI"
+	VmType `json:"vmType,omitempty"`
+	// +optional
+	Description string `json:"description,omitempty"`
+}
+
+// CourseSpec defines the desired state of Course
+type CourseSpec struct {
+	// Name is the human-readable name of the course (e.g. Software Networking).
+	Name string `json:"name"`
+	// +optional
+	Teachers []CourseMember `json:"teachers,omitempty"`
+	// +optional
+	Students []CourseMember `json:"students,omitempty"`
+	// +optional
+	Laboratories []CourseLaboratory `json:"laboratories,omitempty"`
+}
+
+// CourseStatus defines the observed state of Course
+type CourseStatus struct {
+	// Namespace is the namespace of the course, containing its LabTemplates.
+	Namespace string `json:"namespace,omitempty"`
+	// Tenants is the number of tenants (teachers and students) enrolled in the course.
+	Tenants int `json:"tenants,omitempty"`
+	// Laboratories is the number of LabTemplates of the course.
+	Laboratories int `json:"laboratories,omitempty"`
+	// FailedResources are the resources which could not be reconciled.
+	// +optional
+	FailedResources []string `json:"failedResources,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+
+// Course is the Schema for the courses API. It declares a course together with its teachers, students and
+// laboratories: the operator keeps the namespaces, RoleBindings, quotas, NetworkPolicies and LabTemplates
+// of the course and of its tenants in sync with it.
+type Course struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CourseSpec   `json:"spec,omitempty"`
+	Status CourseStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CourseList contains a list of Course
+type CourseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Course `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Course{}, &CourseList{})
+}
@@ -0,0 +1,65 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EnrolmentRole is the role of a tenant in a course
+type EnrolmentRole string
+
+const (
+	// RoleTeacher can manage the LabTemplates of the course and access the LabInstances of the students
+	RoleTeacher EnrolmentRole = "teacher"
+	// RoleStudent can create LabInstances of the LabTemplates of the course
+	RoleStudent EnrolmentRole = "student"
+)
+
+// EnrolmentSpec defines the desired state of Enrolment
+type EnrolmentSpec struct {
+	// Tenant is the name of the Tenant enrolled in the course.
+	Tenant string `json:"tenant"`
+	// Course is the name of the Course.
+	Course string `json:"course"`
+	// +kubebuilder:validation:Enum="teacher";"student"
+	Role EnrolmentRole `json:"role"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// Enrolment is the Schema for the enrolments API. It enrols a Tenant in a Course, either as a teacher or as
+// a student, and it is conventionally named <course>-<tenant>.
+type Enrolment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EnrolmentSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// EnrolmentList contains a list of Enrolment
+type EnrolmentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Enrolment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Enrolment{}, &EnrolmentList{})
+}
@@ -27,6 +27,25 @@ type LabInstanceSpec struct {
 	LabTemplateName      string `json:"labTemplateName,omitempty"`
 	LabTemplateNamespace string `json:"labTemplateNamespace,omitempty"`
 	StudentID            string `json:"studentId,omitempty"`
+	// Team shares the LabInstance among several students.
+	// +optional
+	Team *TeamSpec `json:"team,omitempty"`
+	// Priority lowers the priority tier of the LabTemplate (e.g. for the tests of the exams). Since it is set by the
+	// creator of the LabInstance, it is capped at the tier of the LabTemplate.
+	// +kubebuilder:validation:Enum="student";"teacher";"exam"
+	// +optional
+	Priority PriorityTier `json:"priority,omitempty"`
+	// LabTemplateRevision pins the LabInstance to a revision of the LabTemplate. If not specified, or if the LabInstance
+	// does not belong to a teacher of the course, the LabInstance is created from the released revision.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	LabTemplateRevision int64 `json:"labTemplateRevision,omitempty"`
+}
+
+// TeamSpec identifies the team sharing a LabInstance, among the ones declared by the teachers in the LabTemplate.
+type TeamSpec struct {
+	// Name is the name of a team of the LabTemplate, which the owner of the LabInstance must be a member of.
+	Name string `json:"name"`
 }
 
 // LabInstanceStatus defines the observed state of LabInstance
@@ -36,6 +55,98 @@ type LabInstanceStatus struct {
 	IP    string `json:"ip,omitempty"`
 	// +optional
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	Submission *SubmissionStatus `json:"submission,omitempty"`
+	// Vms reports the status of each VM of a multi-VM laboratory.
+	// +optional
+	Vms []VmStatus `json:"vms,omitempty"`
+	// QueuePosition is the position of the LabInstance in the admission queue, while waiting for free capacity.
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+	// Team reports the team owning the LabInstance, with the members declared by the LabTemplate.
+	// +optional
+	Team *TeamStatus `json:"team,omitempty"`
+	// Conditions report further details about the state of the LabInstance (e.g. whether it has been preempted).
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Readiness reports the result of the readiness checks of the VM of single-VM laboratories.
+	// +optional
+	Readiness *ReadinessStatus `json:"readiness,omitempty"`
+	// Failure describes the cause of the last failure of the VMs, and how to solve it.
+	// +optional
+	Failure *FailureStatus `json:"failure,omitempty"`
+	// LabTemplateRevision is the revision of the LabTemplate the LabInstance has been created from.
+	// +optional
+	LabTemplateRevision int64 `json:"labTemplateRevision,omitempty"`
+	// ResourceName is the name prefix shared by the resources of the LabInstance, used to create again its VMs.
+	// +optional
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// FailureStatus is the diagnosis of the failure of a VM of a LabInstance
+type FailureStatus struct {
+	// Reason is the summarised cause of the failure (e.g. Unschedulable, ImagePullFailed, OutOfMemory).
+	Reason string `json:"reason"`
+	// Message reports the details of the failure, as collected from the VMI, its pod and their events.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Hint suggests how to solve the failure.
+	// +optional
+	Hint string `json:"hint,omitempty"`
+	// Vm is the name of the failed VM in multi-VM laboratories.
+	// +optional
+	Vm string `json:"vm,omitempty"`
+	// Retries is the number of times the failed VMs have been automatically restarted.
+	// +optional
+	Retries int32 `json:"retries,omitempty"`
+	// +optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+}
+
+// ReadinessStatus is the result of the readiness checks of a VM
+type ReadinessStatus struct {
+	// +kubebuilder:validation:Enum="Probing";"Passed";"Failed"
+	Result string `json:"result"`
+	// Check is the type of the check being executed, or of the one which failed.
+	// +optional
+	Check ReadinessCheckType `json:"check,omitempty"`
+	// Attempts is the number of attempts of the check.
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// Reason describes why the last attempt failed.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// +optional
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+}
+
+// TeamStatus is the team owning a LabInstance
+type TeamStatus struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members,omitempty"`
+	Group   string   `json:"group,omitempty"`
+}
+
+// VmStatus is the observed state of one of the VMs of a multi-VM laboratory
+type VmStatus struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase,omitempty"`
+	Url   string `json:"url,omitempty"`
+	IP    string `json:"ip,omitempty"`
+	// +optional
+	Readiness *ReadinessStatus `json:"readiness,omitempty"`
+}
+
+// SubmissionStatus describes the last collection of the work of the student.
+type SubmissionStatus struct {
+	// Request is the value of the collection request which triggered the collection.
+	Request string `json:"request"`
+	Phase   string `json:"phase,omitempty"`
+	// Artifact is the location of the collected work, in the form pvc://<namespace>/<claim>/<path>.
+	Artifact string `json:"artifact,omitempty"`
+	JobName  string `json:"jobName,omitempty"`
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,75 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabSessionSpec defines the desired state of LabSession
+type LabSessionSpec struct {
+	LabTemplateName string `json:"labTemplateName"`
+	// LabTemplateNamespace defaults to the namespace of the LabSession, which it must be equal to: the LabSessions
+	// are accepted only in the namespace of their LabTemplate, where only the teachers of the course can create them.
+	// +optional
+	LabTemplateNamespace string `json:"labTemplateNamespace,omitempty"`
+	// StudentIDs are the students attending the session: a LabInstance is created in the tenant namespace of each of them.
+	StudentIDs []string    `json:"studentIds"`
+	StartTime  metav1.Time `json:"startTime"`
+	EndTime    metav1.Time `json:"endTime"`
+	// Prewarm is how long before the start the LabInstances are created, so that they are ready when the session starts.
+	// If not specified, it defaults to 10 minutes.
+	// +optional
+	Prewarm *metav1.Duration `json:"prewarm,omitempty"`
+}
+
+// LabSessionStatus defines the observed state of LabSession
+type LabSessionStatus struct {
+	// Phase is one of Scheduled, Prewarming, Running, Ended and Rejected.
+	Phase string `json:"phase,omitempty"`
+	// Instances is the number of LabInstances created for the session.
+	Instances int `json:"instances,omitempty"`
+	// FailedStudents are the students whose LabInstance could not be created.
+	// +optional
+	FailedStudents []string `json:"failedStudents,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName="labs"
+
+// LabSession is the Schema for the labsessions API. It schedules the creation of the LabInstances
+// of a group of students shortly before the start of a lab session, and their deletion at its end.
+type LabSession struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LabSessionSpec   `json:"spec,omitempty"`
+	Status LabSessionStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LabSessionList contains a list of LabSession
+type LabSessionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LabSession `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LabSession{}, &LabSessionList{})
+}
@@ -28,6 +28,52 @@ const (
 	TypeCLI VmType = "CLI"
 )
 
+// NamedVm is one of the VMs of a multi-VM laboratory (e.g. client, router, server)
+type NamedVm struct {
+	// +kubebuilder:validation:Pattern="^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"
+	Name string                        `json:"name"`
+	Vm   virtv1.VirtualMachineInstance `json:"vm"`
+	// +kubebuilder:validation:Enum="GUI";"CLI"
+	VmType `json:"vmType,omitempty"`
+	// Networks are the names of the private networks the VM is attached to, in addition to the pod network.
+	// +optional
+	Networks []string `json:"networks,omitempty"`
+	// ReadinessChecks override the readiness checks of the LabTemplate for this VM.
+	// +optional
+	ReadinessChecks []ReadinessCheck `json:"readinessChecks,omitempty"`
+}
+
+// LabNetwork is a private network interconnecting the VMs of a multi-VM laboratory. It is implemented
+// as a Multus bridge network, hence all the VMs of a LabInstance are scheduled on the same node.
+type LabNetwork struct {
+	// +kubebuilder:validation:Pattern="^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"
+	Name string `json:"name"`
+}
+
+// EgressPolicy defines which destinations can be reached by the instances of a LabTemplate
+type EgressPolicy string
+
+const (
+	// EgressInternet allows the instances to reach any destination
+	EgressInternet EgressPolicy = "internet"
+	// EgressCluster allows the instances to reach only the other pods in the cluster
+	EgressCluster EgressPolicy = "cluster"
+	// EgressNone prevents the instances from initiating any connection
+	EgressNone EgressPolicy = "none"
+)
+
+// PriorityTier defines the priority of the instances under contention: instances of higher tiers can preempt the ones of lower tiers
+type PriorityTier string
+
+const (
+	// PriorityStudent is the tier of ordinary practice labs
+	PriorityStudent PriorityTier = "student"
+	// PriorityTeacher is the tier of the instances of teachers
+	PriorityTeacher PriorityTier = "teacher"
+	// PriorityExam is the tier of exams, which is the default one for the templates with an exam profile
+	PriorityExam PriorityTier = "exam"
+)
+
 // LabTemplateSpec defines the desired state of LabTemplate
 type LabTemplateSpec struct {
 	CourseName  string                        `json:"courseName,omitempty"`
@@ -37,12 +83,202 @@ type LabTemplateSpec struct {
 	Vm          virtv1.VirtualMachineInstance `json:"vm"`
 	// +kubebuilder:validation:Enum="GUI";"CLI"
 	VmType `json:"vmType,omitempty"`
+	// Vms describes the VMs of a multi-VM laboratory. When specified, Vm and VmType are ignored.
+	// +optional
+	Vms []NamedVm `json:"vms,omitempty"`
+	// Networks are the private networks interconnecting the VMs of a multi-VM laboratory.
+	// +optional
+	Networks []LabNetwork `json:"networks,omitempty"`
+	// +kubebuilder:validation:Enum="internet";"cluster";"none"
+	// +optional
+	EgressPolicy EgressPolicy `json:"egressPolicy,omitempty"`
+	// +optional
+	Exam *ExamProfile `json:"exam,omitempty"`
+	// +optional
+	Collection *CollectionSpec `json:"collection,omitempty"`
+	// +optional
+	WarmPool *WarmPoolSpec `json:"warmPool,omitempty"`
+	// Priority is the tier of the instances of the LabTemplate. If not specified, it defaults to
+	// "exam" for exams, and to "student" otherwise. The instances of the teachers of the course
+	// are raised to the "teacher" tier, if higher.
+	// +kubebuilder:validation:Enum="student";"teacher";"exam"
+	// +optional
+	Priority PriorityTier `json:"priority,omitempty"`
+	// ReadinessChecks are the checks which must succeed, in order, before the VMs are reported as ready. If not
+	// specified, the VMs are ready once the VNC port (for GUI VMs) or the SSH port (for CLI VMs) accepts connections.
+	// +optional
+	ReadinessChecks []ReadinessCheck `json:"readinessChecks,omitempty"`
+	// Ingress configures how the instances are exposed. The fields not specified are taken from the annotations
+	// of the namespace of the LabTemplate and from the settings of the operator.
+	// +optional
+	Ingress *IngressSettings `json:"ingress,omitempty"`
+	// Release is the revision of the LabTemplate the new instances are created from: setting it to a later revision
+	// rolls out the changes, while setting it to a previous one rolls them back. If not specified, the instances are
+	// created from the latest revision, i.e. the changes are rolled out immediately.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Release *int64 `json:"release,omitempty"`
+	// RevisionHistoryLimit is the number of revisions kept, in addition to the latest one, the released one and the
+	// ones of the existing instances (defaults to 10).
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// Teams are the teams of students allowed to share the instances of the LabTemplate.
+	// +optional
+	Teams []LabTeam `json:"teams,omitempty"`
+}
+
+// LabTeam is a team of students declared by the teachers, whose members can share a LabInstance
+type LabTeam struct {
+	Name string `json:"name"`
+	// StudentIDs are the members of the team: they are granted access to the LabInstances of the team, which are
+	// accounted to all of them and do not mount their Nextcloud drives.
+	StudentIDs []string `json:"studentIds"`
+	// Group is the Keycloak group granted access to the LabInstances of the team, in place of the single members.
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// ReadinessCheckType is the type of a readiness check
+type ReadinessCheckType string
+
+const (
+	// ReadinessTCP checks that the port accepts TCP connections
+	ReadinessTCP ReadinessCheckType = "tcp"
+	// ReadinessHTTP checks that an HTTP GET request to the port and path returns the expected status
+	ReadinessHTTP ReadinessCheckType = "http"
+	// ReadinessSSH checks that the port replies with an SSH banner
+	ReadinessSSH ReadinessCheckType = "ssh"
+	// ReadinessGuestAgent checks that the QEMU guest agent of the VM is connected
+	ReadinessGuestAgent ReadinessCheckType = "guest-agent"
+	// ReadinessKubeVirt checks that the VMI is ready according to the readinessProbe of its specification
+	ReadinessKubeVirt ReadinessCheckType = "kubevirt"
+)
+
+// ReadinessCheck is a check verifying whether a VM is ready to be used. The check is retried until it
+// succeeds, up to FailureThreshold attempts.
+type ReadinessCheck struct {
+	// +kubebuilder:validation:Enum="tcp";"http";"ssh";"guest-agent";"kubevirt"
+	Type ReadinessCheckType `json:"type"`
+	// Port is the port checked by the tcp, http and ssh checks. It defaults to 6080 for GUI VMs and to 22 for CLI
+	// VMs for tcp checks, to 6080 for http checks and to 22 for ssh checks.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// Path is the path requested by the http checks (defaulting to /).
+	// +optional
+	Path string `json:"path,omitempty"`
+	// ExpectedStatus is the status code expected by the http checks (defaulting to 200).
+	// +optional
+	ExpectedStatus int32 `json:"expectedStatus,omitempty"`
+	// InitialDelaySeconds is the time waited before the first attempt.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	// TimeoutSeconds is the timeout of each attempt (defaulting to 1).
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// PeriodSeconds is the time waited after a failed attempt (defaulting to 1).
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// FailureThreshold is the maximum number of attempts before the check fails (defaulting to 120).
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// WarmPoolSpec requests a pool of pre-booted VMs, which are handed over to the new instances of the
+// LabTemplate to avoid waiting for the boot. Pools are not supported by exams and multi-VM laboratories.
+type WarmPoolSpec struct {
+	// Size is the number of unassigned VMs kept ready in the namespace of the LabTemplate.
+	// +kubebuilder:validation:Minimum=0
+	Size int32 `json:"size"`
+}
+
+// IngressMode is how the instances are exposed
+type IngressMode string
+
+const (
+	// IngressPathMode exposes each instance under a path of the host (i.e. host/<uuid>)
+	IngressPathMode IngressMode = "path"
+	// IngressSubdomainMode exposes each instance on its own subdomain of the host (i.e. <uuid>.host)
+	IngressSubdomainMode IngressMode = "subdomain"
+)
+
+// IngressSettings configures the Ingresses exposing the instances
+type IngressSettings struct {
+	// Host is the host name the instances are exposed at (e.g. a per-course subdomain).
+	// +optional
+	Host string `json:"host,omitempty"`
+	// Mode is how the instances are exposed: under a path of the host (the default), or on their own subdomain,
+	// which requires a wildcard DNS record and a wildcard certificate (stored in the TLS secret) for the host.
+	// +kubebuilder:validation:Enum="path";"subdomain"
+	// +optional
+	Mode IngressMode `json:"mode,omitempty"`
+	// TLSSecretName is the secret containing the certificate of the host. If not specified, it defaults to
+	// a secret named after the host when a cert-manager issuer is specified, and to the shared one otherwise.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+	// ClusterIssuer is the cert-manager ClusterIssuer requested to generate the certificate of the host.
+	// +optional
+	ClusterIssuer string `json:"clusterIssuer,omitempty"`
+	// IngressClass is the class of the Ingresses, i.e. the ingress controller which implements them.
+	// +optional
+	IngressClass string `json:"ingressClass,omitempty"`
+	// Gateway is the Gateway the HTTPRoutes are attached to (as namespace/name), when the instances are
+	// exposed through the Gateway API.
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+	// Annotations are added to the Ingresses (or HTTPRoutes), overriding the default ones targeting the nginx ingress
+	// controller, except the ones authenticating the requests, removing the path and injecting snippets. They are
+	// honored only in the settings of the operator, and ignored in the LabTemplates.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ExamProfile turns a LabTemplate into an exam. Instances can only be started within
+// the given time window, they do not mount the Nextcloud drive and they can reach only
+// the DNS service of the cluster and the namespaces labelled with
+// crownlabs.polito.it/exam-egress=allowed (unless the EgressPolicy is "none"). Once the
+// deadline expires, students can no longer access the instance, which remains available
+// to teachers.
+type ExamProfile struct {
+	StartTime metav1.Time `json:"startTime"`
+	EndTime   metav1.Time `json:"endTime"`
+	// TeacherGroup is the OIDC group allowed to access the instances after the deadline.
+	// If not specified, it defaults to the "<namespace>-admin" group of the course.
+	// +optional
+	TeacherGroup string `json:"teacherGroup,omitempty"`
+}
+
+// CollectionSpec describes how the work of the students is collected from the instances, either
+// on request or when the exam ends. The content of Path is copied from the VM into the ClaimName
+// PersistentVolumeClaim of the course namespace, under the <StudentID>/<LabInstance> directory.
+type CollectionSpec struct {
+	Path      string `json:"path"`
+	ClaimName string `json:"claimName"`
+	// Vm is the name of the VM the work is collected from, in multi-VM laboratories (defaults to the first one).
+	// +optional
+	Vm string `json:"vm,omitempty"`
 }
 
 // LabTemplateStatus defines the observed state of LabTemplate
 type LabTemplateStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make lab-template" to regenerate code after modifying this file
+	// PoolReady is the number of VMs of the warm pool ready to be handed over.
+	// +optional
+	PoolReady int32 `json:"poolReady,omitempty"`
+	// PoolBooting is the number of VMs of the warm pool still booting.
+	// +optional
+	PoolBooting int32 `json:"poolBooting,omitempty"`
+	// LatestRevision is the revision recording the current specification of the LabTemplate.
+	// +optional
+	LatestRevision int64 `json:"latestRevision,omitempty"`
+	// ReleasedRevision is the revision the new instances are created from.
+	// +optional
+	ReleasedRevision int64 `json:"releasedRevision,omitempty"`
 }
 
 // +kubebuilder:object:root=true
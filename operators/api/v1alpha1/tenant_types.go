@@ -0,0 +1,62 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TenantSpec defines the desired state of Tenant
+type TenantSpec struct {
+	// StudentID identifies the tenant (e.g. s123456), whose namespace is tenant-<studentId>.
+	StudentID string `json:"studentId"`
+	// +optional
+	Email string `json:"email,omitempty"`
+	// +optional
+	FirstName string `json:"firstName,omitempty"`
+	// +optional
+	LastName string `json:"lastName,omitempty"`
+	// MaxInstances overrides the maximum number of LabInstances the tenant can own, including the ones shared with
+	// a team. If not specified, the limit of the operator applies.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxInstances *int32 `json:"maxInstances,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// Tenant is the Schema for the tenants API. It describes a student or a teacher, whose name is the StudentID
+// in lower case, with the dots replaced by dashes (i.e. the name of its namespace, without the tenant- prefix).
+type Tenant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TenantSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TenantList contains a list of Tenant
+type TenantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Tenant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Tenant{}, &TenantList{})
+}
@@ -0,0 +1,68 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// UsageRecordSpec describes the resources consumed by a LabInstance during its life
+type UsageRecordSpec struct {
+	InstanceName      string `json:"instanceName"`
+	InstanceNamespace string `json:"instanceNamespace"`
+	// Students are the StudentIDs the usage is accounted to (i.e. the members of the team sharing the instance),
+	// or the namespace of the instance in case it does not specify a StudentID.
+	Students          []string `json:"students"`
+	Course            string   `json:"course,omitempty"`
+	TemplateName      string   `json:"templateName"`
+	TemplateNamespace string   `json:"templateNamespace"`
+	// VMs, CPU and Memory are the number of VMs of the instance and the resources they request.
+	VMs    int32             `json:"vms"`
+	CPU    resource.Quantity `json:"cpu,omitempty"`
+	Memory resource.Quantity `json:"memory,omitempty"`
+
+	StartTime metav1.Time `json:"startTime"`
+	// +optional
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+	// +optional
+	StopTime *metav1.Time `json:"stopTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName="usage"
+
+// UsageRecord is the Schema for the usagerecords API. A record is created each time the VMs of a LabInstance
+// are started, and it survives the deletion of the LabInstance to account the consumed resources.
+type UsageRecord struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec UsageRecordSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// UsageRecordList contains a list of UsageRecord
+type UsageRecordList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []UsageRecord `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&UsageRecord{}, &UsageRecordList{})
+}
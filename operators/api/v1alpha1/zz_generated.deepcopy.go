@@ -20,16 +20,305 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *LabInstance) DeepCopyInto(out *LabInstance) {
+func (in *CollectionSpec) DeepCopyInto(out *CollectionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CollectionSpec.
+func (in *CollectionSpec) DeepCopy() *CollectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CollectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Course) DeepCopyInto(out *Course) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Course.
+func (in *Course) DeepCopy() *Course {
+	if in == nil {
+		return nil
+	}
+	out := new(Course)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Course) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CourseLaboratory) DeepCopyInto(out *CourseLaboratory) {
+	*out = *in
+	out.Memory = in.Memory.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CourseLaboratory.
+func (in *CourseLaboratory) DeepCopy() *CourseLaboratory {
+	if in == nil {
+		return nil
+	}
+	out := new(CourseLaboratory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CourseList) DeepCopyInto(out *CourseList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Course, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CourseList.
+func (in *CourseList) DeepCopy() *CourseList {
+	if in == nil {
+		return nil
+	}
+	out := new(CourseList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *CourseList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CourseMember) DeepCopyInto(out *CourseMember) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CourseMember.
+func (in *CourseMember) DeepCopy() *CourseMember {
+	if in == nil {
+		return nil
+	}
+	out := new(CourseMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CourseSpec) DeepCopyInto(out *CourseSpec) {
+	*out = *in
+	if in.Teachers != nil {
+		in, out := &in.Teachers, &out.Teachers
+		*out = make([]CourseMember, len(*in))
+		copy(*out, *in)
+	}
+	if in.Students != nil {
+		in, out := &in.Students, &out.Students
+		*out = make([]CourseMember, len(*in))
+		copy(*out, *in)
+	}
+	if in.Laboratories != nil {
+		in, out := &in.Laboratories, &out.Laboratories
+		*out = make([]CourseLaboratory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CourseSpec.
+func (in *CourseSpec) DeepCopy() *CourseSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CourseSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CourseStatus) DeepCopyInto(out *CourseStatus) {
+	*out = *in
+	if in.FailedResources != nil {
+		in, out := &in.FailedResources, &out.FailedResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CourseStatus.
+func (in *CourseStatus) DeepCopy() *CourseStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CourseStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Enrolment) DeepCopyInto(out *Enrolment) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Enrolment.
+func (in *Enrolment) DeepCopy() *Enrolment {
+	if in == nil {
+		return nil
+	}
+	out := new(Enrolment)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Enrolment) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnrolmentList) DeepCopyInto(out *EnrolmentList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Enrolment, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnrolmentList.
+func (in *EnrolmentList) DeepCopy() *EnrolmentList {
+	if in == nil {
+		return nil
+	}
+	out := new(EnrolmentList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EnrolmentList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnrolmentSpec) DeepCopyInto(out *EnrolmentSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnrolmentSpec.
+func (in *EnrolmentSpec) DeepCopy() *EnrolmentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EnrolmentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExamProfile) DeepCopyInto(out *ExamProfile) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.EndTime.DeepCopyInto(&out.EndTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExamProfile.
+func (in *ExamProfile) DeepCopy() *ExamProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(ExamProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureStatus) DeepCopyInto(out *FailureStatus) {
+	*out = *in
+	if in.LastFailureTime != nil {
+		in, out := &in.LastFailureTime, &out.LastFailureTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureStatus.
+func (in *FailureStatus) DeepCopy() *FailureStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressSettings) DeepCopyInto(out *IngressSettings) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressSettings.
+func (in *IngressSettings) DeepCopy() *IngressSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabInstance) DeepCopyInto(out *LabInstance) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabInstance.
@@ -85,6 +374,11 @@ func (in *LabInstanceList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LabInstanceSpec) DeepCopyInto(out *LabInstanceSpec) {
 	*out = *in
+	if in.Team != nil {
+		in, out := &in.Team, &out.Team
+		*out = new(TeamSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabInstanceSpec.
@@ -100,6 +394,40 @@ func (in *LabInstanceSpec) DeepCopy() *LabInstanceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LabInstanceStatus) DeepCopyInto(out *LabInstanceStatus) {
 	*out = *in
+	if in.Submission != nil {
+		in, out := &in.Submission, &out.Submission
+		*out = new(SubmissionStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Vms != nil {
+		in, out := &in.Vms, &out.Vms
+		*out = make([]VmStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Team != nil {
+		in, out := &in.Team, &out.Team
+		*out = new(TeamStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Readiness != nil {
+		in, out := &in.Readiness, &out.Readiness
+		*out = new(ReadinessStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Failure != nil {
+		in, out := &in.Failure, &out.Failure
+		*out = new(FailureStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabInstanceStatus.
@@ -113,26 +441,41 @@ func (in *LabInstanceStatus) DeepCopy() *LabInstanceStatus {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *LabTemplate) DeepCopyInto(out *LabTemplate) {
+func (in *LabNetwork) DeepCopyInto(out *LabNetwork) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabNetwork.
+func (in *LabNetwork) DeepCopy() *LabNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(LabNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabSession) DeepCopyInto(out *LabSession) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplate.
-func (in *LabTemplate) DeepCopy() *LabTemplate {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSession.
+func (in *LabSession) DeepCopy() *LabSession {
 	if in == nil {
 		return nil
 	}
-	out := new(LabTemplate)
+	out := new(LabSession)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *LabTemplate) DeepCopyObject() runtime.Object {
+func (in *LabSession) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -140,31 +483,31 @@ func (in *LabTemplate) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *LabTemplateList) DeepCopyInto(out *LabTemplateList) {
+func (in *LabSessionList) DeepCopyInto(out *LabSessionList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]LabTemplate, len(*in))
+		*out = make([]LabSession, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplateList.
-func (in *LabTemplateList) DeepCopy() *LabTemplateList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSessionList.
+func (in *LabSessionList) DeepCopy() *LabSessionList {
 	if in == nil {
 		return nil
 	}
-	out := new(LabTemplateList)
+	out := new(LabSessionList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *LabTemplateList) DeepCopyObject() runtime.Object {
+func (in *LabSessionList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -172,33 +515,529 @@ func (in *LabTemplateList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *LabTemplateSpec) DeepCopyInto(out *LabTemplateSpec) {
+func (in *LabSessionSpec) DeepCopyInto(out *LabSessionSpec) {
 	*out = *in
-	out.LabNum = in.LabNum.DeepCopy()
-	in.Vm.DeepCopyInto(&out.Vm)
+	if in.StudentIDs != nil {
+		in, out := &in.StudentIDs, &out.StudentIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.EndTime.DeepCopyInto(&out.EndTime)
+	if in.Prewarm != nil {
+		in, out := &in.Prewarm, &out.Prewarm
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplateSpec.
-func (in *LabTemplateSpec) DeepCopy() *LabTemplateSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSessionSpec.
+func (in *LabSessionSpec) DeepCopy() *LabSessionSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(LabTemplateSpec)
+	out := new(LabSessionSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *LabTemplateStatus) DeepCopyInto(out *LabTemplateStatus) {
+func (in *LabSessionStatus) DeepCopyInto(out *LabSessionStatus) {
 	*out = *in
+	if in.FailedStudents != nil {
+		in, out := &in.FailedStudents, &out.FailedStudents
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplateStatus.
-func (in *LabTemplateStatus) DeepCopy() *LabTemplateStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabSessionStatus.
+func (in *LabSessionStatus) DeepCopy() *LabSessionStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(LabTemplateStatus)
+	out := new(LabSessionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabTeam) DeepCopyInto(out *LabTeam) {
+	*out = *in
+	if in.StudentIDs != nil {
+		in, out := &in.StudentIDs, &out.StudentIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTeam.
+func (in *LabTeam) DeepCopy() *LabTeam {
+	if in == nil {
+		return nil
+	}
+	out := new(LabTeam)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabTemplate) DeepCopyInto(out *LabTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplate.
+func (in *LabTemplate) DeepCopy() *LabTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(LabTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabTemplateList) DeepCopyInto(out *LabTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]LabTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplateList.
+func (in *LabTemplateList) DeepCopy() *LabTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(LabTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *LabTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabTemplateSpec) DeepCopyInto(out *LabTemplateSpec) {
+	*out = *in
+	out.LabNum = in.LabNum.DeepCopy()
+	in.Vm.DeepCopyInto(&out.Vm)
+	if in.Vms != nil {
+		in, out := &in.Vms, &out.Vms
+		*out = make([]NamedVm, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make([]LabNetwork, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exam != nil {
+		in, out := &in.Exam, &out.Exam
+		*out = new(ExamProfile)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Collection != nil {
+		in, out := &in.Collection, &out.Collection
+		*out = new(CollectionSpec)
+		**out = **in
+	}
+	if in.WarmPool != nil {
+		in, out := &in.WarmPool, &out.WarmPool
+		*out = new(WarmPoolSpec)
+		**out = **in
+	}
+	if in.ReadinessChecks != nil {
+		in, out := &in.ReadinessChecks, &out.ReadinessChecks
+		*out = make([]ReadinessCheck, len(*in))
+		copy(*out, *in)
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(IngressSettings)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Release != nil {
+		in, out := &in.Release, &out.Release
+		*out = new(int64)
+		**out = **in
+	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Teams != nil {
+		in, out := &in.Teams, &out.Teams
+		*out = make([]LabTeam, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplateSpec.
+func (in *LabTemplateSpec) DeepCopy() *LabTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LabTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabTemplateStatus) DeepCopyInto(out *LabTemplateStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabTemplateStatus.
+func (in *LabTemplateStatus) DeepCopy() *LabTemplateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LabTemplateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamedVm) DeepCopyInto(out *NamedVm) {
+	*out = *in
+	in.Vm.DeepCopyInto(&out.Vm)
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReadinessChecks != nil {
+		in, out := &in.ReadinessChecks, &out.ReadinessChecks
+		*out = make([]ReadinessCheck, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamedVm.
+func (in *NamedVm) DeepCopy() *NamedVm {
+	if in == nil {
+		return nil
+	}
+	out := new(NamedVm)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReadinessCheck) DeepCopyInto(out *ReadinessCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReadinessCheck.
+func (in *ReadinessCheck) DeepCopy() *ReadinessCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(ReadinessCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReadinessStatus) DeepCopyInto(out *ReadinessStatus) {
+	*out = *in
+	if in.LastProbeTime != nil {
+		in, out := &in.LastProbeTime, &out.LastProbeTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReadinessStatus.
+func (in *ReadinessStatus) DeepCopy() *ReadinessStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReadinessStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubmissionStatus) DeepCopyInto(out *SubmissionStatus) {
+	*out = *in
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubmissionStatus.
+func (in *SubmissionStatus) DeepCopy() *SubmissionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SubmissionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TeamSpec) DeepCopyInto(out *TeamSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TeamSpec.
+func (in *TeamSpec) DeepCopy() *TeamSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TeamSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TeamStatus) DeepCopyInto(out *TeamStatus) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TeamStatus.
+func (in *TeamStatus) DeepCopy() *TeamStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TeamStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Tenant) DeepCopyInto(out *Tenant) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Tenant.
+func (in *Tenant) DeepCopy() *Tenant {
+	if in == nil {
+		return nil
+	}
+	out := new(Tenant)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Tenant) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantList) DeepCopyInto(out *TenantList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Tenant, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantList.
+func (in *TenantList) DeepCopy() *TenantList {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TenantList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantSpec) DeepCopyInto(out *TenantSpec) {
+	*out = *in
+	if in.MaxInstances != nil {
+		in, out := &in.MaxInstances, &out.MaxInstances
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantSpec.
+func (in *TenantSpec) DeepCopy() *TenantSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UsageRecord) DeepCopyInto(out *UsageRecord) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UsageRecord.
+func (in *UsageRecord) DeepCopy() *UsageRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(UsageRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *UsageRecord) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UsageRecordList) DeepCopyInto(out *UsageRecordList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]UsageRecord, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UsageRecordList.
+func (in *UsageRecordList) DeepCopy() *UsageRecordList {
+	if in == nil {
+		return nil
+	}
+	out := new(UsageRecordList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *UsageRecordList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UsageRecordSpec) DeepCopyInto(out *UsageRecordSpec) {
+	*out = *in
+	if in.Students != nil {
+		in, out := &in.Students, &out.Students
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.CPU = in.CPU.DeepCopy()
+	out.Memory = in.Memory.DeepCopy()
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.ReadyTime != nil {
+		in, out := &in.ReadyTime, &out.ReadyTime
+		*out = (*in).DeepCopy()
+	}
+	if in.StopTime != nil {
+		in, out := &in.StopTime, &out.StopTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UsageRecordSpec.
+func (in *UsageRecordSpec) DeepCopy() *UsageRecordSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UsageRecordSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VmStatus) DeepCopyInto(out *VmStatus) {
+	*out = *in
+	if in.Readiness != nil {
+		in, out := &in.Readiness, &out.Readiness
+		*out = new(ReadinessStatus)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VmStatus.
+func (in *VmStatus) DeepCopy() *VmStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VmStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WarmPoolSpec) DeepCopyInto(out *WarmPoolSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WarmPoolSpec.
+func (in *WarmPoolSpec) DeepCopy() *WarmPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WarmPoolSpec)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -0,0 +1,142 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/courses"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/enrolment"
+)
+
+// crownlabs-import imports the CSV files of the provisioning script as Courses, Tenants and Enrolments
+func main() {
+	var kubeconfig string
+	var coursesFile string
+	var laboratoriesFile string
+	var teachersFile string
+	var studentsFile string
+	var prune bool
+
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "The kubeconfig file (defaults to the standard locations)")
+	flag.StringVar(&coursesFile, "courses", "", "The CSV file containing the courses to be created")
+	flag.StringVar(&laboratoriesFile, "laboratories", "", "The CSV file containing the list of laboratories to be created (it requires the courses)")
+	flag.StringVar(&teachersFile, "teachers", "", "The CSV file containing the teachers to be enrolled")
+	flag.StringVar(&studentsFile, "students", "", "The CSV file containing the students to be enrolled")
+	flag.BoolVar(&prune, "prune", false, "Delete the Tenants and the Enrolments previously imported from CSV files which are no longer listed")
+	flag.Parse()
+
+	c, err := kubeClient(kubeconfig)
+	if err != nil {
+		exit(err)
+	}
+	ctx := context.Background()
+
+	if coursesFile != "" {
+		if err := importCourses(ctx, c, coursesFile, laboratoriesFile); err != nil {
+			exit(err)
+		}
+	}
+	if teachersFile != "" || studentsFile != "" {
+		source := &enrolment.CSVSource{Teachers: teachersFile, Students: studentsFile}
+		records, err := source.Records(ctx)
+		if err != nil {
+			exit(err)
+		}
+		result, err := enrolment.Apply(ctx, c, source.Name(), records, prune)
+		for _, updated := range result.Updated {
+			fmt.Println(updated + " imported")
+		}
+		for _, deleted := range result.Deleted {
+			fmt.Println(deleted + " deleted")
+		}
+		exit(err)
+	}
+	exit(nil)
+}
+
+// importCourses creates or updates the Courses described by the given files, preserving their members
+func importCourses(ctx context.Context, c client.Client, coursesFile, laboratoriesFile string) error {
+	coursesReader, err := os.Open(coursesFile)
+	if err != nil {
+		return err
+	}
+	defer coursesReader.Close()
+	var laboratoriesReader io.Reader
+	if laboratoriesFile != "" {
+		f, err := os.Open(laboratoriesFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		laboratoriesReader = f
+	}
+
+	parsed, err := courses.ParseCSV(coursesReader, laboratoriesReader)
+	if err != nil {
+		return err
+	}
+	for i := range parsed {
+		course := &crownlabsv1alpha1.Course{ObjectMeta: parsed[i].ObjectMeta}
+		spec := parsed[i].Spec
+		op, err := controllerutil.CreateOrUpdate(ctx, c, course, func() error {
+			course.Spec.Name = spec.Name
+			course.Spec.Laboratories = spec.Laboratories
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if op != controllerutil.OperationResultNone {
+			fmt.Println("Course " + course.Name + " imported")
+		}
+	}
+	return nil
+}
+
+// kubeClient returns a client with the credentials of the kubeconfig
+func kubeClient(kubeconfig string) (client.Client, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = crownlabsv1alpha1.AddToScheme(scheme)
+	return client.New(restConfig, client.Options{Scheme: scheme})
+}
+
+func exit(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,125 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/apiserver"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/crownlabsctl"
+)
+
+func main() {
+	var apiUrl string
+	var issuer string
+	var clientID string
+	var kubeconfig string
+	var namespace string
+	var studentID string
+
+	flag.StringVar(&apiUrl, "api", os.Getenv("CROWNLABS_API"), "The URL of the API of the operator, authenticating with the OIDC device flow. "+
+		"If empty, the LabInstances are managed directly through Kubernetes with the kubeconfig")
+	flag.StringVar(&issuer, "oidc-provider-url", os.Getenv("CROWNLABS_OIDC_PROVIDER_URL"), "The url of the oidc provider issuing the tokens for the API")
+	flag.StringVar(&clientID, "oidc-client-id", "k8s", "The oidc client the tokens for the API are issued for")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "The kubeconfig file, when the API is not used (defaults to the standard locations)")
+	flag.StringVar(&namespace, "namespace", "", "The namespace of the LabInstances (defaults to the one of the user)")
+	flag.StringVar(&studentID, "student", "", "The student owning the LabInstances created through Kubernetes (defaults to the one of the tenant-<student> namespace)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] <command> [arguments]\n\n%v\nFlags:\n", os.Args[0], crownlabsctl.Usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	cli := &crownlabsctl.CLI{Out: os.Stdout, Namespace: namespace, Exec: crownlabsctl.ExecAttached}
+	if apiUrl != "" {
+		if issuer == "" {
+			exit(fmt.Errorf("the oidc provider url is required to use the API"))
+		}
+		home, _ := os.UserHomeDir()
+		device := &crownlabsctl.DeviceFlow{Issuer: issuer, ClientID: clientID, CachePath: filepath.Join(home, ".crownlabs", "token.json")}
+		if flag.Arg(0) == "login" {
+			_, err := device.Login(ctx)
+			exit(err)
+		}
+		cli.Backend = crownlabsctl.NewAPIBackend(&apiserver.Client{BaseURL: apiUrl, Token: device.Token})
+	} else {
+		backend, err := kubeBackend(kubeconfig, namespace, studentID)
+		if err != nil {
+			exit(err)
+		}
+		cli.Backend = backend
+	}
+
+	exit(cli.Run(ctx, flag.Args()))
+}
+
+// kubeBackend returns a backend managing the LabInstances through Kubernetes, with the credentials of the kubeconfig
+func kubeBackend(kubeconfig, namespace, studentID string) (crownlabsctl.Backend, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+	if studentID == "" {
+		studentID = strings.TrimPrefix(namespace, "tenant-")
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = crownlabsv1alpha1.AddToScheme(scheme)
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return crownlabsctl.NewKubeBackend(c, namespace, studentID), nil
+}
+
+func exit(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -17,17 +17,30 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/prometheus/common/log"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	virtv1 "kubevirt.io/client-go/api/v1"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/accounting"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/apiserver"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/config"
 	"github.com/netgroup-polito/CrownLabs/operators/pkg/controllers"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/courses"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/enrolment"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/exposure"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/keycloak"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/proxy"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/tracing"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -61,6 +74,27 @@ func main() {
 	var oauth2ProxyImage string
 	var oidcClientSecret string
 	var oidcProviderUrl string
+	var collectorImage string
+	var maxInstancesPerStudent int
+	var capacityAdmission bool
+	var priorityClasses string
+	var otlpEndpoint string
+	var maxVmiRetries int
+	var configFile string
+	var exposureKind string
+	var gateway string
+	var proxyAddr string
+	var apiAddr string
+	var oidcClientID string
+	var enrolmentSource string
+	var enrolmentTeachers string
+	var enrolmentStudents string
+	var enrolmentSyncPeriod time.Duration
+	var keycloakURL string
+	var keycloakRealm string
+	var keycloakClientID string
+	var keycloakClientSecret string
+	var keycloakGroups bool
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -74,13 +108,41 @@ func main() {
 	flag.StringVar(&oauth2ProxyImage, "oauth2-proxy-image", "", "The docker image used for the oauth2-proxy deployment")
 	flag.StringVar(&oidcClientSecret, "oidc-client-secret", "", "The oidc client secret used by oauth2-proxy")
 	flag.StringVar(&oidcProviderUrl, "oidc-provider-url", "", "The url of the oidc provider used by oauth2-proxy")
+	flag.StringVar(&collectorImage, "collector-image", "kroniak/ssh-client", "The docker image used to collect the work of the students (it requires ssh and tar)")
+	flag.IntVar(&maxInstancesPerStudent, "max-instances-per-student", 0, "The maximum number of LabInstances each student can own, including the ones shared with a team (0 means unlimited)")
+	flag.BoolVar(&capacityAdmission, "enable-capacity-admission", false, "Enable the admission of new LabInstances only when the cluster has enough free capacity, queueing them otherwise")
+	flag.StringVar(&priorityClasses, "priority-classes", "", "The PriorityClasses associated with the priority tiers of the instances, separated by a & "+
+		"(e.g. student=crownlabs-student&teacher=crownlabs-teacher&exam=crownlabs-exam)")
+	flag.IntVar(&maxVmiRetries, "max-vmi-retries", 2, "The number of times the failed VMs of each instance are automatically restarted")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "The OTLP/HTTP endpoint of the OpenTelemetry collector the traces of the creation of the instances are exported to "+
+		"(e.g. http://localhost:4318), empty to disable the tracing")
+	flag.StringVar(&configFile, "config", "", "The configuration file of the operator, which is reloaded when modified. The settings it specifies "+
+		"override the ones of the corresponding flags")
+	flag.StringVar(&exposureKind, "exposure", string(exposure.Auto), "The resources exposing the instances: ingress (networking.k8s.io/v1), ingress-v1beta1 (extensions/v1beta1), "+
+		"httproute (Gateway API), proxy (the reverse proxy of the operator) or auto, to select the first one served by the cluster among the Ingresses")
+	flag.StringVar(&proxyAddr, "proxy-bind-address", ":8000", "The address the reverse proxy exposing the instances binds to, when the proxy exposure is selected")
+	flag.StringVar(&apiAddr, "api-bind-address", "", "The address the API for the web frontend binds to, empty to disable the API")
+	flag.StringVar(&oidcClientID, "oidc-client-id", "k8s", "The oidc client used by oauth2-proxy, which the tokens authenticating the requests to the API are issued for")
+	flag.StringVar(&enrolmentSource, "enrolment-source", "", "The source the Tenants and the Enrolments are periodically synchronized from: csv (the files of the "+
+		"provisioning script) or keycloak (the groups of the courses), empty to disable the synchronization")
+	flag.StringVar(&enrolmentTeachers, "enrolment-teachers-csv", "", "The teachers.csv file the teachers are synchronized from, with the csv enrolment source")
+	flag.StringVar(&enrolmentStudents, "enrolment-students-csv", "", "The students.csv file the students are synchronized from, with the csv enrolment source")
+	flag.DurationVar(&enrolmentSyncPeriod, "enrolment-sync-period", 10*time.Minute, "The period the Tenants and the Enrolments are synchronized with")
+	flag.StringVar(&keycloakURL, "keycloak-url", "", "The URL of Keycloak (e.g. https://auth.crownlabs.polito.it/auth)")
+	flag.StringVar(&keycloakRealm, "keycloak-realm", "crownlabs", "The Keycloak realm of the users of CrownLabs")
+	flag.StringVar(&keycloakClientID, "keycloak-client-id", "", "The Keycloak client whose service account is used to invoke the admin API")
+	flag.StringVar(&keycloakClientSecret, "keycloak-client-secret", "", "The secret of the Keycloak client used to invoke the admin API")
+	flag.BoolVar(&keycloakGroups, "enable-keycloak-groups", false, "Provision the Keycloak groups of the Courses and of their members, mapped to the roles "+
+		"of the --oidc-client-id client, and restrict the access to the instances of the Courses to their owners and to the teachers")
+	flag.StringVar(&gateway, "gateway", "", "The Gateway the HTTPRoutes of the instances are attached to (namespace/name)")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
 		o.Development = true
 	}))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	restConfig := ctrl.GetConfigOrDie()
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		LeaderElection:         enableLeaderElection,
@@ -94,24 +156,160 @@ func main() {
 		os.Exit(1)
 	}
 	whiteListMap := parseMap(namespaceWhiteList)
+	priorityClassMap := map[crownlabsv1alpha1.PriorityTier]string{}
+	if priorityClasses != "" {
+		for tier, class := range parseMap(priorityClasses) {
+			priorityClassMap[crownlabsv1alpha1.PriorityTier(tier)] = class
+		}
+	}
+	exposer, err := exposure.New(exposure.Kind(exposureKind), discovery.NewDiscoveryClientForConfigOrDie(restConfig))
+	if err != nil {
+		setupLog.Error(err, "unable to select the resources exposing the instances")
+		os.Exit(1)
+	}
+	setupLog.Info("exposing the instances through " + string(exposer.Kind()))
+	if exposer.Kind() == exposure.Proxy {
+		if err = proxy.IndexRoutes(mgr.GetFieldIndexer()); err != nil {
+			setupLog.Error(err, "unable to index the routes of the reverse proxy")
+			os.Exit(1)
+		}
+		if err = mgr.Add(proxy.New(proxyAddr, mgr.GetClient(), ctrl.Log.WithName("proxy"))); err != nil {
+			setupLog.Error(err, "unable to add the reverse proxy")
+			os.Exit(1)
+		}
+	}
+	if apiAddr != "" {
+		verifier := apiserver.NewVerifier(oidcProviderUrl, oidcClientID)
+		if err = mgr.Add(apiserver.New(apiAddr, mgr.GetClient(), verifier, ctrl.Log.WithName("apiserver"))); err != nil {
+			setupLog.Error(err, "unable to add the API server")
+			os.Exit(1)
+		}
+	}
+	keycloakClient := &keycloak.Client{BaseURL: keycloakURL, Realm: keycloakRealm, ClientID: keycloakClientID, ClientSecret: keycloakClientSecret}
+	var courseGroups *courses.KeycloakGroups
+	if keycloakGroups {
+		courseGroups = &courses.KeycloakGroups{Client: keycloakClient, ClientID: oidcClientID}
+	}
+	if enrolmentSource != "" {
+		var source enrolment.Source
+		switch enrolmentSource {
+		case "csv":
+			source = &enrolment.CSVSource{Teachers: enrolmentTeachers, Students: enrolmentStudents}
+		case "keycloak":
+			// the groups provisioned from the Courses would be synchronized back into the Enrolments, which
+			// would never be removed, since the members of the groups are derived from the Enrolments themselves
+			if keycloakGroups {
+				setupLog.Error(fmt.Errorf("the keycloak enrolment source cannot be used with --enable-keycloak-groups"),
+					"unable to synchronize the enrolments")
+				os.Exit(1)
+			}
+			source = &enrolment.KeycloakSource{Client: keycloakClient}
+		default:
+			setupLog.Error(fmt.Errorf("unknown enrolment source %v", enrolmentSource), "unable to synchronize the enrolments")
+			os.Exit(1)
+		}
+		syncer := &enrolment.Syncer{Client: mgr.GetClient(), Source: source, Period: enrolmentSyncPeriod, Log: ctrl.Log.WithName("enrolment")}
+		if err = mgr.Add(syncer); err != nil {
+			setupLog.Error(err, "unable to add the enrolment synchronization")
+			os.Exit(1)
+		}
+	}
+	tracer := tracing.NewTracer(otlpEndpoint, "laboratory-operator", ctrl.Log.WithName("tracing"))
+	if tracer != nil {
+		if err = mgr.Add(tracer); err != nil {
+			setupLog.Error(err, "unable to add the tracer")
+			os.Exit(1)
+		}
+	}
+	var configWatcher *config.Watcher
+	if configFile != "" {
+		defaults := config.Default(config.Settings{
+			WebsiteBaseUrl:   websiteBaseUrl,
+			NextcloudBaseUrl: nextcloudBaseUrl,
+			WebdavSecretName: webdavSecret,
+			CollectorImage:   collectorImage,
+			Oauth2: config.Oauth2{
+				ProxyImage:   oauth2ProxyImage,
+				ClientID:     oidcClientID,
+				ClientSecret: oidcClientSecret,
+				ProviderUrl:  oidcProviderUrl,
+			},
+			MaxInstancesPerStudent: &maxInstancesPerStudent,
+			Readiness:              config.Readiness{MaxVmiRetries: &maxVmiRetries},
+			Ingress:                crownlabsv1alpha1.IngressSettings{Gateway: gateway},
+		}, whiteListMap)
+		if configWatcher, err = config.NewWatcher(configFile, defaults, ctrl.Log.WithName("config")); err != nil {
+			setupLog.Error(err, "unable to load the configuration file")
+			os.Exit(1)
+		}
+		if err = mgr.Add(configWatcher); err != nil {
+			setupLog.Error(err, "unable to watch the configuration file")
+			os.Exit(1)
+		}
+	}
 	log.Info("Reconciling only namespaces with the following labels: ")
 	if err = (&controllers.LabInstanceReconciler{
-		Client:             mgr.GetClient(),
-		Log:                ctrl.Log.WithName("controllers").WithName("LabInstance"),
-		Scheme:             mgr.GetScheme(),
-		EventsRecorder:     mgr.GetEventRecorderFor("LabInstanceOperator"),
-		NamespaceWhitelist: metav1.LabelSelector{MatchLabels: whiteListMap, MatchExpressions: []metav1.LabelSelectorRequirement{}},
-		NextcloudBaseUrl:   nextcloudBaseUrl,
-		WebsiteBaseUrl:     websiteBaseUrl,
-		WebdavSecretName:   webdavSecret,
-		Oauth2ProxyImage:   oauth2ProxyImage,
-		OidcClientSecret:   oidcClientSecret,
-		OidcProviderUrl:    oidcProviderUrl,
+		Client:                 mgr.GetClient(),
+		Log:                    ctrl.Log.WithName("controllers").WithName("LabInstance"),
+		Scheme:                 mgr.GetScheme(),
+		EventsRecorder:         mgr.GetEventRecorderFor("LabInstanceOperator"),
+		NamespaceWhitelist:     metav1.LabelSelector{MatchLabels: whiteListMap, MatchExpressions: []metav1.LabelSelectorRequirement{}},
+		NextcloudBaseUrl:       nextcloudBaseUrl,
+		WebsiteBaseUrl:         websiteBaseUrl,
+		WebdavSecretName:       webdavSecret,
+		Oauth2ProxyImage:       oauth2ProxyImage,
+		OidcClientID:           oidcClientID,
+		OidcClientSecret:       oidcClientSecret,
+		OidcProviderUrl:        oidcProviderUrl,
+		CollectorImage:         collectorImage,
+		MaxInstancesPerStudent: maxInstancesPerStudent,
+		CapacityAdmission:      capacityAdmission,
+		PriorityClasses:        priorityClassMap,
+		APIReader:              mgr.GetAPIReader(),
+		MaxVmiRetries:          maxVmiRetries,
+		Config:                 configWatcher,
+		Tracer:                 tracer,
+		Exposer:                exposer,
+		Gateway:                gateway,
+		CourseGroups:           keycloakGroups,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "LabInstance")
 		os.Exit(1)
 	}
+	if err = (&controllers.LabSessionReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("LabSession"),
+		Scheme:         mgr.GetScheme(),
+		EventsRecorder: mgr.GetEventRecorderFor("LabSessionOperator"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LabSession")
+		os.Exit(1)
+	}
+	if err = (&controllers.LabTemplateReconciler{
+		Client:          mgr.GetClient(),
+		Log:             ctrl.Log.WithName("controllers").WithName("LabTemplate"),
+		Scheme:          mgr.GetScheme(),
+		EventsRecorder:  mgr.GetEventRecorderFor("LabTemplateOperator"),
+		PriorityClasses: priorityClassMap,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LabTemplate")
+		os.Exit(1)
+	}
+	if err = (&controllers.CourseReconciler{
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("Course"),
+		Scheme:         mgr.GetScheme(),
+		EventsRecorder: mgr.GetEventRecorderFor("CourseOperator"),
+		Keycloak:       courseGroups,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Course")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
+
+	// The usage of the LabInstances is exposed as Prometheus metrics, while the reports are served by the API
+	metrics.Registry.MustRegister(accounting.NewCollector(mgr.GetClient()))
+	metrics.Registry.MustRegister(controllers.NewInstanceCollector(mgr.GetClient()))
 	// Add readiness probe
 	err = mgr.AddReadyzCheck("ready-ping", healthz.Ping)
 	if err != nil {
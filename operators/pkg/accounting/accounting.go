@@ -0,0 +1,135 @@
+// Package accounting records the resources consumed by the LabInstances, and aggregates them in reports
+// per student, course and template.
+package accounting
+
+import (
+	"sort"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/admission"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The keys the usage can be grouped by
+const (
+	GroupByStudent  = "student"
+	GroupByCourse   = "course"
+	GroupByTemplate = "template"
+)
+
+const gibibyte = 1 << 30
+
+// RecordName returns the name of the UsageRecord of the resources with the given name prefix of a LabInstance
+func RecordName(namespace string, name string) string {
+	return namespace + "." + name
+}
+
+// NewRecord creates the UsageRecord of a LabInstance, whose resources share the given name prefix, started at the given time.
+func NewRecord(labInstance crownlabsv1alpha1.LabInstance, template crownlabsv1alpha1.LabTemplate, name string, start time.Time) crownlabsv1alpha1.UsageRecord {
+	total := corev1.ResourceList{}
+	for _, requests := range admission.Demand(template) {
+		for resourceName, quantity := range requests {
+			sum := total[resourceName].DeepCopy()
+			sum.Add(quantity)
+			total[resourceName] = sum
+		}
+	}
+
+	record := crownlabsv1alpha1.UsageRecord{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   RecordName(labInstance.Namespace, name),
+			Labels: map[string]string{"instance-name": labInstance.Name, "instance-namespace": labInstance.Namespace},
+		},
+		Spec: crownlabsv1alpha1.UsageRecordSpec{
+			InstanceName:      labInstance.Name,
+			InstanceNamespace: labInstance.Namespace,
+			Students:          students(labInstance),
+			Course:            labInstance.Labels["course-name"],
+			TemplateName:      template.Name,
+			TemplateNamespace: template.Namespace,
+			VMs:               int32(len(instanceCreation.TemplateVms(template))),
+			CPU:               total[corev1.ResourceCPU],
+			Memory:            total[corev1.ResourceMemory],
+			StartTime:         metav1.NewTime(start),
+		},
+	}
+
+	return record
+}
+
+// Report is the aggregated usage of a student, course or template
+type Report struct {
+	Key            string  `json:"key"`
+	Instances      int     `json:"instances"`
+	Running        int     `json:"running"`
+	VMHours        float64 `json:"vmHours"`
+	CPUHours       float64 `json:"cpuHours"`
+	MemoryGiBHours float64 `json:"memoryGiBHours"`
+}
+
+// Aggregate groups the usage described by the given records by student, course or template. The records
+// still open are accounted until now.
+func Aggregate(records []crownlabsv1alpha1.UsageRecord, groupBy string, now time.Time) []Report {
+	reports := map[string]*Report{}
+	for _, record := range records {
+		end := now
+		if record.Spec.StopTime != nil {
+			end = record.Spec.StopTime.Time
+		}
+		hours := end.Sub(record.Spec.StartTime.Time).Hours()
+		if hours < 0 {
+			hours = 0
+		}
+
+		cpu, memory := record.Spec.CPU, record.Spec.Memory
+		for _, key := range keys(record, groupBy) {
+			report, ok := reports[key]
+			if !ok {
+				report = &Report{Key: key}
+				reports[key] = report
+			}
+			report.Instances++
+			if record.Spec.StopTime == nil {
+				report.Running++
+			}
+			report.VMHours += hours * float64(record.Spec.VMs)
+			report.CPUHours += hours * float64(cpu.MilliValue()) / 1000
+			report.MemoryGiBHours += hours * float64(memory.Value()) / gibibyte
+		}
+	}
+
+	result := make([]Report, 0, len(reports))
+	for _, report := range reports {
+		result = append(result, *report)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// keys returns the keys a record is accounted to, given the grouping criterion
+func keys(record crownlabsv1alpha1.UsageRecord, groupBy string) []string {
+	switch groupBy {
+	case GroupByCourse:
+		return []string{record.Spec.Course}
+	case GroupByTemplate:
+		return []string{record.Spec.TemplateNamespace + "/" + record.Spec.TemplateName}
+	default:
+		return record.Spec.Students
+	}
+}
+
+// students returns the students a LabInstance is accounted to
+func students(labInstance crownlabsv1alpha1.LabInstance) []string {
+	if team := labInstance.Status.Team; team != nil && len(team.Members) > 0 {
+		return team.Members
+	}
+	// the StudentID is set by the creator, hence it is trusted only if it refers to the owner of the namespace
+	if labInstance.Spec.StudentID != "" && instanceCreation.TenantName(labInstance.Spec.StudentID) == labInstance.Namespace {
+		return []string{labInstance.Spec.StudentID}
+	}
+	return []string{labInstance.Namespace}
+}
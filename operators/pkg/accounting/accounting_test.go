@@ -0,0 +1,89 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func record(students []string, course string, template string, cpu string, memory string, start time.Time, stop *time.Time) crownlabsv1alpha1.UsageRecord {
+	usage := crownlabsv1alpha1.UsageRecord{
+		Spec: crownlabsv1alpha1.UsageRecordSpec{
+			Students:          students,
+			Course:            course,
+			TemplateName:      template,
+			TemplateNamespace: "course-" + course,
+			VMs:               1,
+			CPU:               resource.MustParse(cpu),
+			Memory:            resource.MustParse(memory),
+			StartTime:         metav1.NewTime(start),
+		},
+	}
+	if stop != nil {
+		stopTime := metav1.NewTime(*stop)
+		usage.Spec.StopTime = &stopTime
+	}
+	return usage
+}
+
+func TestNewRecord(t *testing.T) {
+	template := crownlabsv1alpha1.LabTemplate{ObjectMeta: metav1.ObjectMeta{Name: "lab1", Namespace: "course-sdn"}}
+	template.Spec.Vms = []crownlabsv1alpha1.NamedVm{{Name: "a"}, {Name: "b"}}
+	template.Spec.Vms[0].Vm.Spec.Domain.Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1Gi")}
+	template.Spec.Vms[1].Vm.Spec.Domain.Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("2Gi")}
+
+	labInstance := crownlabsv1alpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{
+		Name: "instance", Namespace: "tenant-s123456", Labels: map[string]string{"course-name": "sdn"}}}
+	labInstance.Spec.StudentID = "s123456"
+
+	usage := NewRecord(labInstance, template, "lab1-abcd", time.Now())
+	cpu, memory := usage.Spec.CPU, usage.Spec.Memory
+	assert.Equal(t, usage.Name, "tenant-s123456.lab1-abcd")
+	assert.Equal(t, usage.Spec.Students, []string{"s123456"})
+	spoofed := *labInstance.DeepCopy()
+	spoofed.Spec.StudentID = "s654321"
+	assert.Equal(t, NewRecord(spoofed, template, "lab1-abcd", time.Now()).Spec.Students, []string{"tenant-s123456"},
+		"The usage should not be accounted to a StudentID not owning the namespace.")
+	assert.Equal(t, usage.Spec.Course, "sdn")
+	assert.Equal(t, usage.Spec.VMs, int32(2))
+	assert.Equal(t, cpu.MilliValue(), int64(1500), "The CPU requested by all the VMs should be summed.")
+	assert.Equal(t, memory.Cmp(resource.MustParse("3Gi")), 0, "The memory requested by all the VMs should be summed.")
+
+	labInstance.Status.Team = &crownlabsv1alpha1.TeamStatus{Name: "team", Members: []string{"s1", "s2"}}
+	usage = NewRecord(labInstance, template, "lab1-abcd", time.Now())
+	assert.Equal(t, usage.Spec.Students, []string{"s1", "s2"}, "The usage of a team should be accounted to all its members.")
+}
+
+func TestAggregate(t *testing.T) {
+	now := time.Now()
+	stop := now.Add(-time.Hour)
+	records := []crownlabsv1alpha1.UsageRecord{
+		record([]string{"s1"}, "sdn", "lab1", "2", "4Gi", now.Add(-3*time.Hour), &stop),
+		record([]string{"s1", "s2"}, "sdn", "lab2", "1", "1Gi", now.Add(-time.Hour), nil),
+		record([]string{"s3"}, "cloud", "lab1", "1", "2Gi", now.Add(-2*time.Hour), nil),
+	}
+
+	students := Aggregate(records, GroupByStudent, now)
+	assert.Equal(t, len(students), 3)
+	assert.Equal(t, students[0].Key, "s1")
+	assert.Equal(t, students[0].Instances, 2)
+	assert.Equal(t, students[0].Running, 1)
+	assert.InDelta(t, students[0].VMHours, 3, 0.001)
+	assert.InDelta(t, students[0].CPUHours, 5, 0.001)
+	assert.InDelta(t, students[0].MemoryGiBHours, 9, 0.001)
+	assert.InDelta(t, students[1].VMHours, 1, 0.001, "The usage of a team should be accounted to each member.")
+
+	courses := Aggregate(records, GroupByCourse, now)
+	assert.Equal(t, courses[0].Key, "cloud")
+	assert.InDelta(t, courses[1].CPUHours, 5, 0.001)
+
+	templates := Aggregate(records, GroupByTemplate, now)
+	assert.Equal(t, len(templates), 3, "The templates should be identified by namespace and name.")
+}
@@ -0,0 +1,85 @@
+package accounting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reports aggregates the usage described by the given records according to groupBy (student, course or template,
+// defaulting to student), restricting the reports to the given key, if not empty (e.g. a single student).
+func Reports(records []crownlabsv1alpha1.UsageRecord, groupBy string, key string, now time.Time) ([]Report, error) {
+	switch groupBy {
+	case "":
+		groupBy = GroupByStudent
+	case GroupByStudent, GroupByCourse, GroupByTemplate:
+	default:
+		return nil, fmt.Errorf("groupBy must be one of student, course and template")
+	}
+
+	reports := Aggregate(records, groupBy, now)
+	if key == "" {
+		return reports, nil
+	}
+	filtered := []Report{}
+	for _, report := range reports {
+		if report.Key == key {
+			filtered = append(filtered, report)
+		}
+	}
+	return filtered, nil
+}
+
+// Collector exposes the usage aggregated by course and template as Prometheus metrics
+type Collector struct {
+	reader      client.Reader
+	running     *prometheus.Desc
+	vmHours     *prometheus.Desc
+	cpuHours    *prometheus.Desc
+	memoryHours *prometheus.Desc
+}
+
+// NewCollector creates a collector computing the usage from the UsageRecords available through the given reader
+func NewCollector(reader client.Reader) *Collector {
+	labels := []string{"course", "template"}
+	return &Collector{
+		reader:      reader,
+		running:     prometheus.NewDesc("crownlabs_usage_running_instances", "The number of running LabInstances", labels, nil),
+		vmHours:     prometheus.NewDesc("crownlabs_usage_vm_hours", "The VM-hours consumed by the LabInstances", labels, nil),
+		cpuHours:    prometheus.NewDesc("crownlabs_usage_cpu_hours", "The CPU-hours requested by the LabInstances", labels, nil),
+		memoryHours: prometheus.NewDesc("crownlabs_usage_memory_gib_hours", "The memory GiB-hours requested by the LabInstances", labels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.running
+	ch <- c.vmHours
+	ch <- c.cpuHours
+	ch <- c.memoryHours
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	var records crownlabsv1alpha1.UsageRecordList
+	if err := c.reader.List(context.Background(), &records); err != nil {
+		return
+	}
+
+	courses := map[string]string{}
+	for _, record := range records.Items {
+		courses[record.Spec.TemplateNamespace+"/"+record.Spec.TemplateName] = record.Spec.Course
+	}
+	for _, report := range Aggregate(records.Items, GroupByTemplate, time.Now()) {
+		course := courses[report.Key]
+		ch <- prometheus.MustNewConstMetric(c.running, prometheus.GaugeValue, float64(report.Running), course, report.Key)
+		ch <- prometheus.MustNewConstMetric(c.vmHours, prometheus.CounterValue, report.VMHours, course, report.Key)
+		ch <- prometheus.MustNewConstMetric(c.cpuHours, prometheus.CounterValue, report.CPUHours, course, report.Key)
+		ch <- prometheus.MustNewConstMetric(c.memoryHours, prometheus.CounterValue, report.MemoryGiBHours, course, report.Key)
+	}
+}
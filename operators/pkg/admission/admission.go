@@ -0,0 +1,202 @@
+// Package admission estimates the free capacity of the cluster, to admit new LabInstances only
+// when their VMs can be scheduled, instead of leaving them pending indefinitely.
+package admission
+
+import (
+	"sort"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// admissionResources are the resources taken into account by the admission
+var admissionResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+// Capacity is the free capacity of each node of the cluster
+type Capacity map[string]corev1.ResourceList
+
+// FreeCapacity returns the resources of the schedulable nodes which are not requested by the given pods.
+func FreeCapacity(nodes []corev1.Node, pods []corev1.Pod) Capacity {
+	capacity := Capacity{}
+	for _, node := range nodes {
+		if node.Spec.Unschedulable || !nodeReady(node) {
+			continue
+		}
+		free := corev1.ResourceList{}
+		for _, name := range admissionResources {
+			free[name] = node.Status.Allocatable[name].DeepCopy()
+		}
+		capacity[node.Name] = free
+	}
+
+	for _, pod := range pods {
+		free, ok := capacity[pod.Spec.NodeName]
+		if !ok || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			subtract(free, container.Resources.Requests)
+		}
+	}
+	return capacity
+}
+
+// Demand returns the resources requested by the VMs of a LabTemplate. Each element must be placed on a
+// single node: the VMs of multi-VM laboratories with private networks are grouped in a single element,
+// since they are scheduled on the same node.
+func Demand(template crownlabsv1alpha1.LabTemplate) []corev1.ResourceList {
+	vms := template.Spec.Vms
+	if len(vms) == 0 {
+		vms = []crownlabsv1alpha1.NamedVm{{Vm: template.Spec.Vm}}
+	}
+
+	var demand []corev1.ResourceList
+	for _, vm := range vms {
+		requests := corev1.ResourceList{}
+		for _, name := range admissionResources {
+			if quantity, ok := vm.Vm.Spec.Domain.Resources.Requests[name]; ok {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+		// the memory of the guest is requested in case no explicit request is specified
+		if _, ok := requests[corev1.ResourceMemory]; !ok && vm.Vm.Spec.Domain.Memory != nil && vm.Vm.Spec.Domain.Memory.Guest != nil {
+			requests[corev1.ResourceMemory] = vm.Vm.Spec.Domain.Memory.Guest.DeepCopy()
+		}
+		demand = append(demand, requests)
+	}
+
+	if len(template.Spec.Networks) > 0 && len(demand) > 1 {
+		total := corev1.ResourceList{}
+		for _, requests := range demand {
+			add(total, requests)
+		}
+		demand = []corev1.ResourceList{total}
+	}
+	return demand
+}
+
+// Place reserves the capacity required by the given demand, if it can be satisfied. The largest requests are placed
+// first, each one on the node with the most free memory. It returns the nodes chosen for each element of the demand,
+// or false (leaving the capacity unchanged) if the demand cannot be satisfied.
+func (c Capacity) Place(demand []corev1.ResourceList) ([]string, bool) {
+	order := make([]int, len(demand))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		mi, mj := demand[order[i]][corev1.ResourceMemory], demand[order[j]][corev1.ResourceMemory]
+		return mi.Cmp(mj) > 0
+	})
+
+	nodes := make([]string, len(demand))
+	for _, i := range order {
+		node := c.bestFit(demand[i])
+		if node == "" {
+			c.Release(demand, nodes)
+			return nil, false
+		}
+		subtract(c[node], demand[i])
+		nodes[i] = node
+	}
+	return nodes, true
+}
+
+// Reserve subtracts the given demand from the capacity of the nodes it has been placed on.
+func (c Capacity) Reserve(demand []corev1.ResourceList, nodes []string) {
+	for i, node := range nodes {
+		if free, ok := c[node]; ok {
+			subtract(free, demand[i])
+		}
+	}
+}
+
+// Release gives back the capacity reserved for the given demand on the given nodes.
+func (c Capacity) Release(demand []corev1.ResourceList, nodes []string) {
+	for i, node := range nodes {
+		if free, ok := c[node]; ok {
+			add(free, demand[i])
+		}
+	}
+}
+
+// bestFit returns the node with the most free memory among the ones which can satisfy the requests
+func (c Capacity) bestFit(requests corev1.ResourceList) string {
+	best := ""
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		free := c[name]
+		if !fits(free, requests) {
+			continue
+		}
+		if best == "" {
+			best = name
+			continue
+		}
+		bestMemory, memory := c[best][corev1.ResourceMemory], free[corev1.ResourceMemory]
+		if memory.Cmp(bestMemory) > 0 {
+			best = name
+		}
+	}
+	return best
+}
+
+// SortQueue orders the queued LabInstances by decreasing priority (as given by the priorities map), the oldest first.
+func SortQueue(instances []crownlabsv1alpha1.LabInstance, priorities map[types.UID]int) {
+	sort.SliceStable(instances, func(i, j int) bool {
+		pi, pj := priorities[instances[i].UID], priorities[instances[j].UID]
+		if pi != pj {
+			return pi > pj
+		}
+		ti, tj := instances[i].CreationTimestamp, instances[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return instances[i].Namespace+"/"+instances[i].Name < instances[j].Namespace+"/"+instances[j].Name
+	})
+}
+
+func fits(free corev1.ResourceList, requests corev1.ResourceList) bool {
+	for name, quantity := range requests {
+		available := free[name]
+		if available.Cmp(quantity) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func add(list corev1.ResourceList, requests corev1.ResourceList) {
+	for _, name := range admissionResources {
+		if quantity, ok := requests[name]; ok {
+			total := list[name].DeepCopy()
+			total.Add(quantity)
+			list[name] = total
+		}
+	}
+}
+
+func subtract(list corev1.ResourceList, requests corev1.ResourceList) {
+	for _, name := range admissionResources {
+		if quantity, ok := requests[name]; ok {
+			total := list[name].DeepCopy()
+			total.Sub(quantity)
+			list[name] = total
+		}
+	}
+}
+
+func nodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package admission
+
+import (
+	"testing"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func node(name string, cpu string, memory string, ready bool) corev1.Node {
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+		},
+	}
+}
+
+func requests(cpu string, memory string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func TestFreeCapacity(t *testing.T) {
+	nodes := []corev1.Node{node("n1", "4", "8Gi", true), node("n2", "4", "8Gi", false)}
+	pods := []corev1.Pod{
+		{
+			Spec: corev1.PodSpec{
+				NodeName:   "n1",
+				Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: requests("1", "2Gi")}}},
+			},
+		},
+		{
+			Spec: corev1.PodSpec{
+				NodeName:   "n1",
+				Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: requests("1", "2Gi")}}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+		},
+	}
+
+	capacity := FreeCapacity(nodes, pods)
+	memory := capacity["n1"][corev1.ResourceMemory]
+
+	assert.Equal(t, len(capacity), 1, "Nodes not ready should be ignored.")
+	assert.Equal(t, memory.Cmp(resource.MustParse("6Gi")), 0, "The requests of the running pods should be subtracted.")
+}
+
+func TestPlace(t *testing.T) {
+	capacity := FreeCapacity([]corev1.Node{node("n1", "4", "8Gi", true), node("n2", "4", "4Gi", true)}, nil)
+
+	nodes, ok := capacity.Place([]corev1.ResourceList{requests("2", "6Gi"), requests("2", "4Gi")})
+	assert.Equal(t, ok, true, "The demand should be satisfied.")
+	assert.Equal(t, nodes, []string{"n1", "n2"}, "Each request should be placed on a node with enough capacity.")
+
+	_, ok = capacity.Place([]corev1.ResourceList{requests("1", "1Gi"), requests("1", "4Gi")})
+	assert.Equal(t, ok, false, "The demand should not be satisfied.")
+	memory := capacity["n1"][corev1.ResourceMemory]
+	assert.Equal(t, memory.Cmp(resource.MustParse("2Gi")), 0, "The capacity should be unchanged in case of failure.")
+
+	capacity.Release([]corev1.ResourceList{requests("2", "4Gi")}, []string{"n2"})
+	_, ok = capacity.Place([]corev1.ResourceList{requests("1", "4Gi")})
+	assert.Equal(t, ok, true, "The released capacity should be available.")
+}
+
+func TestDemand(t *testing.T) {
+	template := crownlabsv1alpha1.LabTemplate{}
+	template.Spec.Vms = []crownlabsv1alpha1.NamedVm{{Name: "a"}, {Name: "b"}}
+	template.Spec.Vms[0].Vm.Spec.Domain.Resources.Requests = requests("1", "2Gi")
+	template.Spec.Vms[1].Vm.Spec.Domain.Resources.Requests = requests("1", "1Gi")
+
+	assert.Equal(t, len(Demand(template)), 2, "VMs not interconnected should be placed independently.")
+
+	template.Spec.Networks = []crownlabsv1alpha1.LabNetwork{{Name: "lan"}}
+	demand := Demand(template)
+	memory := demand[0][corev1.ResourceMemory]
+	assert.Equal(t, len(demand), 1, "Interconnected VMs should be placed on the same node.")
+	assert.Equal(t, memory.Cmp(resource.MustParse("3Gi")), 0, "The requests of interconnected VMs should be summed.")
+}
+
+func TestSortQueue(t *testing.T) {
+	now := time.Now()
+	queue := []crownlabsv1alpha1.LabInstance{
+		{ObjectMeta: metav1.ObjectMeta{Name: "second", UID: "2", CreationTimestamp: metav1.NewTime(now)}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "first", UID: "1", CreationTimestamp: metav1.NewTime(now.Add(-time.Minute))}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "exam", UID: "3", CreationTimestamp: metav1.NewTime(now)}},
+	}
+
+	SortQueue(queue, map[types.UID]int{"3": 2})
+	assert.Equal(t, queue[0].Name, "exam", "The LabInstances with higher priority should be the first.")
+	assert.Equal(t, queue[1].Name, "first", "The oldest LabInstance should be the first.")
+}
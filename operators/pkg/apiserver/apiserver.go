@@ -0,0 +1,422 @@
+// Package apiserver implements the API served by the operator to the web frontend, so that the students manage
+// their instances without being granted access to the Kubernetes API. The users are authenticated through the
+// tokens issued by the same OIDC provider as oauth2-proxy, and they can only access the LabTemplates of their
+// courses and the LabInstances of their namespace (or of their teams), while the teachers can also access the
+// usage of the LabTemplates of their courses.
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/accounting"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/courses"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+)
+
+const (
+	// BasePath is the path prefix of the API
+	BasePath = "/api/v1"
+	// groupPrefix is the prefix of the groups of the users identifying the namespaces of their courses
+	groupPrefix = "kubernetes:"
+	// adminSuffix is the suffix of the groups of the teachers of a course
+	adminSuffix = "-admin"
+	// the timeout of the graceful shutdown of the server
+	shutdownTimeout = 5 * time.Second
+)
+
+// CreateInstanceRequest is the body of the requests creating a LabInstance
+type CreateInstanceRequest struct {
+	LabTemplateName      string `json:"labTemplateName"`
+	LabTemplateNamespace string `json:"labTemplateNamespace"`
+}
+
+// User is the authenticated user of a request
+type User struct {
+	StudentID string
+	// Namespace is the namespace the instances of the user are created in
+	Namespace string
+	// Courses are the namespaces of the courses the user is enrolled in or teaches
+	Courses []string
+	// Taught are the namespaces of the courses the user teaches
+	Taught []string
+}
+
+// Server serves the API, authenticating the requests through the given verifier
+type Server struct {
+	// Addr is the address the server listens on
+	Addr string
+	// PollPeriod is the period the changes of the LabInstances are checked with, while watching them
+	PollPeriod time.Duration
+
+	client   client.Client
+	verifier *Verifier
+	log      logr.Logger
+	mux      *http.ServeMux
+}
+
+// New returns a server listening on the given address, which manages the resources through the given client
+func New(addr string, c client.Client, verifier *Verifier, log logr.Logger) *Server {
+	s := &Server{
+		Addr:       addr,
+		PollPeriod: time.Second,
+		client:     c,
+		verifier:   verifier,
+		log:        log,
+		mux:        http.NewServeMux(),
+	}
+	s.mux.HandleFunc(BasePath+"/templates", s.authenticated(s.templates))
+	s.mux.HandleFunc(BasePath+"/instances", s.authenticated(s.instances))
+	s.mux.HandleFunc(BasePath+"/instances/", s.authenticated(s.instance))
+	s.mux.HandleFunc(BasePath+"/watch", s.authenticated(s.watch))
+	s.mux.HandleFunc(BasePath+"/usage", s.authenticated(s.usage))
+	return s
+}
+
+// NeedLeaderElection allows all the replicas of the operator to serve the requests
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the requests until the stop channel is closed
+func (s *Server) Start(stop <-chan struct{}) error {
+	server := &http.Server{Addr: s.Addr, Handler: s}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticated wraps a handler, which is invoked only for the requests carrying a valid bearer token. Since
+// browsers cannot set the headers of the event streams, the token can be passed as access_token query parameter.
+func (s *Server) authenticated(handler func(http.ResponseWriter, *http.Request, *User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("access_token")
+		}
+		if token == "" {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		claims, err := s.verifier.Verify(r.Context(), token)
+		if err != nil {
+			http.Error(w, "invalid bearer token: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		user := userFromClaims(claims)
+		// the users enrolled through the Courses and the Enrolments may not be members of the corresponding groups yet
+		enrolled, taught, err := courses.TenantCourses(r.Context(), s.client, user.Namespace)
+		if err != nil {
+			s.fail(w, "unable to retrieve the enrolments", err)
+			return
+		}
+		user.Courses = append(user.Courses, enrolled...)
+		user.Taught = append(user.Taught, taught...)
+		handler(w, r, user)
+	}
+}
+
+// userFromClaims returns the user identified by the claims of a token
+func userFromClaims(claims *Claims) *User {
+	user := &User{StudentID: claims.Username, Namespace: instanceCreation.TenantName(claims.Username)}
+	if len(claims.Namespace) > 0 && claims.Namespace[0] != "" {
+		user.Namespace = claims.Namespace[0]
+	}
+	for _, group := range claims.Groups {
+		if !strings.HasPrefix(group, groupPrefix) {
+			continue
+		}
+		namespace := strings.TrimPrefix(group, groupPrefix)
+		if strings.HasSuffix(namespace, adminSuffix) {
+			namespace = strings.TrimSuffix(namespace, adminSuffix)
+			user.Taught = append(user.Taught, namespace)
+		}
+		user.Courses = append(user.Courses, namespace)
+	}
+	return user
+}
+
+// enrolled returns whether the user can access the LabTemplates of the given namespace
+func (u *User) enrolled(namespace string) bool {
+	for _, course := range u.Courses {
+		if course == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// teaches returns whether the user is a teacher of the course of the given namespace
+func (u *User) teaches(namespace string) bool {
+	for _, course := range u.Taught {
+		if course == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// owns returns whether the user can access the given LabInstance, i.e. it belongs to the namespace of the user
+// or to a team the user is a member of (as resolved by the operator, since the labels can be set by the creator)
+func (u *User) owns(labInstance *crownlabsv1alpha1.LabInstance) bool {
+	if labInstance.Namespace == u.Namespace {
+		return true
+	}
+	if team := labInstance.Status.Team; team != nil {
+		for _, member := range team.Members {
+			if instanceCreation.TenantName(member) == u.Namespace {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// templates lists the LabTemplates of the courses of the user
+func (s *Server) templates(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := crownlabsv1alpha1.LabTemplateList{}
+	for _, namespace := range uniqueStrings(user.Courses) {
+		var templates crownlabsv1alpha1.LabTemplateList
+		if err := s.client.List(r.Context(), &templates, client.InNamespace(namespace)); err != nil {
+			s.fail(w, "unable to list the LabTemplates", err)
+			return
+		}
+		list.Items = append(list.Items, templates.Items...)
+	}
+	writeJSON(w, http.StatusOK, &list)
+}
+
+// instances lists the LabInstances of the user, or creates a new one
+func (s *Server) instances(w http.ResponseWriter, r *http.Request, user *User) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := s.listInstances(r.Context(), user)
+		if err != nil {
+			s.fail(w, "unable to list the LabInstances", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		s.createInstance(w, r, user)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// listInstances returns the LabInstances of the namespace of the user and the ones of the teams of the user
+func (s *Server) listInstances(ctx context.Context, user *User) (*crownlabsv1alpha1.LabInstanceList, error) {
+	var owned, shared crownlabsv1alpha1.LabInstanceList
+	if err := s.client.List(ctx, &owned, client.InNamespace(user.Namespace)); err != nil {
+		return nil, err
+	}
+	if err := s.client.List(ctx, &shared, client.MatchingLabels{instanceCreation.MemberLabelPrefix + user.Namespace: "true"}); err != nil {
+		return nil, err
+	}
+	for i := range shared.Items {
+		if shared.Items[i].Namespace != user.Namespace && user.owns(&shared.Items[i]) {
+			owned.Items = append(owned.Items, shared.Items[i])
+		}
+	}
+	return &owned, nil
+}
+
+// createInstance creates a LabInstance of a LabTemplate of the courses of the user, in the namespace of the user
+func (s *Server) createInstance(w http.ResponseWriter, r *http.Request, user *User) {
+	var request CreateInstanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.LabTemplateName == "" || request.LabTemplateNamespace == "" {
+		http.Error(w, "labTemplateName and labTemplateNamespace are required", http.StatusBadRequest)
+		return
+	}
+	if !user.enrolled(request.LabTemplateNamespace) {
+		http.Error(w, "you are not enrolled in the course of namespace "+request.LabTemplateNamespace, http.StatusForbidden)
+		return
+	}
+
+	var labTemplate crownlabsv1alpha1.LabTemplate
+	templateName := types.NamespacedName{Namespace: request.LabTemplateNamespace, Name: request.LabTemplateName}
+	if err := s.client.Get(r.Context(), templateName, &labTemplate); err != nil {
+		if errors.IsNotFound(err) {
+			http.Error(w, "LabTemplate "+request.LabTemplateName+" not found", http.StatusNotFound)
+		} else {
+			s.fail(w, "unable to retrieve the LabTemplate", err)
+		}
+		return
+	}
+
+	labInstance := NewInstance(&labTemplate, user.Namespace, user.StudentID)
+	if err := s.client.Create(r.Context(), &labInstance); err != nil {
+		s.fail(w, "unable to create the LabInstance", err)
+		return
+	}
+	s.log.Info("LabInstance " + labInstance.Name + " created by " + user.StudentID)
+	writeJSON(w, http.StatusCreated, &labInstance)
+}
+
+// NewInstance returns a LabInstance of the given LabTemplate, owned by the given student and named after both
+func NewInstance(labTemplate *crownlabsv1alpha1.LabTemplate, namespace, studentID string) crownlabsv1alpha1.LabInstance {
+	return crownlabsv1alpha1.LabInstance{
+		TypeMeta: metav1.TypeMeta{APIVersion: crownlabsv1alpha1.GroupVersion.String(), Kind: "LabInstance"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v-%v-%.4s", labTemplate.Name, strings.TrimPrefix(namespace, "tenant-"), uuid.New().String()),
+			Namespace: namespace,
+		},
+		Spec: crownlabsv1alpha1.LabInstanceSpec{
+			LabTemplateName:      labTemplate.Name,
+			LabTemplateNamespace: labTemplate.Namespace,
+			StudentID:            studentID,
+		},
+	}
+}
+
+// instance serves the requests concerning a single LabInstance: /instances/<name>[/start|/stop]. The LabInstances
+// of the teams of the user are addressed through the namespace query parameter.
+func (s *Server) instance(w http.ResponseWriter, r *http.Request, user *User) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, BasePath+"/instances/"), "/")
+	if len(path) > 2 || path[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = user.Namespace
+	}
+
+	var labInstance crownlabsv1alpha1.LabInstance
+	if err := s.client.Get(r.Context(), types.NamespacedName{Namespace: namespace, Name: path[0]}, &labInstance); err != nil {
+		if errors.IsNotFound(err) {
+			http.Error(w, "LabInstance "+path[0]+" not found", http.StatusNotFound)
+		} else {
+			s.fail(w, "unable to retrieve the LabInstance", err)
+		}
+		return
+	}
+	if !user.owns(&labInstance) {
+		// the LabInstances of the other users are not disclosed
+		http.Error(w, "LabInstance "+path[0]+" not found", http.StatusNotFound)
+		return
+	}
+
+	action := ""
+	if len(path) == 2 {
+		action = path[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, &labInstance)
+	case action == "" && r.Method == http.MethodDelete:
+		// the LabInstances of a team can be deleted only by the owner of the namespace
+		if labInstance.Namespace != user.Namespace {
+			http.Error(w, "only the owner can delete LabInstance "+labInstance.Name, http.StatusForbidden)
+			return
+		}
+		if err := s.client.Delete(r.Context(), &labInstance); err != nil && !errors.IsNotFound(err) {
+			s.fail(w, "unable to delete the LabInstance", err)
+			return
+		}
+		s.log.Info("LabInstance " + labInstance.Name + " deleted by " + user.StudentID)
+		w.WriteHeader(http.StatusNoContent)
+	case (action == "start" || action == "stop") && r.Method == http.MethodPost:
+		if instanceCreation.SetRunning(&labInstance, action == "start") {
+			if err := s.client.Update(r.Context(), &labInstance); err != nil {
+				s.fail(w, "unable to "+action+" the LabInstance", err)
+				return
+			}
+			s.log.Info("LabInstance " + labInstance.Name + " requested to " + action + " by " + user.StudentID)
+		}
+		writeJSON(w, http.StatusAccepted, &labInstance)
+	case action == "" || action == "start" || action == "stop":
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// usage reports the usage of the LabTemplates of the courses taught by the user, grouped according to the groupBy
+// query parameter (student, course or template) and possibly restricted to the one of the key query parameter
+func (s *Server) usage(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if len(user.Taught) == 0 {
+		http.Error(w, "only the teachers can access the usage of the courses", http.StatusForbidden)
+		return
+	}
+
+	var records crownlabsv1alpha1.UsageRecordList
+	if err := s.client.List(r.Context(), &records); err != nil {
+		s.fail(w, "unable to list the usage records", err)
+		return
+	}
+	var taught []crownlabsv1alpha1.UsageRecord
+	for i := range records.Items {
+		if user.teaches(records.Items[i].Spec.TemplateNamespace) {
+			taught = append(taught, records.Items[i])
+		}
+	}
+	reports, err := accounting.Reports(taught, r.URL.Query().Get("groupBy"), r.URL.Query().Get("key"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// fail reports an unexpected error in serving a request
+func (s *Server) fail(w http.ResponseWriter, msg string, err error) {
+	s.log.Error(err, msg)
+	status := http.StatusInternalServerError
+	if statusErr, ok := err.(errors.APIStatus); ok && statusErr.Status().Code != 0 {
+		status = int(statusErr.Status().Code)
+	}
+	http.Error(w, msg+": "+err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,270 @@
+package apiserver
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/accounting"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+)
+
+// provider is a mock OIDC provider, which publishes the key signing its tokens
+type provider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	p := &provider{key: key}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case discoveryPath:
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "jwks_uri": p.URL + "/certs"})
+		case "/certs":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "RSA", "kid": "key", "use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return p
+}
+
+func (p *provider) token(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *provider) userToken(t *testing.T, username string, groups ...string) string {
+	return p.token(t, map[string]interface{}{
+		"iss": p.URL, "azp": "k8s", "aud": "account", "exp": time.Now().Add(time.Hour).Unix(),
+		"preferred_username": username, "groups": groups,
+	})
+}
+
+func TestVerifier(t *testing.T) {
+	p := newProvider(t)
+	defer p.Close()
+	verifier := NewVerifier(p.URL+"/", "k8s")
+	ctx := context.Background()
+
+	claims, err := verifier.Verify(ctx, p.userToken(t, "s123456", "kubernetes:course-sdn"))
+	assert.NoError(t, err)
+	assert.Equal(t, claims.Username, "s123456")
+	assert.Equal(t, claims.Groups, []string{"kubernetes:course-sdn"})
+
+	valid := map[string]interface{}{"iss": p.URL, "aud": []string{"k8s"}, "exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "s1"}
+	_, err = verifier.Verify(ctx, p.token(t, valid))
+	assert.NoError(t, err, "The client should be accepted as audience of id tokens.")
+
+	for name, change := range map[string]func(map[string]interface{}){
+		"expired":      func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"issuer":       func(c map[string]interface{}) { c["iss"] = "https://attacker.example.com" },
+		"audience":     func(c map[string]interface{}) { c["aud"] = "other" },
+		"not yet":      func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"anonymous":    func(c map[string]interface{}) { delete(c, "preferred_username") },
+		"unsigned":     nil,
+		"wrong length": nil,
+	} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		token := p.token(t, claims)
+		switch name {
+		case "unsigned":
+			token = token[:strings.LastIndex(token, ".")+1]
+		case "wrong length":
+			token = strings.Join(strings.Split(token, ".")[:2], ".")
+		default:
+			change(claims)
+			token = p.token(t, claims)
+		}
+		_, err := verifier.Verify(ctx, token)
+		assert.Error(t, err, "The %v token should be rejected.", name)
+	}
+}
+
+func TestServer(t *testing.T) {
+	p := newProvider(t)
+	defer p.Close()
+
+	template := func(namespace, name string) runtime.Object {
+		return &crownlabsv1alpha1.LabTemplate{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	team := &crownlabsv1alpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-s654321", Name: "project",
+		Labels: map[string]string{instanceCreation.MemberLabelPrefix + "tenant-s123456": "true"}},
+		Status: crownlabsv1alpha1.LabInstanceStatus{Team: &crownlabsv1alpha1.TeamStatus{Name: "team", Members: []string{"s654321", "s123456"}}}}
+	spoofed := &crownlabsv1alpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-s654321", Name: "spoofed",
+		Labels: map[string]string{instanceCreation.MemberLabelPrefix + "tenant-s123456": "true"}}}
+	other := &crownlabsv1alpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-s654321", Name: "private"}}
+	c := fake.NewFakeClientWithScheme(scheme(t), template("course-sdn", "lab1"), template("course-cloud", "lab1"), team, spoofed, other)
+	server := New(":0", c, NewVerifier(p.URL, "k8s"), logr.Discard())
+	server.PollPeriod = 10 * time.Millisecond
+
+	token := p.userToken(t, "s123456", "kubernetes:course-sdn", "offline_access")
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	req := httptest.NewRequest(http.MethodGet, BasePath+"/templates", nil)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, recorder.Code, http.StatusUnauthorized, "The requests without a token should be rejected.")
+
+	resp := do(http.MethodGet, BasePath+"/templates", "")
+	var templates crownlabsv1alpha1.LabTemplateList
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&templates))
+	assert.Equal(t, len(templates.Items), 1, "Only the templates of the courses of the user should be listed.")
+	assert.Equal(t, templates.Items[0].Namespace, "course-sdn")
+
+	resp = do(http.MethodPost, BasePath+"/instances", `{"labTemplateName":"lab1","labTemplateNamespace":"course-cloud"}`)
+	assert.Equal(t, resp.Code, http.StatusForbidden, "The instances of the other courses should not be created.")
+	resp = do(http.MethodPost, BasePath+"/instances", `{"labTemplateName":"missing","labTemplateNamespace":"course-sdn"}`)
+	assert.Equal(t, resp.Code, http.StatusNotFound)
+	resp = do(http.MethodPost, BasePath+"/instances", `{"labTemplateName":"lab1","labTemplateNamespace":"course-sdn"}`)
+	assert.Equal(t, resp.Code, http.StatusCreated)
+	var created crownlabsv1alpha1.LabInstance
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, created.Namespace, "tenant-s123456")
+	assert.True(t, strings.HasPrefix(created.Name, "lab1-s123456-"))
+	assert.Equal(t, created.Spec.StudentID, "s123456")
+
+	resp = do(http.MethodGet, BasePath+"/instances", "")
+	var instances crownlabsv1alpha1.LabInstanceList
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&instances))
+	assert.Equal(t, len(instances.Items), 2, "The instances of the user and of their teams should be listed.")
+
+	resp = do(http.MethodGet, BasePath+"/instances/private?namespace=tenant-s654321", "")
+	assert.Equal(t, resp.Code, http.StatusNotFound, "The instances of the other users should not be disclosed.")
+	resp = do(http.MethodGet, BasePath+"/instances/spoofed?namespace=tenant-s654321", "")
+	assert.Equal(t, resp.Code, http.StatusNotFound, "The membership labels set by the creator should not be trusted.")
+	resp = do(http.MethodGet, BasePath+"/instances/project?namespace=tenant-s654321", "")
+	assert.Equal(t, resp.Code, http.StatusOK)
+	resp = do(http.MethodDelete, BasePath+"/instances/project?namespace=tenant-s654321", "")
+	assert.Equal(t, resp.Code, http.StatusForbidden, "Only the owner should delete the instances of a team.")
+
+	resp = do(http.MethodPost, BasePath+"/instances/"+created.Name+"/stop", "")
+	assert.Equal(t, resp.Code, http.StatusAccepted)
+	var stored crownlabsv1alpha1.LabInstance
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: created.Namespace, Name: created.Name}, &stored))
+	assert.True(t, instanceCreation.IsStopped(stored))
+	do(http.MethodPost, BasePath+"/instances/"+created.Name+"/start", "")
+	var started crownlabsv1alpha1.LabInstance
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: created.Namespace, Name: created.Name}, &started))
+	assert.False(t, instanceCreation.IsStopped(started))
+
+	// the users can access also the courses they are enrolled in through the Enrolments
+	assert.NoError(t, c.Create(context.Background(), &crownlabsv1alpha1.Enrolment{ObjectMeta: metav1.ObjectMeta{Name: "cloud-s123456"},
+		Spec: crownlabsv1alpha1.EnrolmentSpec{Tenant: "s123456", Course: "cloud", Role: crownlabsv1alpha1.RoleStudent}}))
+	resp = do(http.MethodGet, BasePath+"/templates", "")
+	var enrolled crownlabsv1alpha1.LabTemplateList
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&enrolled))
+	assert.Equal(t, len(enrolled.Items), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	watchReq, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+BasePath+"/watch?access_token="+token, nil)
+	watchResp, err := http.DefaultClient.Do(watchReq)
+	assert.NoError(t, err)
+	defer watchResp.Body.Close()
+	assert.Equal(t, watchResp.Header.Get("Content-Type"), "text/event-stream")
+	events := bufio.NewScanner(watchResp.Body)
+	var received []string
+	for events.Scan() && len(received) < 2 {
+		if line := events.Text(); strings.HasPrefix(line, "event: ") {
+			received = append(received, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	assert.Equal(t, received, []string{Added, Added}, "The existing instances should be streamed.")
+	resp = do(http.MethodDelete, BasePath+"/instances/"+created.Name, "")
+	assert.Equal(t, resp.Code, http.StatusNoContent)
+	for events.Scan() {
+		if line := events.Text(); strings.HasPrefix(line, "event: ") {
+			assert.Equal(t, line, "event: "+Deleted, "The deletion of the instance should be streamed.")
+			break
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	p := newProvider(t)
+	defer p.Close()
+
+	record := func(name, namespace string, students ...string) runtime.Object {
+		return &crownlabsv1alpha1.UsageRecord{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: crownlabsv1alpha1.UsageRecordSpec{
+			Students: students, TemplateName: "lab1", TemplateNamespace: namespace, VMs: 1,
+			StartTime: metav1.NewTime(time.Now().Add(-time.Hour))}}
+	}
+	c := fake.NewFakeClientWithScheme(scheme(t), record("a", "course-sdn", "s123456"),
+		record("b", "course-sdn", "s654321"), record("c", "course-cloud", "s123456"))
+	server := New(":0", c, NewVerifier(p.URL, "k8s"), logr.Discard())
+	get := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, BasePath+"/usage"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	student := p.userToken(t, "s123456", "kubernetes:course-sdn")
+	assert.Equal(t, get(student, "").Code, http.StatusForbidden, "Only the teachers should access the usage.")
+
+	teacher := p.userToken(t, "william.brown", "kubernetes:course-sdn-admin")
+	resp := get(teacher, "?groupBy=template")
+	assert.Equal(t, resp.Code, http.StatusOK)
+	var reports []accounting.Report
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&reports))
+	assert.Len(t, reports, 1, "Only the usage of the courses of the teacher should be reported.")
+	assert.Equal(t, reports[0].Key, "course-sdn/lab1")
+	assert.Equal(t, reports[0].Instances, 2)
+
+	resp = get(teacher, "?key=s123456")
+	reports = nil
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&reports))
+	assert.Len(t, reports, 1)
+	assert.Equal(t, reports[0].Instances, 1, "The usage of the other courses of the student should not be disclosed.")
+	assert.Equal(t, get(teacher, "?groupBy=tenant").Code, http.StatusBadRequest)
+}
+
+func scheme(t *testing.T) *runtime.Scheme {
+	s := runtime.NewScheme()
+	assert.NoError(t, crownlabsv1alpha1.AddToScheme(s))
+	return s
+}
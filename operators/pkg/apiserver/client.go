@@ -0,0 +1,162 @@
+package apiserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// Client invokes the API of the operator on behalf of a user
+type Client struct {
+	// BaseURL is the URL the API is served at (e.g. https://crownlabs.polito.it/operator), without the /api/v1 prefix
+	BaseURL string
+	// Token returns the bearer token authenticating the requests
+	Token func(ctx context.Context) (string, error)
+	HTTP  *http.Client
+}
+
+// Templates lists the LabTemplates of the courses of the user
+func (c *Client) Templates(ctx context.Context) ([]crownlabsv1alpha1.LabTemplate, error) {
+	var list crownlabsv1alpha1.LabTemplateList
+	err := c.do(ctx, http.MethodGet, "/templates", nil, nil, &list)
+	return list.Items, err
+}
+
+// Instances lists the LabInstances of the user, including the ones of the teams of the user
+func (c *Client) Instances(ctx context.Context) ([]crownlabsv1alpha1.LabInstance, error) {
+	var list crownlabsv1alpha1.LabInstanceList
+	err := c.do(ctx, http.MethodGet, "/instances", nil, nil, &list)
+	return list.Items, err
+}
+
+// Instance retrieves a LabInstance. The namespace can be empty for the LabInstances of the namespace of the user.
+func (c *Client) Instance(ctx context.Context, namespace, name string) (*crownlabsv1alpha1.LabInstance, error) {
+	var labInstance crownlabsv1alpha1.LabInstance
+	err := c.do(ctx, http.MethodGet, "/instances/"+url.PathEscape(name), namespaceQuery(namespace), nil, &labInstance)
+	return &labInstance, err
+}
+
+// CreateInstance creates a LabInstance of the given LabTemplate in the namespace of the user
+func (c *Client) CreateInstance(ctx context.Context, templateNamespace, templateName string) (*crownlabsv1alpha1.LabInstance, error) {
+	var labInstance crownlabsv1alpha1.LabInstance
+	request := CreateInstanceRequest{LabTemplateName: templateName, LabTemplateNamespace: templateNamespace}
+	err := c.do(ctx, http.MethodPost, "/instances", nil, &request, &labInstance)
+	return &labInstance, err
+}
+
+// DeleteInstance deletes a LabInstance of the namespace of the user
+func (c *Client) DeleteInstance(ctx context.Context, namespace, name string) error {
+	return c.do(ctx, http.MethodDelete, "/instances/"+url.PathEscape(name), namespaceQuery(namespace), nil, nil)
+}
+
+// SetRunning starts or stops the VMs of a LabInstance
+func (c *Client) SetRunning(ctx context.Context, namespace, name string, running bool) error {
+	action := "/stop"
+	if running {
+		action = "/start"
+	}
+	return c.do(ctx, http.MethodPost, "/instances/"+url.PathEscape(name)+action, namespaceQuery(namespace), nil, nil)
+}
+
+// Watch invokes the handler for each change of the LabInstances of the user, until the context is cancelled,
+// the stream is closed or the handler returns an error
+func (c *Client) Watch(ctx context.Context, handler func(WatchEvent) error) error {
+	resp, err := c.request(ctx, http.MethodGet, "/watch", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	// the events carry whole LabInstances
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event WatchEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			return fmt.Errorf("malformed event: %v", err)
+		}
+		if err := handler(event); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// request sends an authenticated request, returning an error in case of unsuccessful status
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := strings.TrimSuffix(c.BaseURL, "/") + BasePath + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token, err := c.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%v %v: %v", method, path, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func namespaceQuery(namespace string) url.Values {
+	if namespace == "" {
+		return nil
+	}
+	return url.Values{"namespace": {namespace}}
+}
@@ -0,0 +1,226 @@
+package apiserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// discoveryPath is the path of the discovery document of the OIDC provider, relative to the issuer
+	discoveryPath = "/.well-known/openid-configuration"
+	// keysRefreshPeriod is the minimum time between two retrievals of the signing keys of the provider
+	keysRefreshPeriod = 10 * time.Second
+)
+
+// Claims are the claims of the tokens issued by Keycloak which identify a CrownLabs user
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          int64    `json:"exp"`
+	NotBefore       int64    `json:"nbf"`
+	Username        string   `json:"preferred_username"`
+	// Groups are the groups of the user: kubernetes:<namespace> for the courses the user is enrolled in,
+	// and kubernetes:<namespace>-admin for the ones the user teaches.
+	Groups []string `json:"groups"`
+	// Namespace contains the namespace the instances of the user are created in
+	Namespace []string `json:"namespace"`
+}
+
+// audience is the aud claim, which is either a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// Verifier validates the tokens issued by an OIDC provider (i.e. the Keycloak realm used by oauth2-proxy) for the
+// given client. Only the RS256 signatures are supported, verified with the keys published by the provider.
+type Verifier struct {
+	Issuer   string
+	ClientID string
+	Client   *http.Client
+
+	lock    sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// NewVerifier returns a verifier of the tokens issued by the given provider (e.g. https://auth.crownlabs.polito.it/auth/realms/crownlabs)
+func NewVerifier(issuer, clientID string) *Verifier {
+	return &Verifier{
+		Issuer:   strings.TrimSuffix(issuer, "/"),
+		ClientID: clientID,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Verify checks the signature, the issuer, the audience and the validity period of the token, and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	key, err := v.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != v.Issuer {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if !claims.hasAudience(v.ClientID) {
+		return nil, fmt.Errorf("the token has not been issued for client %q", v.ClientID)
+	}
+	now := time.Now().Unix()
+	if claims.Expiry <= now {
+		return nil, fmt.Errorf("the token is expired")
+	}
+	if claims.NotBefore > now {
+		return nil, fmt.Errorf("the token is not valid yet")
+	}
+	if claims.Username == "" {
+		return nil, fmt.Errorf("the token does not identify the user")
+	}
+	return &claims, nil
+}
+
+// hasAudience returns whether the token has been issued for the given client. Keycloak reports the client
+// in the azp claim of the access tokens, and in the aud claim of the id tokens.
+func (c *Claims) hasAudience(clientID string) bool {
+	if c.AuthorizedParty == clientID {
+		return true
+	}
+	for _, aud := range c.Audience {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the signing key with the given ID, retrieving the keys of the provider again if it is not known
+func (v *Verifier) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if key, ok := v.keys[keyID]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < keysRefreshPeriod {
+		return nil, fmt.Errorf("unknown signing key %q", keyID)
+	}
+	v.fetched = time.Now()
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the signing keys: %v", err)
+	}
+	v.keys = keys
+	if key, ok := v.keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", keyID)
+}
+
+// fetchKeys retrieves the RSA signing keys published by the provider, through its discovery document
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var discovery struct {
+		JwksURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(ctx, v.Issuer+discoveryPath, &discovery); err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(ctx, discovery.JwksURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v from %v", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,93 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// The types of the watch events, as in the Kubernetes watch API
+const (
+	Added    = "ADDED"
+	Modified = "MODIFIED"
+	Deleted  = "DELETED"
+)
+
+// WatchEvent is a change of a LabInstance of the user, sent as data of the server-sent events
+type WatchEvent struct {
+	Type   string                        `json:"type"`
+	Object crownlabsv1alpha1.LabInstance `json:"object"`
+}
+
+// watch streams the changes of the LabInstances of the user as server-sent events, starting with an ADDED event for
+// each existing LabInstance. The LabInstances are read from the cache of the operator, hence they are polled.
+func (s *Server) watch(w http.ResponseWriter, r *http.Request, user *User) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	known := map[string]crownlabsv1alpha1.LabInstance{}
+	ticker := time.NewTicker(s.PollPeriod)
+	defer ticker.Stop()
+	for {
+		list, err := s.listInstances(r.Context(), user)
+		if err != nil {
+			s.log.Error(err, "unable to list the LabInstances of "+user.StudentID)
+		} else {
+			current := map[string]crownlabsv1alpha1.LabInstance{}
+			for _, labInstance := range list.Items {
+				key := labInstance.Namespace + "/" + labInstance.Name
+				current[key] = labInstance
+				previous, found := known[key]
+				switch {
+				case !found:
+					err = writeEvent(w, Added, labInstance)
+				case previous.ResourceVersion != labInstance.ResourceVersion:
+					err = writeEvent(w, Modified, labInstance)
+				}
+				if err != nil {
+					return
+				}
+			}
+			for key, labInstance := range known {
+				if _, found := current[key]; !found {
+					if err = writeEvent(w, Deleted, labInstance); err != nil {
+						return
+					}
+				}
+			}
+			known = current
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, eventType string, labInstance crownlabsv1alpha1.LabInstance) error {
+	data, err := json.Marshal(WatchEvent{Type: eventType, Object: labInstance})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %v\ndata: %s\n\n", eventType, data)
+	return err
+}
@@ -0,0 +1,217 @@
+// Package config defines the configuration file of the laboratory operator, which is watched and
+// reloaded at runtime, so that the settings can be changed without restarting the operator.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion and Kind identify the supported version of the configuration schema
+	APIVersion = "crownlabs.polito.it/v1alpha1"
+	Kind       = "OperatorConfig"
+)
+
+var courseNameRegexp = regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+
+// Oauth2 configures the oauth2-proxy protecting the instances
+type Oauth2 struct {
+	ProxyImage   string `json:"proxyImage,omitempty"`
+	ClientID     string `json:"clientID,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	ProviderUrl  string `json:"providerUrl,omitempty"`
+}
+
+// Readiness configures how the readiness of the VMs is checked, and how their failures are handled
+type Readiness struct {
+	// MaxVmiRetries is the number of times the failed VMs of each instance are automatically restarted
+	MaxVmiRetries *int `json:"maxVmiRetries,omitempty"`
+	// DefaultChecks are the readiness checks of the LabTemplates which do not specify them
+	DefaultChecks []crownlabsv1alpha1.ReadinessCheck `json:"defaultChecks,omitempty"`
+}
+
+// Settings are the settings which can be overridden for each course
+type Settings struct {
+	WebsiteBaseUrl   string `json:"websiteBaseUrl,omitempty"`
+	NextcloudBaseUrl string `json:"nextcloudBaseUrl,omitempty"`
+	WebdavSecretName string `json:"webdavSecretName,omitempty"`
+	CollectorImage   string `json:"collectorImage,omitempty"`
+	Oauth2           Oauth2 `json:"oauth2,omitempty"`
+	// MaxInstancesPerStudent limits the LabInstances each student is accounted to (0 means unlimited)
+	MaxInstancesPerStudent *int      `json:"maxInstancesPerStudent,omitempty"`
+	Readiness              Readiness `json:"readiness,omitempty"`
+	// Ingress configures how the instances are exposed, unless overridden by the LabTemplates or their namespaces
+	Ingress crownlabsv1alpha1.IngressSettings `json:"ingress,omitempty"`
+}
+
+// Config is the configuration of the operator
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Settings   `json:",inline"`
+	// NamespaceWhitelist are the labels identifying the namespaces whose LabInstances are reconciled
+	NamespaceWhitelist map[string]string `json:"namespaceWhitelist,omitempty"`
+	// Courses override the settings for the LabInstances of each course, identified by the course-name label
+	Courses map[string]Settings `json:"courses,omitempty"`
+}
+
+// Default returns a configuration with the given settings and whitelist (e.g. the ones specified through the
+// command line flags), which are used as defaults by Load.
+func Default(settings Settings, namespaceWhitelist map[string]string) Config {
+	config := Config{APIVersion: APIVersion, Kind: Kind, Settings: settings, NamespaceWhitelist: namespaceWhitelist}
+	if config.MaxInstancesPerStudent == nil {
+		config.MaxInstancesPerStudent = intPtr(0)
+	}
+	if config.Readiness.MaxVmiRetries == nil {
+		config.Readiness.MaxVmiRetries = intPtr(2)
+	}
+	return config
+}
+
+// Load reads and validates the configuration file at the given path. The settings not specified
+// by the file are taken from the given defaults.
+func Load(path string, defaults Config) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return Parse(data, defaults)
+}
+
+// Parse parses and validates a configuration. The settings not specified are taken from the given defaults.
+func Parse(data []byte, defaults Config) (Config, error) {
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	config.Settings = config.Settings.Override(defaults.Settings, true)
+	if config.NamespaceWhitelist == nil {
+		config.NamespaceWhitelist = defaults.NamespaceWhitelist
+	}
+	return config, nil
+}
+
+// Validate verifies that the configuration is consistent
+func (c *Config) Validate() error {
+	if c.APIVersion != APIVersion || c.Kind != Kind {
+		return fmt.Errorf("unsupported configuration %v/%v, expected %v/%v", c.APIVersion, c.Kind, APIVersion, Kind)
+	}
+	if err := c.Settings.validate(""); err != nil {
+		return err
+	}
+	for course, settings := range c.Courses {
+		if !courseNameRegexp.MatchString(course) {
+			return fmt.Errorf("invalid course name %q: it must match the course-name label of the LabInstances", course)
+		}
+		if err := settings.validate("courses." + course + "."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Settings) validate(prefix string) error {
+	for field, value := range map[string]string{"nextcloudBaseUrl": s.NextcloudBaseUrl, "oauth2.providerUrl": s.Oauth2.ProviderUrl} {
+		if value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid %v%v %q: an http or https URL is required", prefix, field, value)
+		}
+	}
+	if s.WebsiteBaseUrl != "" {
+		if parsed, err := url.Parse("https://" + s.WebsiteBaseUrl); err != nil || parsed.Host != s.WebsiteBaseUrl {
+			return fmt.Errorf("invalid %vwebsiteBaseUrl %q: a host name is required", prefix, s.WebsiteBaseUrl)
+		}
+	}
+	if s.Ingress.Host != "" {
+		if parsed, err := url.Parse("https://" + s.Ingress.Host); err != nil || parsed.Host != s.Ingress.Host {
+			return fmt.Errorf("invalid %vingress.host %q: a host name is required", prefix, s.Ingress.Host)
+		}
+	}
+	switch s.Ingress.Mode {
+	case "", crownlabsv1alpha1.IngressPathMode, crownlabsv1alpha1.IngressSubdomainMode:
+	default:
+		return fmt.Errorf("invalid %vingress.mode %q: path or subdomain is required", prefix, s.Ingress.Mode)
+	}
+	if s.MaxInstancesPerStudent != nil && *s.MaxInstancesPerStudent < 0 {
+		return fmt.Errorf("invalid %vmaxInstancesPerStudent: it cannot be negative", prefix)
+	}
+	if s.Readiness.MaxVmiRetries != nil && *s.Readiness.MaxVmiRetries < 0 {
+		return fmt.Errorf("invalid %vreadiness.maxVmiRetries: it cannot be negative", prefix)
+	}
+	for i, check := range s.Readiness.DefaultChecks {
+		switch check.Type {
+		case crownlabsv1alpha1.ReadinessTCP, crownlabsv1alpha1.ReadinessHTTP, crownlabsv1alpha1.ReadinessSSH,
+			crownlabsv1alpha1.ReadinessGuestAgent, crownlabsv1alpha1.ReadinessKubeVirt:
+		default:
+			return fmt.Errorf("invalid %vreadiness.defaultChecks[%v]: unknown type %q", prefix, i, check.Type)
+		}
+		if check.TimeoutSeconds < 0 || check.PeriodSeconds < 0 || check.FailureThreshold < 0 || check.InitialDelaySeconds < 0 {
+			return fmt.Errorf("invalid %vreadiness.defaultChecks[%v]: negative durations are not allowed", prefix, i)
+		}
+	}
+	return nil
+}
+
+// ForCourse returns the settings of the given course, i.e. the ones of the operator overridden by the ones of the course
+func (c *Config) ForCourse(course string) Settings {
+	if overrides, ok := c.Courses[course]; ok && course != "" {
+		return c.Settings.Override(overrides, false)
+	}
+	return c.Settings
+}
+
+// Override returns the settings overridden by the specified fields of the given ones. If onlyMissing is true,
+// only the fields not specified by the receiver are taken from the given settings.
+func (s Settings) Override(o Settings, onlyMissing bool) Settings {
+	str := func(current *string, value string) {
+		if value != "" && (!onlyMissing || *current == "") {
+			*current = value
+		}
+	}
+	integer := func(current **int, value *int) {
+		if value != nil && (!onlyMissing || *current == nil) {
+			*current = value
+		}
+	}
+
+	str(&s.WebsiteBaseUrl, o.WebsiteBaseUrl)
+	str(&s.NextcloudBaseUrl, o.NextcloudBaseUrl)
+	str(&s.WebdavSecretName, o.WebdavSecretName)
+	str(&s.CollectorImage, o.CollectorImage)
+	str(&s.Oauth2.ProxyImage, o.Oauth2.ProxyImage)
+	str(&s.Oauth2.ClientID, o.Oauth2.ClientID)
+	str(&s.Oauth2.ClientSecret, o.Oauth2.ClientSecret)
+	str(&s.Oauth2.ProviderUrl, o.Oauth2.ProviderUrl)
+	str(&s.Ingress.Host, o.Ingress.Host)
+	str((*string)(&s.Ingress.Mode), string(o.Ingress.Mode))
+	str(&s.Ingress.TLSSecretName, o.Ingress.TLSSecretName)
+	str(&s.Ingress.ClusterIssuer, o.Ingress.ClusterIssuer)
+	str(&s.Ingress.IngressClass, o.Ingress.IngressClass)
+	str(&s.Ingress.Gateway, o.Ingress.Gateway)
+	if len(o.Ingress.Annotations) > 0 && (!onlyMissing || len(s.Ingress.Annotations) == 0) {
+		s.Ingress.Annotations = o.Ingress.Annotations
+	}
+	integer(&s.MaxInstancesPerStudent, o.MaxInstancesPerStudent)
+	integer(&s.Readiness.MaxVmiRetries, o.Readiness.MaxVmiRetries)
+	if len(o.Readiness.DefaultChecks) > 0 && (!onlyMissing || len(s.Readiness.DefaultChecks) == 0) {
+		s.Readiness.DefaultChecks = o.Readiness.DefaultChecks
+	}
+	return s
+}
+
+func intPtr(value int) *int {
+	return &value
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func defaults() Config {
+	return Default(Settings{
+		WebsiteBaseUrl:   "crownlabs.polito.it",
+		NextcloudBaseUrl: "https://nextcloud.example.com",
+		WebdavSecretName: "webdav",
+		Oauth2:           Oauth2{ProxyImage: "oauth2-proxy", ClientID: "k8s", ClientSecret: "secret", ProviderUrl: "https://auth.example.com"},
+	}, map[string]string{"production": "true"})
+}
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(`
+apiVersion: crownlabs.polito.it/v1alpha1
+kind: OperatorConfig
+websiteBaseUrl: labs.example.com
+ingress:
+  ingressClass: nginx
+readiness:
+  maxVmiRetries: 1
+courses:
+  computer-networks:
+    nextcloudBaseUrl: https://drive.example.com
+    maxInstancesPerStudent: 3
+    ingress:
+      host: networks.labs.example.com
+    readiness:
+      defaultChecks:
+      - type: ssh
+`), defaults())
+	assert.NoError(t, err)
+
+	assert.Equal(t, config.WebsiteBaseUrl, "labs.example.com", "The settings of the file should override the defaults.")
+	assert.Equal(t, config.WebdavSecretName, "webdav", "The settings not specified should be taken from the defaults.")
+	assert.Equal(t, config.Oauth2.ClientID, "k8s")
+	assert.Equal(t, config.Oauth2.ClientSecret, "secret")
+	assert.Equal(t, *config.Readiness.MaxVmiRetries, 1)
+	assert.Equal(t, *config.MaxInstancesPerStudent, 0)
+	assert.Equal(t, config.NamespaceWhitelist, map[string]string{"production": "true"})
+
+	course := config.ForCourse("computer-networks")
+	assert.Equal(t, course.NextcloudBaseUrl, "https://drive.example.com", "The settings of the course should override the ones of the operator.")
+	assert.Equal(t, course.WebsiteBaseUrl, "labs.example.com")
+	assert.Equal(t, *course.MaxInstancesPerStudent, 3)
+	assert.Equal(t, *course.Readiness.MaxVmiRetries, 1)
+	assert.Equal(t, course.Readiness.DefaultChecks[0].Type, crownlabsv1alpha1.ReadinessSSH)
+	assert.Equal(t, course.Ingress, crownlabsv1alpha1.IngressSettings{Host: "networks.labs.example.com", IngressClass: "nginx"})
+
+	other := config.ForCourse("other")
+	assert.Equal(t, other.NextcloudBaseUrl, "https://nextcloud.example.com")
+}
+
+func TestValidate(t *testing.T) {
+	header := "apiVersion: crownlabs.polito.it/v1alpha1\nkind: OperatorConfig\n"
+	invalid := map[string]string{
+		"version":      "apiVersion: crownlabs.polito.it/v2\nkind: OperatorConfig\n",
+		"unknown":      header + "websiteUrl: labs.example.com\n",
+		"website":      header + "websiteBaseUrl: https://labs.example.com\n",
+		"nextcloud":    header + "nextcloudBaseUrl: nextcloud\n",
+		"quota":        header + "maxInstancesPerStudent: -1\n",
+		"ingress":      header + "ingress:\n  host: labs.example.com/path\n",
+		"check":        header + "readiness:\n  defaultChecks:\n  - type: icmp\n",
+		"course":       header + "courses:\n  Computer Networks: {}\n",
+		"course field": header + "courses:\n  networks:\n    oauth2:\n      providerUrl: auth\n",
+	}
+	for name, data := range invalid {
+		_, err := Parse([]byte(data), defaults())
+		assert.Error(t, err, name)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	header := "apiVersion: crownlabs.polito.it/v1alpha1\nkind: OperatorConfig\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(header+"websiteBaseUrl: first.example.com\n"), 0644))
+
+	watcher, err := NewWatcher(path, defaults(), ctrl.Log)
+	assert.NoError(t, err)
+	assert.Equal(t, watcher.Get().WebsiteBaseUrl, "first.example.com")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { _ = watcher.Start(stop) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// invalid configurations are discarded
+	assert.NoError(t, ioutil.WriteFile(path, []byte("kind: Unknown\n"), 0644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, watcher.Get().WebsiteBaseUrl, "first.example.com")
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(header+"websiteBaseUrl: second.example.com\n"), 0644))
+	assert.Eventually(t, func() bool { return watcher.Get().WebsiteBaseUrl == "second.example.com" },
+		5*time.Second, 50*time.Millisecond, "The configuration should be reloaded when modified.")
+}
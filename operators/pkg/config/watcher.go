@@ -0,0 +1,80 @@
+package config
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+)
+
+// Watcher keeps the configuration up to date with the content of the configuration file. The directory of
+// the file is watched, since ConfigMaps mounted as volumes are updated by replacing a symbolic link.
+type Watcher struct {
+	path     string
+	defaults Config
+	log      logr.Logger
+
+	lock   sync.RWMutex
+	config Config
+}
+
+// NewWatcher loads the configuration file at the given path, returning an error if it is not valid. The settings
+// not specified by the file are taken from the given defaults.
+func NewWatcher(path string, defaults Config, log logr.Logger) (*Watcher, error) {
+	config, err := Load(path, defaults)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{path: path, defaults: defaults, log: log, config: config}, nil
+}
+
+// Get returns the current configuration
+func (w *Watcher) Get() Config {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.config
+}
+
+// Reload reads the configuration file again. In case the new configuration is not valid, the previous one is kept.
+func (w *Watcher) Reload() error {
+	config, err := Load(w.path, w.defaults)
+	if err != nil {
+		return err
+	}
+	w.lock.Lock()
+	w.config = config
+	w.lock.Unlock()
+	return nil
+}
+
+// Start watches the configuration file until the stop channel is closed. It implements the manager.Runnable interface.
+func (w *Watcher) Start(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(w.path)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			// chmod events are generated also by reads, and do not affect the content of the file
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if err := w.Reload(); err != nil {
+				w.log.Error(err, "unable to reload the configuration, the previous one is kept")
+				continue
+			}
+			w.log.Info("Configuration reloaded from " + w.path)
+		case err := <-watcher.Errors:
+			w.log.Error(err, "unable to watch the configuration file")
+		case <-stop:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/accounting"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=usagerecords,verbs=get;list;watch;create;update
+
+// startUsageRecord opens the UsageRecord of the VMs of a LabInstance started at the given time, closing the
+// records of the previous runs of the same instance (e.g. in case its specification has been changed).
+func (r *LabInstanceReconciler) startUsageRecord(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate, name string, start time.Time) {
+
+	r.stopUsageRecords(ctx, log, labInstance.Namespace, labInstance.Name, start)
+
+	record := accounting.NewRecord(*labInstance, *labTemplate, name, start)
+	if err := r.Create(ctx, &record); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "unable to create UsageRecord "+record.Name)
+	}
+}
+
+// readyUsageRecords records the time the VMs of a LabInstance became ready
+func (r *LabInstanceReconciler) readyUsageRecords(ctx context.Context, log logr.Logger, labInstance *crownlabsalpha1.LabInstance) {
+	r.updateUsageRecords(ctx, log, labInstance.Namespace, labInstance.Name, func(record *crownlabsalpha1.UsageRecord) bool {
+		if record.Spec.ReadyTime != nil {
+			return false
+		}
+		now := metav1.Now()
+		record.Spec.ReadyTime = &now
+		return true
+	})
+}
+
+// stopUsageRecords closes the open UsageRecords of a LabInstance at the given time
+func (r *LabInstanceReconciler) stopUsageRecords(ctx context.Context, log logr.Logger, namespace, name string, stop time.Time) {
+	r.updateUsageRecords(ctx, log, namespace, name, func(record *crownlabsalpha1.UsageRecord) bool {
+		stopTime := metav1.NewTime(stop)
+		record.Spec.StopTime = &stopTime
+		return true
+	})
+}
+
+// updateUsageRecords applies the given mutation to the open UsageRecords of a LabInstance, updating the ones actually changed
+func (r *LabInstanceReconciler) updateUsageRecords(ctx context.Context, log logr.Logger, namespace, name string,
+	mutate func(record *crownlabsalpha1.UsageRecord) bool) {
+
+	var records crownlabsalpha1.UsageRecordList
+	if err := r.List(ctx, &records, client.MatchingLabels{"instance-name": name, "instance-namespace": namespace}); err != nil {
+		log.Error(err, "unable to list the UsageRecords of LabInstance "+name)
+		return
+	}
+	for i := range records.Items {
+		record := &records.Items[i]
+		if record.Spec.StopTime != nil || !mutate(record) {
+			continue
+		}
+		if err := r.Update(ctx, record); err != nil {
+			log.Error(err, "unable to update UsageRecord "+record.Name)
+		}
+	}
+}
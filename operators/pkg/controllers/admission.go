@@ -0,0 +1,167 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/admission"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	queued = "Queued"
+
+	admissionRetryPeriod = 30 * time.Second
+	// reservationTimeout is how long the capacity of an admitted LabInstance is reserved,
+	// waiting for the pods of its VMs to be scheduled.
+	reservationTimeout = 2 * time.Minute
+)
+
+// reservation is the capacity reserved for an admitted LabInstance
+type reservation struct {
+	demand []v1.ResourceList
+	nodes  []string
+	expiry time.Time
+}
+
+// admit decides whether the LabInstance can be created given the free capacity of the cluster. LabInstances are
+// admitted by priority tier, and in FIFO order within the same tier: in case the capacity is not enough, the
+// LabInstance is queued, reporting its position in the status, and the admission is retried periodically.
+// It returns true if the creation can proceed, otherwise the result to be returned by the reconciler.
+func (r *LabInstanceReconciler) admit(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) (bool, ctrl.Result) {
+
+	if !r.CapacityAdmission {
+		return true, ctrl.Result{}
+	}
+
+	// the LabInstances are admitted one at a time, so that the concurrent reconciliations do not admit them
+	// given the same free capacity, without accounting for the reservations of each other
+	r.admissionLock.Lock()
+	defer r.admissionLock.Unlock()
+
+	capacity, err := r.freeCapacity(ctx)
+	if err != nil {
+		// the admission is skipped, rather than blocking all the LabInstances
+		log.Error(err, "unable to estimate the free capacity of the cluster")
+		return true, ctrl.Result{}
+	}
+
+	var instances crownlabsalpha1.LabInstanceList
+	if err := r.List(ctx, &instances); err != nil {
+		log.Error(err, "unable to list the queued LabInstances")
+		return true, ctrl.Result{}
+	}
+	// the queue is ordered by priority tier, and then by creation time
+	queue := []crownlabsalpha1.LabInstance{*labInstance}
+	templates := map[types.UID]*crownlabsalpha1.LabTemplate{labInstance.UID: labTemplate}
+	priority, err := r.instancePriority(ctx, labInstance, labTemplate)
+	if err != nil {
+		log.Error(err, "unable to retrieve the priority of the LabInstance")
+		return true, ctrl.Result{}
+	}
+	priorities := map[types.UID]int{labInstance.UID: instanceCreation.PriorityRank(priority)}
+	for _, instance := range instances.Items {
+		if instance.Status.Phase != queued || instance.UID == labInstance.UID {
+			continue
+		}
+		var template crownlabsalpha1.LabTemplate
+		templateName := types.NamespacedName{Namespace: instance.Spec.LabTemplateNamespace, Name: instance.Spec.LabTemplateName}
+		if err := r.Get(ctx, templateName, &template); err != nil {
+			continue
+		}
+		priority, err := r.instancePriority(ctx, &instance, &template)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, instance)
+		templates[instance.UID] = &template
+		priorities[instance.UID] = instanceCreation.PriorityRank(priority)
+	}
+	admission.SortQueue(queue, priorities)
+
+	position := 0
+	for i := range queue {
+		demand := admission.Demand(*templates[queue[i].UID])
+		nodes, ok := capacity.Place(demand)
+		if !ok {
+			// the LabInstances following the first one not fitting the capacity are not admitted
+			position = i + 1
+			break
+		}
+		if queue[i].UID == labInstance.UID {
+			r.reservations[labInstance.UID] = reservation{demand: demand, nodes: nodes, expiry: time.Now().Add(reservationTimeout)}
+			labInstance.Status.QueuePosition = 0
+			return true, ctrl.Result{}
+		}
+	}
+
+	for i := position; i < len(queue); i++ {
+		if queue[i].UID == labInstance.UID {
+			position = i + 1
+		}
+	}
+	if labInstance.Status.Phase != queued || labInstance.Status.QueuePosition != int32(position) {
+		msg := fmt.Sprintf("Not enough capacity to create LabInstance %v, queued at position %v", labInstance.Name, position)
+		log.Info(msg)
+		if labInstance.Status.Phase != queued {
+			r.EventsRecorder.Event(labInstance, "Normal", queued, msg)
+		}
+		// the ObservedGeneration is not updated, so that the creation is retried
+		r.phases.transition(instanceKey(labInstance), labInstance, queued)
+		labInstance.Status.Phase = queued
+		labInstance.Status.QueuePosition = int32(position)
+		if err := r.Status().Update(ctx, labInstance); err != nil {
+			log.Error(err, "unable to update LabInstance status")
+		}
+	}
+	return false, ctrl.Result{RequeueAfter: admissionRetryPeriod}
+}
+
+// freeCapacity estimates the free capacity of the nodes, given the requests of the pods running on them
+// and the capacity reserved for the LabInstances recently admitted. It must be called holding the admissionLock.
+func (r *LabInstanceReconciler) freeCapacity(ctx context.Context) (admission.Capacity, error) {
+	var nodes v1.NodeList
+	if err := r.List(ctx, &nodes); err != nil {
+		return nil, err
+	}
+	var pods v1.PodList
+	if err := r.List(ctx, &pods); err != nil {
+		return nil, err
+	}
+	capacity := admission.FreeCapacity(nodes.Items, pods.Items)
+
+	if r.reservations == nil {
+		r.reservations = map[types.UID]reservation{}
+	}
+	now := time.Now()
+	for uid, reservation := range r.reservations {
+		if now.After(reservation.expiry) {
+			delete(r.reservations, uid)
+			continue
+		}
+		capacity.Reserve(reservation.demand, reservation.nodes)
+	}
+	return capacity, nil
+}
@@ -0,0 +1,188 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// collectAnnotation requests the collection of the work of the student: a new collection
+	// is started every time the value of the annotation changes.
+	collectAnnotation   = "crownlabs.polito.it/collect"
+	collectionOnExamEnd = "exam-end"
+
+	collectionRunning   = "CollectionRunning"
+	collectionCompleted = "CollectionCompleted"
+	collectionFailed    = "CollectionFailed"
+
+	collectionPollPeriod = 10 * time.Second
+)
+
+// reconcileCollection starts the collection of the work of the student, either when requested through
+// the collect annotation or when the exam ends, and tracks its progress.
+func (r *LabInstanceReconciler) reconcileCollection(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) (ctrl.Result, error) {
+
+	if labTemplate.Spec.Collection == nil {
+		return ctrl.Result{}, nil
+	}
+
+	request := labInstance.Annotations[collectAnnotation]
+	if request == "" && labTemplate.Spec.Exam != nil && labInstance.Status.Phase == examEnded {
+		request = collectionOnExamEnd
+	}
+
+	submission := labInstance.Status.Submission
+	if request != "" && (submission == nil || submission.Request != request) {
+		return r.startCollection(ctx, log, labInstance, labTemplate, request)
+	}
+	if submission != nil && submission.Phase == collectionRunning {
+		return r.checkCollection(ctx, log, labInstance, labTemplate)
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *LabInstanceReconciler) startCollection(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate, request string) (ctrl.Result, error) {
+
+	submission := crownlabsalpha1.SubmissionStatus{Request: request, Phase: collectionFailed}
+
+	name, err := r.getInstanceResourceName(ctx, labInstance)
+	if err != nil {
+		setSubmissionStatus(r, ctx, log, "Unable to collect the work of LabInstance "+labInstance.Name+": "+err.Error(),
+			"Warning", labInstance, submission)
+		return ctrl.Result{}, nil
+	}
+
+	var key v1.Secret
+	keyName := types.NamespacedName{Namespace: labInstance.Namespace, Name: name + "-collector-key"}
+	if err := r.Get(ctx, keyName, &key); err != nil {
+		setSubmissionStatus(r, ctx, log, "Unable to get the collector key of LabInstance "+labInstance.Name+": "+err.Error(),
+			"Warning", labInstance, submission)
+		return ctrl.Result{}, nil
+	}
+
+	// the collected work is stored in the course namespace, under a directory identifying the student
+	destination, err := instanceCreation.CollectionDestination(labInstance.Spec.StudentID, labInstance.Namespace, labInstance.Name)
+	if err != nil {
+		setSubmissionStatus(r, ctx, log, "Unable to collect the work of LabInstance "+labInstance.Name+": "+err.Error(),
+			"Warning", labInstance, submission)
+		return ctrl.Result{}, nil
+	}
+	collection := labTemplate.Spec.Collection
+	host := collectionVmResourceName(name, labTemplate) + "-svc." + labInstance.Namespace
+	labels := map[string]string{"instance-name": labInstance.Name, "instance-namespace": labInstance.Namespace}
+
+	// the VMs accept the connections of the collector jobs only from the labelled namespaces
+	if err := instanceCreation.LabelCollectorNamespace(r.Client, ctx, labTemplate.Namespace); err != nil {
+		log.Error(err, "unable to label namespace "+labTemplate.Namespace)
+		return ctrl.Result{}, err
+	}
+	job := instanceCreation.CreateCollectionJob(name, labTemplate.Namespace, r.settings(labInstance).CollectorImage, host, *collection, destination, labels)
+	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, job); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: job.Namespace, Name: job.Name}, &job); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the private key is copied in the namespace of the job, and deleted together with it
+	jobKey := instanceCreation.CreateCollectorKeySecret(job.Name, job.Namespace, key.Data[instanceCreation.CollectorKeyName])
+	jobKey.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&job, batchv1.SchemeGroupVersion.WithKind("Job"))})
+	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, jobKey); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	submission.Phase = collectionRunning
+	submission.JobName = job.Name
+	submission.Artifact = "pvc://" + job.Namespace + "/" + collection.ClaimName + "/" + destination
+	setSubmissionStatus(r, ctx, log, "Collection of the work of LabInstance "+labInstance.Name+" started by job "+job.Name,
+		"Normal", labInstance, submission)
+	return ctrl.Result{RequeueAfter: collectionPollPeriod}, nil
+}
+
+func (r *LabInstanceReconciler) checkCollection(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) (ctrl.Result, error) {
+
+	submission := *labInstance.Status.Submission
+
+	var job batchv1.Job
+	jobName := types.NamespacedName{Namespace: labTemplate.Namespace, Name: submission.JobName}
+	if err := r.Get(ctx, jobName, &job); err != nil {
+		submission.Phase = collectionFailed
+		setSubmissionStatus(r, ctx, log, "Unable to get collection job "+jobName.Name+": "+err.Error(),
+			"Warning", labInstance, submission)
+		return ctrl.Result{}, nil
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		submission.Phase = collectionCompleted
+		submission.CompletionTime = job.Status.CompletionTime
+		setSubmissionStatus(r, ctx, log, "Work of LabInstance "+labInstance.Name+" collected in "+submission.Artifact,
+			"Normal", labInstance, submission)
+	case jobFailed(&job):
+		submission.Phase = collectionFailed
+		setSubmissionStatus(r, ctx, log, "Collection job "+job.Name+" of LabInstance "+labInstance.Name+" failed",
+			"Warning", labInstance, submission)
+	default:
+		return ctrl.Result{RequeueAfter: collectionPollPeriod}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// collectionVmResourceName returns the name prefix of the resources of the VM the work is collected from
+func collectionVmResourceName(name string, labTemplate *crownlabsalpha1.LabTemplate) string {
+	vms := instanceCreation.TemplateVms(*labTemplate)
+	for _, vm := range vms {
+		if vm.Name == labTemplate.Spec.Collection.Vm {
+			return instanceCreation.VmResourceName(name, vm)
+		}
+	}
+	return instanceCreation.VmResourceName(name, vms[0])
+}
+
+func jobFailed(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func setSubmissionStatus(r *LabInstanceReconciler, ctx context.Context, log logr.Logger,
+	msg string, eventType string, labInstance *crownlabsalpha1.LabInstance, submission crownlabsalpha1.SubmissionStatus) {
+
+	log.Info(msg)
+	r.EventsRecorder.Event(labInstance, eventType, submission.Phase, msg)
+
+	labInstance.Status.Submission = &submission
+	if err := r.Status().Update(ctx, labInstance); err != nil {
+		log.Error(err, "unable to update LabInstance status")
+	}
+}
@@ -0,0 +1,90 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/config"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/courses"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+)
+
+// settings returns the settings applying to the given LabInstance, according to its course. They are taken from
+// the configuration file, if available, and from the fields of the reconciler otherwise.
+func (r *LabInstanceReconciler) settings(labInstance *crownlabsalpha1.LabInstance) config.Settings {
+	if r.Config != nil {
+		current := r.Config.Get()
+		return current.ForCourse(r.instanceCourse(labInstance))
+	}
+
+	maxInstancesPerStudent, maxVmiRetries := r.MaxInstancesPerStudent, r.MaxVmiRetries
+	return config.Settings{
+		WebsiteBaseUrl:   r.WebsiteBaseUrl,
+		NextcloudBaseUrl: r.NextcloudBaseUrl,
+		WebdavSecretName: r.WebdavSecretName,
+		CollectorImage:   r.CollectorImage,
+		Oauth2: config.Oauth2{
+			ProxyImage:   r.Oauth2ProxyImage,
+			ClientID:     r.OidcClientID,
+			ClientSecret: r.OidcClientSecret,
+			ProviderUrl:  r.OidcProviderUrl,
+		},
+		MaxInstancesPerStudent: &maxInstancesPerStudent,
+		Readiness:              config.Readiness{MaxVmiRetries: &maxVmiRetries},
+		Ingress:                crownlabsalpha1.IngressSettings{Gateway: r.Gateway},
+	}
+}
+
+// instanceCourse returns the Course the LabInstance belongs to, i.e. the one labelling the namespace of its LabTemplate,
+// which is set by the operator only, rather than the course name chosen by the teachers in the LabTemplate.
+func (r *LabInstanceReconciler) instanceCourse(labInstance *crownlabsalpha1.LabInstance) string {
+	var namespace corev1.Namespace
+	if err := r.Get(context.Background(), types.NamespacedName{Name: labInstance.Spec.LabTemplateNamespace}, &namespace); err != nil {
+		return ""
+	}
+	return namespace.Labels[courses.CourseLabel]
+}
+
+// namespaceWhitelist returns the labels identifying the namespaces whose LabInstances are reconciled
+func (r *LabInstanceReconciler) namespaceWhitelist() map[string]string {
+	if r.Config != nil {
+		return r.Config.Get().NamespaceWhitelist
+	}
+	return r.NamespaceWhitelist.MatchLabels
+}
+
+// ingressSettings returns the settings of the Ingresses exposing the instances of a LabTemplate. They are taken from
+// the LabTemplate, from the annotations of its namespace and from the settings of the operator, in this order, except
+// the annotations of the Ingresses, which are taken only from the settings of the operator.
+func (r *LabInstanceReconciler) ingressSettings(ctx context.Context, labTemplate *crownlabsalpha1.LabTemplate, settings config.Settings) (crownlabsalpha1.IngressSettings, error) {
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: labTemplate.Namespace}, &namespace); err != nil {
+		return crownlabsalpha1.IngressSettings{}, err
+	}
+
+	var template crownlabsalpha1.IngressSettings
+	if labTemplate.Spec.Ingress != nil {
+		template = *labTemplate.Spec.Ingress
+		template.Annotations = nil
+	}
+	return instanceCreation.MergeIngressSettings(template, instanceCreation.NamespaceIngressSettings(namespace.Annotations),
+		settings.Ingress, crownlabsalpha1.IngressSettings{Host: settings.WebsiteBaseUrl}), nil
+}
@@ -0,0 +1,169 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/courses"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// courseFinalizer guarantees the removal of the enrolment labels from the namespaces of the tenants, which cannot be owned by the Course,
+	// and of the members from the Keycloak groups of the course
+	courseFinalizer = "crownlabs.polito.it/course"
+
+	// courseResyncPeriod is the period the resources of the courses are checked at, to correct the drift of
+	// the ones of the tenants, which are not owned (hence, not watched) by the Course
+	courseResyncPeriod = 10 * time.Minute
+)
+
+// CourseReconciler reconciles a Course object, keeping the resources of the course and of its tenants in sync with it
+type CourseReconciler struct {
+	client.Client
+	Log            logr.Logger
+	Scheme         *runtime.Scheme
+	EventsRecorder record.EventRecorder
+	// Keycloak provisions the Keycloak groups of the courses and keeps their members in sync (nil disables it)
+	Keycloak *courses.KeycloakGroups
+}
+
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=courses,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=courses/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labtemplates,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=tenants;enrolments,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=namespaces;resourcequotas,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings;clusterrolebindings,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update
+
+func (r *CourseReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("course", req.Name)
+
+	var course crownlabsalpha1.Course
+	if err := r.Get(ctx, req.NamespacedName, &course); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !course.DeletionTimestamp.IsZero() {
+		if !containsString(course.Finalizers, courseFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := courses.Unenrol(ctx, r.Client, &course); err != nil {
+			log.Error(err, "unable to unenrol the tenants of Course "+course.Name)
+			return ctrl.Result{}, err
+		}
+		if r.Keycloak != nil {
+			groups, err := r.Keycloak.Clear(ctx, &course)
+			for _, removed := range groups.Removed {
+				log.Info("Keycloak membership " + removed + " removed")
+			}
+			if err != nil {
+				log.Error(err, "unable to clear the Keycloak groups of Course "+course.Name)
+				return ctrl.Result{}, err
+			}
+		}
+		course.Finalizers = removeString(course.Finalizers, courseFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &course)
+	}
+
+	if !containsString(course.Finalizers, courseFinalizer) {
+		course.Finalizers = append(course.Finalizers, courseFinalizer)
+		if err := r.Update(ctx, &course); err != nil {
+			log.Error(err, "unable to add the finalizer to Course "+course.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	result, err := courses.Sync(ctx, r.Client, r.Scheme, &course)
+	for _, updated := range result.Updated {
+		log.Info(updated + " reconciled")
+	}
+	for i, failed := range result.Failed {
+		log.Error(result.Errors[i], "unable to reconcile "+failed)
+		r.EventsRecorder.Event(&course, "Warning", "ResourceNotReconciled", "Could not reconcile "+failed+": "+result.Errors[i].Error())
+	}
+	if err != nil {
+		log.Error(err, "unable to reconcile Course "+course.Name)
+		return ctrl.Result{}, err
+	}
+
+	status := crownlabsalpha1.CourseStatus{
+		Namespace:       courses.NamespaceName(&course),
+		Tenants:         len(result.Members),
+		Laboratories:    len(course.Spec.Laboratories),
+		FailedResources: result.Failed,
+	}
+	// the status is updated only if changed, since each update triggers a new reconciliation
+	if !reflect.DeepEqual(status, course.Status) {
+		course.Status = status
+		if err := r.Status().Update(ctx, &course); err != nil {
+			log.Error(err, "unable to update Course status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if r.Keycloak != nil {
+		groups, err := r.Keycloak.Sync(ctx, &course, result.Members)
+		for _, added := range groups.Added {
+			log.Info("Keycloak membership " + added + " added")
+		}
+		for _, removed := range groups.Removed {
+			log.Info("Keycloak membership " + removed + " removed")
+		}
+		if len(groups.Missing) > 0 {
+			log.Info("members not found in Keycloak: " + strings.Join(groups.Missing, ", "))
+		}
+		if err != nil {
+			log.Error(err, "unable to synchronize the Keycloak groups of Course "+course.Name)
+			r.EventsRecorder.Event(&course, "Warning", "GroupsNotSynchronized", "Could not synchronize the Keycloak groups: "+err.Error())
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: courseResyncPeriod}, nil
+}
+
+func (r *CourseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&crownlabsalpha1.Course{}).
+		Owns(&corev1.Namespace{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Owns(&rbacv1.ClusterRoleBinding{}).
+		Owns(&crownlabsalpha1.LabTemplate{}).
+		// the Courses are reconciled also when the tenants are enrolled or unenrolled through Enrolments
+		Watches(&source.Kind{Type: &crownlabsalpha1.Enrolment{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+				enrolment := obj.Object.(*crownlabsalpha1.Enrolment)
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: enrolment.Spec.Course}}}
+			}),
+		}).
+		Complete(r)
+}
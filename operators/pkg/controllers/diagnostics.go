@@ -0,0 +1,225 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/diagnostics"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/readiness"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// virtLauncherLabel identifies the virt-launcher pods of a VMI, by its UID
+	virtLauncherLabel = "kubevirt.io/created-by"
+	// pendingDiagnosisPeriod is the period the causes preventing a pending VMI from starting are checked with
+	pendingDiagnosisPeriod = time.Minute
+	// vmiDeletionTimeout is the maximum time waited for the deletion of a failed VMI before restarting it
+	vmiDeletionTimeout = 5 * time.Minute
+	// vmiDeletionPollPeriod is the period the deletion of a failed VMI is checked with, before restarting it
+	vmiDeletionPollPeriod = 5 * time.Second
+	// vmiRestarting is the phase of the VMs whose VMI is being restarted after a failure
+	vmiRestarting = "VmiRestarting"
+)
+
+// diagnoseVmi collects the state of the given VMI, of its virt-launcher pods and the related events, and summarises
+// the cause of its failure. Events are retrieved directly from the API server, to avoid caching all the events of the cluster.
+func (r *LabInstanceReconciler) diagnoseVmi(ctx context.Context, vmi *virtv1.VirtualMachineInstance) diagnostics.Diagnosis {
+	var pods v1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(vmi.Namespace),
+		client.MatchingLabels{virtLauncherLabel: string(vmi.UID)}); err != nil {
+		pods.Items = nil
+	}
+
+	var events []v1.Event
+	if r.APIReader != nil {
+		names := []string{vmi.Name}
+		for _, pod := range pods.Items {
+			names = append(names, pod.Name)
+		}
+		for _, name := range names {
+			var list v1.EventList
+			if err := r.APIReader.List(ctx, &list, client.InNamespace(vmi.Namespace),
+				client.MatchingFields{"involvedObject.name": name}); err == nil {
+				events = append(events, list.Items...)
+			}
+		}
+	}
+
+	return diagnostics.Diagnose(*vmi, pods.Items, events)
+}
+
+// setFailureStatus records the diagnosis of a failure of one of the VMs of the LabInstance, identified by its name
+// in multi-VM laboratories (and empty otherwise).
+func setFailureStatus(r *LabInstanceReconciler, ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, vmName string, diagnosis diagnostics.Diagnosis) {
+
+	// the VMs are monitored by concurrent goroutines
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+
+	msg := fmt.Sprintf("LabInstance %v failure diagnosed as %v: %v", labInstance.Name, diagnosis.Reason, diagnosis.Message)
+	log.Info(msg)
+	r.EventsRecorder.Event(labInstance, "Warning", diagnosis.Reason, msg)
+
+	failure := diagnosis.Status()
+	failure.Vm = vmName
+	now := metav1.Now()
+	failure.LastFailureTime = &now
+	if labInstance.Status.Failure != nil {
+		failure.Retries = labInstance.Status.Failure.Retries
+	}
+	labInstance.Status.Failure = &failure
+	if err := r.Status().Update(ctx, labInstance); err != nil {
+		log.Error(err, "unable to update LabInstance status")
+	}
+}
+
+// retryVmi restarts a failed VMI, unless the retry limit of the LabInstance has been reached. The VMI is deleted and
+// the VM is reported as restarting, so that the VMI is created again by restartVmis once the deletion has completed;
+// the URL of the VM is preserved, since it is exposed again by the same route. It returns whether the VMI is being
+// restarted.
+func (r *LabInstanceReconciler) retryVmi(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, vmName, url string, vmi *virtv1.VirtualMachineInstance) bool {
+
+	// the VMIs taken from the warm pool are not restarted, since they belong to the LabTemplate
+	if _, pooled := vmi.Labels[instanceCreation.PoolLabel]; pooled {
+		return false
+	}
+	// the VMIs of the LabInstances stopped in the meanwhile are not restarted
+	var current crownlabsalpha1.LabInstance
+	if err := r.Get(ctx, types.NamespacedName{Namespace: labInstance.Namespace, Name: labInstance.Name}, &current); err != nil ||
+		instanceCreation.IsStopped(current) {
+		return false
+	}
+
+	maxRetries := *r.settings(labInstance).Readiness.MaxVmiRetries
+	r.statusLock.Lock()
+	failure := labInstance.Status.Failure
+	if failure == nil || failure.Retries >= int32(maxRetries) {
+		r.statusLock.Unlock()
+		return false
+	}
+	failure.Retries++
+	retries := failure.Retries
+	// the name of the resources is recorded, since it cannot be retrieved from the VMIs once they are deleted
+	name, err := r.getInstanceResourceName(ctx, labInstance)
+	if err != nil {
+		r.statusLock.Unlock()
+		log.Error(err, "unable to retrieve the resources of LabInstance "+labInstance.Name)
+		return false
+	}
+	labInstance.Status.ResourceName = name
+	r.statusLock.Unlock()
+
+	if err := r.Delete(ctx, vmi); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "unable to delete VirtualMachineInstance "+vmi.Name)
+		return false
+	}
+	msg := fmt.Sprintf("Restarting VirtualMachineInstance %v in namespace %v (attempt %v of %v)", vmi.Name, vmi.Namespace, retries, maxRetries)
+	setVmStatus(r, ctx, log, msg, "Normal", vmiRestarting, labInstance, vmName, "", url)
+	return true
+}
+
+// restartVmis creates again the VMIs deleted by retryVmi, once their deletion has completed, and monitors them until
+// they are ready. While the deletion is in progress, the reconciliation is requeued rather than waiting for it.
+func (r *LabInstanceReconciler) restartVmis(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) (ctrl.Result, error) {
+
+	name := labInstance.Status.ResourceName
+	var result ctrl.Result
+	for _, vm := range instanceCreation.TemplateVms(*labTemplate) {
+		if vmPhase(labInstance, vm.Name) != vmiRestarting || name == "" {
+			continue
+		}
+
+		vmi := instanceCreation.CreateVirtualMachineInstance(name, labInstance.Namespace, *labTemplate, vm, labInstance.Name, name+"-secret")
+		var current virtv1.VirtualMachineInstance
+		err := r.Get(ctx, types.NamespacedName{Namespace: vmi.Namespace, Name: vmi.Name}, &current)
+		switch {
+		case err == nil && current.DeletionTimestamp == nil:
+			// the VMI has been created again in the meanwhile, hence it is only monitored
+			vmi = current
+		case err == nil:
+			failure := labInstance.Status.Failure
+			if failure != nil && failure.LastFailureTime != nil && time.Since(failure.LastFailureTime.Time) > vmiDeletionTimeout {
+				msg := "Timeout while waiting for the deletion of VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace
+				setVmStatus(r, ctx, log, msg, "Warning", "VmiFailed", labInstance, vm.Name, "", "")
+				r.stopUsageRecords(ctx, log, labInstance.Namespace, labInstance.Name, time.Now())
+				continue
+			}
+			result = ctrl.Result{RequeueAfter: vmiDeletionPollPeriod}
+			continue
+		case !errors.IsNotFound(err):
+			return result, err
+		default:
+			vmi.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(labInstance, crownlabsalpha1.GroupVersion.WithKind("LabInstance"))})
+			priority, err := r.instancePriority(ctx, labInstance, labTemplate)
+			if err != nil {
+				return result, err
+			}
+			vmi.Spec.PriorityClassName = r.PriorityClasses[priority]
+			if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, vmi); err != nil {
+				return result, err
+			}
+		}
+
+		msg := "VirtualMachineInstance " + vmi.Name + " correctly created in namespace " + vmi.Namespace
+		setVmStatus(r, ctx, log, msg, "Normal", "VmiCreated", labInstance, vm.Name, "", vmUrl(labInstance, vm.Name))
+		if len(labTemplate.Spec.ReadinessChecks) == 0 {
+			labTemplate.Spec.ReadinessChecks = r.settings(labInstance).Readiness.DefaultChecks
+		}
+		go getVmiStatus(r, ctx, log, vm.Name, readiness.Checks(*labTemplate, vm), vmUrl(labInstance, vm.Name),
+			labInstance, vmi, time.Now())
+	}
+	return result, nil
+}
+
+// vmPhase returns the phase of one of the VMs of the LabInstance, identified by its name in multi-VM laboratories
+// (and empty otherwise)
+func vmPhase(labInstance *crownlabsalpha1.LabInstance, vmName string) string {
+	if vmName == "" {
+		return labInstance.Status.Phase
+	}
+	for _, vm := range labInstance.Status.Vms {
+		if vm.Name == vmName {
+			return vm.Phase
+		}
+	}
+	return ""
+}
+
+// vmUrl returns the URL of one of the VMs of the LabInstance, identified as by vmPhase
+func vmUrl(labInstance *crownlabsalpha1.LabInstance, vmName string) string {
+	for _, vm := range labInstance.Status.Vms {
+		if vm.Name == vmName {
+			return vm.Url
+		}
+	}
+	return labInstance.Status.Url
+}
@@ -0,0 +1,201 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	appsv1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	examNotStarted = "ExamNotStarted"
+	examEnded      = "ExamEnded"
+)
+
+// checkExamWindow verifies whether the LabInstance can be created according to the exam time window.
+// It returns true if the creation can proceed, otherwise the result to be returned by the reconciler.
+func (r *LabInstanceReconciler) checkExamWindow(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, exam *crownlabsalpha1.ExamProfile) (bool, ctrl.Result) {
+
+	now := time.Now()
+	if now.Before(exam.StartTime.Time) {
+		// the ObservedGeneration is not updated, so that the instance is created once the exam starts
+		if labInstance.Status.Phase != examNotStarted {
+			msg := "The exam has not started yet, LabInstance " + labInstance.Name + " will be created at " + exam.StartTime.String()
+			log.Info(msg)
+			r.EventsRecorder.Event(labInstance, "Normal", examNotStarted, msg)
+			r.phases.transition(instanceKey(labInstance), labInstance, examNotStarted)
+			labInstance.Status.Phase = examNotStarted
+			if err := r.Status().Update(ctx, labInstance); err != nil {
+				log.Error(err, "unable to update LabInstance status")
+			}
+		}
+		return false, ctrl.Result{RequeueAfter: exam.StartTime.Sub(now)}
+	}
+
+	if !now.Before(exam.EndTime.Time) {
+		setLabInstanceStatus(r, ctx, log, "The exam is already ended, LabInstance "+labInstance.Name+" will not be created",
+			"Warning", examEnded, labInstance, "", "")
+		return false, ctrl.Result{}
+	}
+
+	return true, ctrl.Result{}
+}
+
+// enforceExamDeadline revokes the access of the student to the LabInstance once the exam is ended,
+// while preserving the access of the teachers through the oauth2-proxy, and isolates its VMs from the
+// other pods. Before the deadline, it requests to be triggered again when the exam ends.
+func (r *LabInstanceReconciler) enforceExamDeadline(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) (ctrl.Result, error) {
+
+	exam := labTemplate.Spec.Exam
+	if exam == nil || labInstance.Status.Phase == examEnded || labInstance.Status.Phase == examNotStarted {
+		return ctrl.Result{}, nil
+	}
+
+	if remaining := time.Until(exam.EndTime.Time); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	name, err := r.getInstanceResourceName(ctx, labInstance)
+	if err != nil {
+		log.Error(err, "unable to retrieve the resources of LabInstance "+labInstance.Name)
+		return ctrl.Result{}, err
+	}
+
+	var oauthDeploy appsv1.Deployment
+	deployName := types.NamespacedName{Namespace: labInstance.Namespace, Name: name + "-oauth2-deploy"}
+	if err := r.Get(ctx, deployName, &oauthDeploy); err != nil {
+		log.Error(err, "unable to get deployment "+deployName.Name)
+		return ctrl.Result{}, err
+	}
+
+	teacherGroup := exam.TeacherGroup
+	if teacherGroup == "" {
+		teacherGroup = labTemplate.Namespace + "-admin"
+	}
+	if instanceCreation.RestrictOauth2Access(&oauthDeploy, teacherGroup) {
+		if err := r.Update(ctx, &oauthDeploy); err != nil {
+			log.Error(err, "unable to update deployment "+oauthDeploy.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	// the VMs are no longer reachable from the other pods, including the ssh connections of the students
+	for _, vm := range instanceCreation.TemplateVms(*labTemplate) {
+		var netpol networkingv1.NetworkPolicy
+		netpolName := types.NamespacedName{Namespace: labInstance.Namespace, Name: instanceCreation.VmResourceName(name, vm) + "-netpol"}
+		if err := r.Get(ctx, netpolName, &netpol); err != nil {
+			log.Error(err, "unable to get network policy "+netpolName.Name)
+			return ctrl.Result{}, err
+		}
+		if instanceCreation.SealNetworkPolicy(&netpol, name, labTemplate.Namespace) {
+			if err := r.Update(ctx, &netpol); err != nil {
+				log.Error(err, "unable to update network policy "+netpol.Name)
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	msg := "The exam is ended, LabInstance " + labInstance.Name + " is now accessible only by group " + teacherGroup
+	setLabInstanceStatus(r, ctx, log, msg, "Normal", examEnded, labInstance, labInstance.Status.IP, labInstance.Status.Url)
+	return ctrl.Result{}, nil
+}
+
+// stopEndedExam stops the VMs of the CLI laboratories once the exam is ended and the work of the student has
+// been collected (if requested), since the students reach them through ssh rather than through the oauth2-proxy.
+func (r *LabInstanceReconciler) stopEndedExam(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) error {
+
+	if labTemplate.Spec.Exam == nil || labInstance.Status.Phase != examEnded || !hasCliVms(labTemplate) {
+		return nil
+	}
+	if submission := labInstance.Status.Submission; labTemplate.Spec.Collection != nil &&
+		(submission == nil || submission.Phase == collectionRunning) {
+		return nil
+	}
+
+	var vmis virtv1.VirtualMachineInstanceList
+	if err := r.List(ctx, &vmis, client.InNamespace(labInstance.Namespace),
+		client.MatchingLabels{"instance-name": labInstance.Name}); err != nil {
+		return err
+	}
+	if len(vmis.Items) == 0 {
+		return nil
+	}
+	for i := range vmis.Items {
+		if err := r.Delete(ctx, &vmis.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	r.stopUsageRecords(ctx, log, labInstance.Namespace, labInstance.Name, time.Now())
+	msg := "The exam is ended, the VMs of LabInstance " + labInstance.Name + " have been stopped"
+	log.Info(msg)
+	r.EventsRecorder.Event(labInstance, "Normal", "ExamVmsStopped", msg)
+	return nil
+}
+
+// hasCliVms returns whether any of the VMs of the LabTemplate is accessed through ssh
+func hasCliVms(labTemplate *crownlabsalpha1.LabTemplate) bool {
+	for _, vm := range instanceCreation.TemplateVms(*labTemplate) {
+		if vm.VmType == crownlabsalpha1.TypeCLI {
+			return true
+		}
+	}
+	return false
+}
+
+// getInstanceResourceName returns the name prefix shared by all the resources created for the given LabInstance,
+// as recorded in its status or, for the LabInstances created by older versions of the operator, by its VMIs.
+func (r *LabInstanceReconciler) getInstanceResourceName(ctx context.Context, labInstance *crownlabsalpha1.LabInstance) (string, error) {
+	if labInstance.Status.ResourceName != "" {
+		return labInstance.Status.ResourceName, nil
+	}
+	var vmis virtv1.VirtualMachineInstanceList
+	if err := r.List(ctx, &vmis, client.InNamespace(labInstance.Namespace),
+		client.MatchingLabels{"instance-name": labInstance.Name}); err != nil {
+		return "", err
+	}
+	if len(vmis.Items) == 0 {
+		// the VMIs taken from the warm pool belong to the namespace of the LabTemplate
+		if err := r.List(ctx, &vmis, client.InNamespace(labInstance.Spec.LabTemplateNamespace), client.MatchingLabels{
+			instanceCreation.PoolInstanceNameLabel:      labInstance.Name,
+			instanceCreation.PoolInstanceNamespaceLabel: labInstance.Namespace,
+		}); err != nil {
+			return "", err
+		}
+	}
+	if len(vmis.Items) == 0 {
+		return "", fmt.Errorf("no VirtualMachineInstance found for LabInstance %v", labInstance.Name)
+	}
+	// the instance-resources label is not present on the VMIs created by older versions of the operator
+	if name, ok := vmis.Items[0].Labels["instance-resources"]; ok {
+		return name, nil
+	}
+	return vmis.Items[0].Labels["name"], nil
+}
@@ -18,17 +18,24 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"net"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/config"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/courses"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/diagnostics"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/exposure"
 	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/readiness"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/revisions"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/tracing"
 
 	"github.com/go-logr/logr"
 	"github.com/google/uuid"
 	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -38,6 +45,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// revisionNotFound is the phase of the LabInstances whose revision of the LabTemplate does not exist
+	revisionNotFound = "RevisionNotFound"
+	// rejectionRequeuePeriod is the period the LabInstances which cannot be created are verified again with
+	rejectionRequeuePeriod = time.Minute
+)
+
 // LabInstanceReconciler reconciles a LabInstance object
 type LabInstanceReconciler struct {
 	client.Client
@@ -49,8 +63,37 @@ type LabInstanceReconciler struct {
 	NextcloudBaseUrl   string
 	WebdavSecretName   string
 	Oauth2ProxyImage   string
+	OidcClientID       string
 	OidcClientSecret   string
 	OidcProviderUrl    string
+	CollectorImage     string
+	// PriorityClasses maps the priority tiers to the PriorityClasses of the VMIs and of the oauth2-proxy pods
+	PriorityClasses map[crownlabsalpha1.PriorityTier]string
+	// APIReader reads the objects which are not cached (e.g. events)
+	APIReader client.Reader
+	// CapacityAdmission enables the admission of the LabInstances according to the free capacity of the cluster
+	CapacityAdmission bool
+	// MaxInstancesPerStudent limits the LabInstances each student is accounted to (0 means unlimited)
+	MaxInstancesPerStudent int
+	// MaxVmiRetries is the number of times the failed VMIs of each LabInstance are automatically restarted
+	MaxVmiRetries int
+	// Config is the configuration file of the operator, which overrides the settings above when available
+	Config *config.Watcher
+	// Tracer records the spans of the creation of the LabInstances (nil disables the tracing)
+	Tracer *tracing.Tracer
+	// Exposer creates the resources exposing the instances (i.e. Ingresses or HTTPRoutes)
+	Exposer exposure.Exposer
+	// Gateway is the Gateway the HTTPRoutes are attached to (as namespace/name)
+	Gateway string
+	// CourseGroups restricts the access to the instances of the LabTemplates generated for the Courses to their owners
+	// and to the teachers, through the Keycloak groups provisioned for the Courses
+	CourseGroups bool
+
+	statusLock sync.Mutex
+	// admissionLock serializes the admissions, which read and update the reservations
+	admissionLock sync.Mutex
+	reservations  map[types.UID]reservation
+	phases        phaseTracker
 }
 
 // +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labinstances,verbs=get;list;watch;create;update;patch;delete
@@ -67,7 +110,11 @@ func (r *LabInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	var labInstance crownlabsalpha1.LabInstance
 	if err := r.Get(ctx, req.NamespacedName, &labInstance); err != nil {
 		// reconcile was triggered by a delete request
-		log.Info("LabInstance " + req.Name + " deleted")
+		if errors.IsNotFound(err) {
+			log.Info("LabInstance " + req.Name + " deleted")
+			r.stopUsageRecords(ctx, log, req.Namespace, req.Name, time.Now())
+			r.phases.forget(req.NamespacedName.String())
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	ns := v1.Namespace{}
@@ -79,7 +126,7 @@ func (r *LabInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	// It performs reconciliation only if the LabInstance belongs to whitelisted namespaces
 	// by checking the existence of keys in labInstance namespace
 	if err := r.Get(ctx, namespaceName, &ns); err == nil {
-		if !instanceCreation.CheckLabels(ns, r.NamespaceWhitelist.MatchLabels) {
+		if !instanceCreation.CheckLabels(ns, r.namespaceWhitelist()) {
 			log.Info("Namespace " + req.Namespace + " does not meet " +
 				"the selector labels")
 			return ctrl.Result{}, nil
@@ -91,9 +138,14 @@ func (r *LabInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	// The metadata.generation value is incremented for all changes, except for changes to .metadata or .status
 	// if metadata.generation is not incremented there's no need to reconcile
 	if labInstance.Status.ObservedGeneration == labInstance.ObjectMeta.Generation {
-		return ctrl.Result{}, nil
+		return r.reconcileLifecycle(ctx, log, &labInstance)
 	}
 
+	// the steps of the creation are traced as children of the span of the reconciliation
+	ctx, span := r.Tracer.StartSpan(r.Tracer.InstanceContext(ctx, labInstance.UID, labInstance.Generation),
+		"reconcile", instanceAttributes(&labInstance))
+	defer span.End()
+
 	// check if labTemplate exists
 	templateName := types.NamespacedName{
 		Namespace: labInstance.Spec.LabTemplateNamespace,
@@ -108,25 +160,73 @@ func (r *LabInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		return ctrl.Result{}, err
 	}
 
-	vmType := labTemplate.Spec.VmType
-	if vmType != crownlabsalpha1.TypeCLI {
-		vmType = crownlabsalpha1.TypeGUI
-	}
-
 	r.EventsRecorder.Event(&labInstance, "Normal", "LabTemplateFound", "LabTemplate "+templateName.Name+" found in namespace "+labTemplate.Namespace)
-	labInstance.Labels = map[string]string{
-		"course-name":        strings.ReplaceAll(strings.ToLower(labTemplate.Spec.CourseName), " ", "-"),
-		"template-name":      labTemplate.Name,
-		"template-namespace": labTemplate.Namespace,
+	// the LabInstance is created from a known revision of the LabTemplate, not affected by its later changes
+	revision, err := r.applyRevision(ctx, &labInstance, &labTemplate)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			log.Error(err, "unable to retrieve the revision of LabTemplate "+labTemplate.Name)
+			return ctrl.Result{}, err
+		}
+		return r.rejectLabInstance(ctx, log, &labInstance, revisionNotFound,
+			fmt.Sprintf("LabInstance %v not created: revision %v of LabTemplate %v not found", labInstance.Name, revision, labTemplate.Name)), nil
+	}
+	// the members of the team are the ones declared by the LabTemplate, rather than chosen by the creator of the LabInstance
+	team, err := instanceCreation.ResolveTeam(labInstance, labTemplate)
+	if err != nil {
+		return r.rejectLabInstance(ctx, log, &labInstance, invalidTeam, "LabInstance "+labInstance.Name+" not created: "+err.Error()), nil
+	}
+	// the existing labels (e.g. the ones identifying the LabSession of the instance) are preserved
+	if labInstance.Labels == nil {
+		labInstance.Labels = map[string]string{}
+	}
+	labInstance.Labels["course-name"] = strings.ReplaceAll(strings.ToLower(labTemplate.Spec.CourseName), " ", "-")
+	labInstance.Labels["template-name"] = labTemplate.Name
+	labInstance.Labels["template-namespace"] = labTemplate.Namespace
+	// the members labels are used to account the LabInstance to all the students sharing it
+	for _, tenant := range instanceCreation.TeamTenants(team, labInstance.Namespace) {
+		labInstance.Labels[instanceCreation.MemberLabelPrefix+tenant] = "true"
 	}
-	labInstance.Status.ObservedGeneration = labInstance.ObjectMeta.Generation
 	if err := r.Update(ctx, &labInstance); err != nil {
 		log.Error(err, "unable to update LabInstance labels")
 	}
 
+	// the settings are read once per reconciliation, since the configuration can be reloaded at any time
+	settings := r.settings(&labInstance)
+
+	labInstance.Status.Team = team
+	labInstance.Status.LabTemplateRevision = revision
+	if violation, err := r.checkInstanceQuota(ctx, &labInstance); err != nil {
+		return ctrl.Result{}, err
+	} else if violation != "" {
+		return r.rejectLabInstance(ctx, log, &labInstance, quotaExceeded, "LabInstance "+labInstance.Name+" not created: "+violation), nil
+	}
+	if violation, err := r.checkEnrolment(ctx, &labInstance); err != nil {
+		return ctrl.Result{}, err
+	} else if violation != "" {
+		return r.rejectLabInstance(ctx, log, &labInstance, notEnrolled, "LabInstance "+labInstance.Name+" not created: "+violation), nil
+	}
+
+	exam := labTemplate.Spec.Exam
+	if exam != nil {
+		if proceed, result := r.checkExamWindow(ctx, log, &labInstance, exam); !proceed {
+			return result, nil
+		}
+	}
+
+	if admitted, result := r.admit(ctx, log, &labInstance, &labTemplate); !admitted {
+		return result, nil
+	}
+
 	// prepare variables common to all resources
 	name := fmt.Sprintf("%v-%.4s", strings.ReplaceAll(labInstance.Name, ".", "-"), uuid.New().String())
+	labInstance.Status.ResourceName = name
 	namespace := labInstance.Namespace
+	priority, err := r.instancePriority(ctx, &labInstance, &labTemplate)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	priorityClass := r.PriorityClasses[priority]
 	// this is added so that all resources created for this LabInstance are destroyed when the LabInstance is deleted
 	b := true
 	labiOwnerRef := []metav1.OwnerReference{
@@ -139,43 +239,124 @@ func (r *LabInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		},
 	}
 
+	// the VMI is taken from the warm pool of the LabTemplate, if available
+	pooledVmi := r.claimPooledVmi(ctx, log, &labInstance, &labTemplate, name)
+
 	// create secret referenced by VirtualMachineInstance (Cloudinit)
 	// To be extracted in a configuration flag
 
-	var user, password string
-	err := instanceCreation.GetWebdavCredentials(r.Client, ctx, log, r.WebdavSecretName, labInstance.Namespace, &user, &password)
-	if err != nil {
-		log.Error(err, "unable to get Webdav Credentials")
-	} else {
+	// the Nextcloud drive is not mounted during exams
+	var drive *instanceCreation.WebdavCredentials
+	if exam == nil {
+		drive = r.getDrive(ctx, log, &labInstance)
 		log.Info("Webdav secrets obtained. Building cloud-init script." + labInstance.Name)
 	}
-	secret := instanceCreation.CreateSecret(name, namespace, user, password, r.NextcloudBaseUrl)
+
+	// the key used to collect the work of the student is authorized in the VM through cloud-init
+	// (or through the provisioning job, replacing the key of the pooled VMI)
+	var collector *instanceCreation.CollectorAccount
+	if labTemplate.Spec.Collection != nil {
+		privateKey, authorizedKey, err := instanceCreation.GenerateCollectorKey()
+		if err != nil {
+			log.Error(err, "unable to generate the collector key")
+		} else {
+			keySecret := instanceCreation.CreateCollectorKeySecret(name, namespace, privateKey)
+			keySecret.SetOwnerReferences(labiOwnerRef)
+			if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, keySecret); err == nil {
+				collector = &instanceCreation.CollectorAccount{
+					AuthorizedKey:  authorizedKey,
+					Sudo:           instanceCreation.CollectorSudo(labTemplate.Spec.Collection.Path),
+					CollectionPath: labTemplate.Spec.Collection.Path,
+				}
+			}
+		}
+	}
+	secret := instanceCreation.CreateSecret(name, namespace, drive, settings.NextcloudBaseUrl, collector)
 	secret.SetOwnerReferences(labiOwnerRef)
-	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, secret); err != nil {
+	if pooledVmi != nil {
+		// pooled VMIs already booted, hence they are configured through ssh instead of cloud-init
+		if err := r.provisionPooledVmi(ctx, log, &labInstance, pooledVmi, name, drive, collector); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not provision vmi "+pooledVmi.Name+" in namespace "+pooledVmi.Namespace, "Warning", "VmiNotProvisioned", &labInstance, "", "")
+		} else {
+			setLabInstanceStatus(r, ctx, log, "Provisioning of vmi "+pooledVmi.Name+" started in namespace "+pooledVmi.Namespace, "Normal", "VmiProvisioning", &labInstance, "", "")
+		}
+	} else if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, secret); err != nil {
 		setLabInstanceStatus(r, ctx, log, "Could not create secret "+secret.Name+" in namespace "+secret.Namespace, "Warning", "SecretNotCreated", &labInstance, "", "")
 	} else {
 		setLabInstanceStatus(r, ctx, log, "Secret "+secret.Name+" correctly created in namespace "+secret.Namespace, "Normal", "SecretCreated", &labInstance, "", "")
 	}
 
-	// create Service to expose the vm
-	service := instanceCreation.CreateService(name, namespace)
-	service.SetOwnerReferences(labiOwnerRef)
-	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, service); err != nil {
-		setLabInstanceStatus(r, ctx, log, "Could not create service "+service.Name+" in namespace "+service.Namespace, "Warning", "ServiceNotCreated", &labInstance, "", "")
+	// create the private networks interconnecting the vms
+	for _, network := range labTemplate.Spec.Networks {
+		nad := instanceCreation.CreateNetworkAttachmentDefinition(name, namespace, network)
+		nad.SetOwnerReferences(labiOwnerRef)
+		if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, nad); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not create network "+nad.GetName()+" in namespace "+nad.GetNamespace(), "Warning", "NetworkNotCreated", &labInstance, "", "")
+			return ctrl.Result{}, err
+		} else {
+			setLabInstanceStatus(r, ctx, log, "Network "+nad.GetName()+" correctly created in namespace "+nad.GetNamespace(), "Normal", "NetworkCreated", &labInstance, "", "")
+		}
+	}
+
+	ingressSettings, err := r.ingressSettings(ctx, &labTemplate, settings)
+	if err != nil {
+		setLabInstanceStatus(r, ctx, log, "Could not retrieve the ingress settings of namespace "+labTemplate.Namespace, "Warning", "IngressNotCreated", &labInstance, "", "")
 		return ctrl.Result{}, err
-	} else {
-		setLabInstanceStatus(r, ctx, log, "Service "+service.Name+" correctly created in namespace "+service.Namespace, "Normal", "ServiceCreated", &labInstance, "", "")
 	}
 
 	urlUUID := uuid.New().String()
-	// create Ingress to manage the service
-	ingress := instanceCreation.CreateIngress(name, namespace, service, urlUUID, r.WebsiteBaseUrl)
-	ingress.SetOwnerReferences(labiOwnerRef)
-	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, ingress); err != nil {
-		setLabInstanceStatus(r, ctx, log, "Could not create ingress "+ingress.Name+" in namespace "+ingress.Namespace, "Warning", "IngressNotCreated", &labInstance, "", "")
-		return ctrl.Result{}, err
-	} else {
-		setLabInstanceStatus(r, ctx, log, "Ingress "+ingress.Name+" correctly created in namespace "+ingress.Namespace, "Normal", "IngressCreated", &labInstance, "", "")
+	vms := instanceCreation.TemplateVms(labTemplate)
+	services := make([]v1.Service, len(vms))
+	routes := make([]exposure.Route, len(vms))
+	for i, vm := range vms {
+		vmName := instanceCreation.VmResourceName(name, vm)
+
+		// create Service to expose the vm
+		services[i] = instanceCreation.CreateService(vmName, namespace)
+		service := &services[i]
+		service.SetOwnerReferences(labiOwnerRef)
+		if pooledVmi != nil {
+			// the pooled VMI belongs to the namespace of the LabTemplate, hence it is reached through manual endpoints
+			service.Spec.Selector = nil
+			endpoints := instanceCreation.CreatePooledVmEndpoints(vmName, namespace, instanceCreation.PooledVmiIP(*pooledVmi))
+			endpoints.SetOwnerReferences(labiOwnerRef)
+			if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, endpoints); err != nil {
+				setLabInstanceStatus(r, ctx, log, "Could not create endpoints "+endpoints.Name+" in namespace "+endpoints.Namespace, "Warning", "EndpointsNotCreated", &labInstance, "", "")
+				return ctrl.Result{}, err
+			}
+		}
+		if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, *service); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not create service "+service.Name+" in namespace "+service.Namespace, "Warning", "ServiceNotCreated", &labInstance, "", "")
+			return ctrl.Result{}, err
+		} else {
+			setLabInstanceStatus(r, ctx, log, "Service "+service.Name+" correctly created in namespace "+service.Namespace, "Normal", "ServiceCreated", &labInstance, "", "")
+		}
+
+		// create NetworkPolicy to isolate the vm
+		netpol := instanceCreation.CreateNetworkPolicy(name, namespace, vm, labTemplate.Spec.EgressPolicy, labTemplate.Namespace)
+		// during exams, the instances can reach only the DNS service and the allowed namespaces
+		if exam != nil && labTemplate.Spec.EgressPolicy != crownlabsalpha1.EgressNone {
+			instanceCreation.RestrictExamEgress(&netpol)
+		}
+		netpol.SetOwnerReferences(labiOwnerRef)
+		if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, netpol); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not create network policy "+netpol.Name+" in namespace "+netpol.Namespace, "Warning", "NetworkPolicyNotCreated", &labInstance, "", "")
+			return ctrl.Result{}, err
+		} else {
+			setLabInstanceStatus(r, ctx, log, "Network policy "+netpol.Name+" correctly created in namespace "+netpol.Namespace, "Normal", "NetworkPolicyCreated", &labInstance, "", "")
+		}
+
+		// create Ingress to manage the service
+		routes[i] = instanceCreation.CreateRoute(vmName, namespace, *service, urlUUID, vm,
+			instanceCreation.Oauth2RouteAuth(name, urlUUID, ingressSettings), ingressSettings)
+		route := &routes[i]
+		route.OwnerReferences = labiOwnerRef
+		if err := r.createRoute(ctx, log, *route); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not create route "+route.Name+" in namespace "+route.Namespace, "Warning", "IngressNotCreated", &labInstance, "", "")
+			return ctrl.Result{}, err
+		} else {
+			setLabInstanceStatus(r, ctx, log, "Route "+route.Name+" correctly created in namespace "+route.Namespace, "Normal", "IngressCreated", &labInstance, "", "")
+		}
 	}
 
 	// create Service for oauth2
@@ -189,18 +370,36 @@ func (r *LabInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	}
 
 	// create Ingress to manage the oauth2 service
-	oauthIngress := instanceCreation.CreateOauth2Ingress(name, namespace, oauthService, urlUUID, r.WebsiteBaseUrl)
-	oauthIngress.SetOwnerReferences(labiOwnerRef)
-	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, oauthIngress); err != nil {
-		setLabInstanceStatus(r, ctx, log, "Could not create ingress "+oauthIngress.Name+" in namespace "+oauthIngress.Namespace, "Warning", "Oauth2IngressNotCreated", &labInstance, "", "")
-		return ctrl.Result{}, err
-	} else {
-		setLabInstanceStatus(r, ctx, log, "Ingress "+oauthIngress.Name+" correctly created in namespace "+oauthIngress.Namespace, "Normal", "Oauth2IngressCreated", &labInstance, "", "")
+	for _, oauthRoute := range instanceCreation.CreateOauth2Routes(name, namespace, oauthService, urlUUID, vms, ingressSettings) {
+		oauthRoute.OwnerReferences = labiOwnerRef
+		if err := r.createRoute(ctx, log, oauthRoute); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not create route "+oauthRoute.Name+" in namespace "+oauthRoute.Namespace, "Warning", "Oauth2IngressNotCreated", &labInstance, "", "")
+			return ctrl.Result{}, err
+		} else {
+			setLabInstanceStatus(r, ctx, log, "Route "+oauthRoute.Name+" correctly created in namespace "+oauthRoute.Namespace, "Normal", "Oauth2IngressCreated", &labInstance, "", "")
+		}
 	}
 
 	// create Deployment for oauth2
-	oauthDeploy := instanceCreation.CreateOauth2Deployment(name, namespace, urlUUID, r.Oauth2ProxyImage, r.OidcClientSecret, r.OidcProviderUrl)
+	oauthDeploy := instanceCreation.CreateOauth2Deployment(name, namespace, urlUUID, settings.Oauth2.ProxyImage, settings.Oauth2.ClientID, settings.Oauth2.ClientSecret, settings.Oauth2.ProviderUrl)
 	oauthDeploy.SetOwnerReferences(labiOwnerRef)
+	oauthDeploy.Spec.Template.Spec.PriorityClassName = priorityClass
+	// the LabInstance of a team is accessible only by its members
+	if team := labInstance.Status.Team; team != nil {
+		instanceCreation.SetOauth2Groups(&oauthDeploy, instanceCreation.TeamGroups(*team))
+	} else if course := labTemplate.Labels[courses.CourseLabel]; r.CourseGroups && course != "" {
+		groups := append(instanceCreation.InstanceTenants(labInstance),
+			courses.AdminGroupName(&crownlabsalpha1.Course{ObjectMeta: metav1.ObjectMeta{Name: course}}))
+		instanceCreation.SetOauth2Groups(&oauthDeploy, groups)
+	}
+	// in the subdomain mode, the oauth2-proxy serves the hosts of all the vms
+	if ingressSettings.Mode == crownlabsalpha1.IngressSubdomainMode {
+		hosts := make([]string, len(routes))
+		for i := range routes {
+			hosts[i] = routes[i].Host
+		}
+		instanceCreation.SetOauth2Subdomains(&oauthDeploy, hosts)
+	}
 	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, oauthDeploy); err != nil {
 		setLabInstanceStatus(r, ctx, log, "Could not create deployment "+oauthDeploy.Name+" in namespace "+oauthDeploy.Namespace, "Warning", "Oauth2DeployNotCreated", &labInstance, "", "")
 		return ctrl.Result{}, err
@@ -208,23 +407,179 @@ func (r *LabInstanceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		setLabInstanceStatus(r, ctx, log, "Deployment "+oauthDeploy.Name+" correctly created in namespace "+oauthDeploy.Namespace, "Normal", "Oauth2DeployCreated", &labInstance, "", "")
 	}
 
-	// create VirtualMachineInstance
-	vmi := instanceCreation.CreateVirtualMachineInstance(name, namespace, labTemplate, labInstance.Name, secret.Name)
-	vmi.SetOwnerReferences(labiOwnerRef)
-	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, vmi); err != nil {
-		setLabInstanceStatus(r, ctx, log, "Could not create vmi "+vmi.Name+" in namespace "+vmi.Namespace, "Warning", "VmiNotCreated", &labInstance, "", "")
-		return ctrl.Result{}, err
-	} else {
-		setLabInstanceStatus(r, ctx, log, "VirtualMachineInstance "+vmi.Name+" correctly created in namespace "+vmi.Namespace, "Normal", "VmiCreated", &labInstance, "", "")
+	// in multi-VM laboratories, the status of each vm is tracked separately
+	labInstance.Status.Vms = nil
+	labInstance.Status.Readiness = nil
+	labInstance.Status.Failure = nil
+	if len(labTemplate.Spec.Vms) > 0 {
+		for _, vm := range vms {
+			labInstance.Status.Vms = append(labInstance.Status.Vms, crownlabsalpha1.VmStatus{Name: vm.Name, Phase: "VmiCreated"})
+		}
 	}
+
+	// create VirtualMachineInstances
+	vmis := make([]virtv1.VirtualMachineInstance, len(vms))
+	for i, vm := range vms {
+		if pooledVmi != nil {
+			// pooled VMIs are available only for single-VM laboratories
+			vmis[i] = *pooledVmi
+			continue
+		}
+		vmis[i] = instanceCreation.CreateVirtualMachineInstance(name, namespace, labTemplate, vm, labInstance.Name, secret.Name)
+		vmi := &vmis[i]
+		vmi.SetOwnerReferences(labiOwnerRef)
+		vmi.Spec.PriorityClassName = priorityClass
+		if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, *vmi); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not create vmi "+vmi.Name+" in namespace "+vmi.Namespace, "Warning", "VmiNotCreated", &labInstance, "", "")
+			return ctrl.Result{}, err
+		} else {
+			setLabInstanceStatus(r, ctx, log, "VirtualMachineInstance "+vmi.Name+" correctly created in namespace "+vmi.Namespace, "Normal", "VmiCreated", &labInstance, "", "")
+		}
+	}
+
+	r.startUsageRecord(ctx, log, &labInstance, &labTemplate, name, VMstart)
+
 	VmElaborationTimestamp := time.Now()
 	VMElaborationDuration := VmElaborationTimestamp.Sub(VMstart)
-	elaborationTimes.Observe(VMElaborationDuration.Seconds())
-	go getVmiStatus(r, ctx, log, name, vmType, service, ingress, &labInstance, vmi, VMstart)
+	elaborationTimes.WithLabelValues(templateLabelValues(&labInstance)...).Observe(VMElaborationDuration.Seconds())
+	for i, vm := range vms {
+		if len(labTemplate.Spec.ReadinessChecks) == 0 {
+			labTemplate.Spec.ReadinessChecks = settings.Readiness.DefaultChecks
+		}
+		checks := readiness.Checks(labTemplate, vm)
+		go getVmiStatus(r, ctx, log, vm.Name, checks, routes[i].URL(), &labInstance, vmis[i], VMstart)
+	}
 
 	return ctrl.Result{}, nil
 }
 
+// reconcileLifecycle enforces the behaviour of a LabInstance whose resources have already been created.
+func (r *LabInstanceReconciler) reconcileLifecycle(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance) (ctrl.Result, error) {
+
+	templateName := types.NamespacedName{
+		Namespace: labInstance.Spec.LabTemplateNamespace,
+		Name:      labInstance.Spec.LabTemplateName,
+	}
+	var labTemplate crownlabsalpha1.LabTemplate
+	if err := r.Get(ctx, templateName, &labTemplate); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// the revision the LabInstance has been created from is enforced, if still available
+	if revision := labInstance.Status.LabTemplateRevision; revision != 0 {
+		if err := revisions.Apply(ctx, r.Client, &labTemplate, revision); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	result, err := r.enforceExamDeadline(ctx, log, labInstance, &labTemplate)
+	if err != nil {
+		return result, err
+	}
+
+	if changed, powerResult, err := r.reconcilePower(ctx, log, labInstance, &labTemplate); changed || err != nil {
+		return mergeResults(result, powerResult), err
+	}
+
+	restartResult, err := r.restartVmis(ctx, log, labInstance, &labTemplate)
+	result = mergeResults(result, restartResult)
+	if err != nil {
+		return result, err
+	}
+
+	collectionResult, err := r.reconcileCollection(ctx, log, labInstance, &labTemplate)
+	if err != nil {
+		return mergeResults(result, collectionResult), err
+	}
+	return mergeResults(result, collectionResult), r.stopEndedExam(ctx, log, labInstance, &labTemplate)
+}
+
+// createRoute creates the resource implementing the route, according to the exposure of the operator
+func (r *LabInstanceReconciler) createRoute(ctx context.Context, log logr.Logger, route exposure.Route) error {
+	object, err := r.Exposer.Object(route)
+	if err != nil {
+		return err
+	}
+	return instanceCreation.CreateOrUpdate(r.Client, ctx, log, object)
+}
+
+// mergeResults combines two reconciliation results, requeueing after the shortest requested period
+func mergeResults(a, b ctrl.Result) ctrl.Result {
+	result := ctrl.Result{Requeue: a.Requeue || b.Requeue, RequeueAfter: a.RequeueAfter}
+	if result.RequeueAfter == 0 || (b.RequeueAfter > 0 && b.RequeueAfter < result.RequeueAfter) {
+		result.RequeueAfter = b.RequeueAfter
+	}
+	return result
+}
+
+// pinnedRevision returns the revision the LabInstance is pinned to, if any. Since the revisions may lack the fixes
+// released by the teachers (e.g. after a rollback), the pin is honored only for the teachers of the course, i.e. for
+// the LabInstances of the namespace of the LabTemplate or of the tenants teaching its course, and ignored otherwise.
+func (r *LabInstanceReconciler) pinnedRevision(ctx context.Context, labInstance *crownlabsalpha1.LabInstance,
+	labTemplate *crownlabsalpha1.LabTemplate) (int64, error) {
+
+	revision := labInstance.Spec.LabTemplateRevision
+	if revision == 0 {
+		return revision, nil
+	}
+	if teacher, err := r.teachesTemplate(ctx, labInstance.Namespace, labTemplate); err != nil || teacher {
+		return revision, err
+	}
+	r.EventsRecorder.Event(labInstance, "Warning", "RevisionPinIgnored", fmt.Sprintf(
+		"Revision %v of LabTemplate %v can be requested only by the teachers of the course, the released one is used", revision, labTemplate.Name))
+	return 0, nil
+}
+
+// teachesTemplate returns whether the given namespace belongs to the teachers of the course of the LabTemplate, i.e.
+// it is the namespace of the LabTemplate itself or the one of a tenant teaching its course.
+func (r *LabInstanceReconciler) teachesTemplate(ctx context.Context, namespace string, labTemplate *crownlabsalpha1.LabTemplate) (bool, error) {
+	if namespace == labTemplate.Namespace {
+		return true, nil
+	}
+	_, taught, err := courses.TenantCourses(ctx, r.Client, namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, course := range taught {
+		if course == labTemplate.Namespace {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// instancePriority returns the priority tier of the LabInstance, raised to the teacher one for the teachers of the course
+func (r *LabInstanceReconciler) instancePriority(ctx context.Context, labInstance *crownlabsalpha1.LabInstance,
+	labTemplate *crownlabsalpha1.LabTemplate) (crownlabsalpha1.PriorityTier, error) {
+
+	teacher, err := r.teachesTemplate(ctx, labInstance.Namespace, labTemplate)
+	if err != nil {
+		return "", err
+	}
+	return instanceCreation.InstancePriority(*labInstance, *labTemplate, teacher), nil
+}
+
+// applyRevision replaces the specification of the LabTemplate with the one of the revision the LabInstance is
+// created from: the one it is pinned to, the one it has already been created from, or the released one otherwise.
+// It returns the revision, and a NotFound error if it does not exist.
+func (r *LabInstanceReconciler) applyRevision(ctx context.Context, labInstance *crownlabsalpha1.LabInstance,
+	labTemplate *crownlabsalpha1.LabTemplate) (int64, error) {
+
+	revision, err := r.pinnedRevision(ctx, labInstance, labTemplate)
+	if err != nil {
+		return 0, err
+	}
+	if revision == 0 {
+		revision = labInstance.Status.LabTemplateRevision
+	}
+	if revision == 0 {
+		if revision, err = revisions.Released(ctx, r.Client, r.Scheme, labTemplate); err != nil {
+			return revision, err
+		}
+	}
+	return revision, revisions.Apply(ctx, r.Client, labTemplate, revision)
+}
+
 func (r *LabInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&crownlabsalpha1.LabInstance{}).
@@ -238,6 +593,8 @@ func setLabInstanceStatus(r *LabInstanceReconciler, ctx context.Context, log log
 	log.Info(msg)
 	r.EventsRecorder.Event(labInstance, eventType, eventReason, msg)
 
+	r.phases.transition(instanceKey(labInstance), labInstance, eventReason)
+	tracing.SpanFromContext(ctx).Step(eventReason, eventType == "Warning")
 	labInstance.Status.Phase = eventReason
 	labInstance.Status.IP = ip
 	labInstance.Status.Url = url
@@ -247,14 +604,94 @@ func setLabInstanceStatus(r *LabInstanceReconciler, ctx context.Context, log log
 	}
 }
 
+// rejectLabInstance reports why the LabInstance cannot be created, without updating the ObservedGeneration, so that
+// its creation is attempted again (e.g. once the quota is freed or the tenant is enrolled) after the requeue period.
+func (r *LabInstanceReconciler) rejectLabInstance(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, reason, msg string) ctrl.Result {
+
+	// the event is recorded only once, rather than at every attempt
+	if labInstance.Status.Phase != reason {
+		log.Info(msg)
+		r.EventsRecorder.Event(labInstance, "Warning", reason, msg)
+		r.phases.transition(instanceKey(labInstance), labInstance, reason)
+		labInstance.Status.Phase = reason
+	}
+	if err := r.Status().Update(ctx, labInstance); err != nil {
+		log.Error(err, "unable to update LabInstance status")
+	}
+	return ctrl.Result{RequeueAfter: rejectionRequeuePeriod}
+}
+
+// setVmStatus updates the status of one of the VMs of the LabInstance, identified by its name in multi-VM
+// laboratories (and empty otherwise). The overall phase is ready only when all the VMs are ready, while the
+// IP and the URL of the LabInstance are the ones of the first VM.
+func setVmStatus(r *LabInstanceReconciler, ctx context.Context, log logr.Logger,
+	msg string, eventType string, eventReason string,
+	labInstance *crownlabsalpha1.LabInstance, vmName, ip, url string) {
+
+	if vmName == "" {
+		setLabInstanceStatus(r, ctx, log, msg, eventType, eventReason, labInstance, ip, url)
+		return
+	}
+
+	// the VMs are monitored by concurrent goroutines
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+
+	log.Info(msg)
+	r.EventsRecorder.Event(labInstance, eventType, eventReason, msg)
+
+	vms := labInstance.Status.Vms
+	for i := range vms {
+		if vms[i].Name == vmName {
+			vms[i].Phase = eventReason
+			vms[i].IP = ip
+			vms[i].Url = url
+		}
+	}
+
+	tracing.SpanFromContext(ctx).Step(eventReason, eventType == "Warning")
+	labInstance.Status.Phase = aggregateVmPhase(vms)
+	r.phases.transition(instanceKey(labInstance), labInstance, labInstance.Status.Phase)
+	labInstance.Status.IP = vms[0].IP
+	labInstance.Status.Url = vms[0].Url
+	labInstance.Status.ObservedGeneration = labInstance.ObjectMeta.Generation
+	if err := r.Status().Update(ctx, labInstance); err != nil {
+		log.Error(err, "unable to update LabInstance status")
+	}
+}
+
+// aggregateVmPhase returns the overall phase of a multi-VM LabInstance: it is failed if any VM failed, ready
+// if all the VMs are ready, and equal to the phase of the first VM not ready yet otherwise.
+func aggregateVmPhase(vms []crownlabsalpha1.VmStatus) string {
+	for _, vm := range vms {
+		if vm.Phase == "VmiFailed" {
+			return vm.Phase
+		}
+	}
+	for _, vm := range vms {
+		if vm.Phase != "VmiReady" {
+			return vm.Phase
+		}
+	}
+	return "VmiReady"
+}
+
 func getVmiStatus(r *LabInstanceReconciler, ctx context.Context, log logr.Logger,
-	name string, vmType crownlabsalpha1.VmType, service v1.Service, ingress v1beta1.Ingress,
+	vmName string, checks []crownlabsalpha1.ReadinessCheck, url string,
 	labInstance *crownlabsalpha1.LabInstance, vmi virtv1.VirtualMachineInstance, startTimeVM time.Time) {
 
+	// the boot of each VM is traced as a child of the root span of the LabInstance
+	ctx, span := r.Tracer.StartSpan(r.Tracer.InstanceContext(ctx, labInstance.UID, labInstance.Generation),
+		"vmi "+vmi.Name, instanceAttributes(labInstance))
+	defer span.End()
+
 	var vmStatus virtv1.VirtualMachineInstancePhase
 
 	var ip string
-	url := ingress.GetAnnotations()["crownlabs.polito.it/probe-url"]
+
+	vmiFailed := false
+	pendingSince := time.Now()
 
 	// iterate until the vm is running
 	for {
@@ -262,6 +699,11 @@ func getVmiStatus(r *LabInstanceReconciler, ctx context.Context, log logr.Logger
 			Namespace: vmi.Namespace,
 			Name:      vmi.Name,
 		}, &vmi)
+		if errors.IsNotFound(err) {
+			// the vmi has been deleted, e.g. together with the LabInstance
+			log.Info("VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace + " not found")
+			return
+		}
 		if err == nil {
 			if vmStatus != vmi.Status.Phase {
 				vmStatus = vmi.Status.Phase
@@ -271,52 +713,106 @@ func getVmiStatus(r *LabInstanceReconciler, ctx context.Context, log logr.Logger
 
 				msg := "VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace + " status update to " + string(vmStatus)
 				if vmStatus == virtv1.Failed {
-					setLabInstanceStatus(r, ctx, log, msg, "Warning", "Vmi"+string(vmStatus), labInstance, "", "")
-					return
+					if message, preempted := r.preemptionMessage(ctx, &vmi); preempted {
+						msg = "VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace + " preempted: " + message
+						r.statusLock.Lock()
+						setPreemptedCondition(labInstance, vmi.Name, message)
+						r.statusLock.Unlock()
+					}
+					setVmStatus(r, ctx, log, msg, "Warning", "Vmi"+string(vmStatus), labInstance, vmName, "", "")
+					setFailureStatus(r, ctx, log, labInstance, vmName, r.diagnoseVmi(ctx, &vmi))
+					vmiFailed = true
+					break
 				}
 
-				setLabInstanceStatus(r, ctx, log, msg, "Normal", "Vmi"+string(vmStatus), labInstance, ip, url)
+				setVmStatus(r, ctx, log, msg, "Normal", "Vmi"+string(vmStatus), labInstance, vmName, ip, url)
 				if vmStatus == virtv1.Running {
 					break
 				}
 			}
+
+			// the causes preventing the vm from starting (e.g. scheduling failures) are reported while waiting
+			if (vmStatus == virtv1.Pending || vmStatus == virtv1.Scheduling) && time.Since(pendingSince) > pendingDiagnosisPeriod {
+				pendingSince = time.Now()
+				if diagnosis := r.diagnoseVmi(ctx, &vmi); diagnosis.Reason != diagnostics.Unknown {
+					setFailureStatus(r, ctx, log, labInstance, vmName, diagnosis)
+				}
+			}
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
 
-	// when the vm status is Running, it is still not available for some seconds
-	// hence, wait until it starts responding
-	host := service.Name + "." + service.Namespace
-	port := "6080" // VNC
-	if vmType == crownlabsalpha1.TypeCLI {
-		port = "22" // SSH
+	if !vmiFailed {
+		// when the vm status is Running, it is still not available for some seconds
+		// hence, wait until the readiness checks succeed
+		// the VMI is probed directly, since the checks may target ports not exposed by its service
+		if len(vmi.Status.Interfaces) > 0 {
+			ip = vmi.Status.Interfaces[0].IP
+		}
+		prober := readiness.Prober{
+			Host: ip,
+			GetVmi: func(ctx context.Context) (*virtv1.VirtualMachineInstance, error) {
+				err := r.Get(ctx, types.NamespacedName{Namespace: vmi.Namespace, Name: vmi.Name}, &vmi)
+				return &vmi, err
+			},
+		}
+		result := prober.Run(ctx, checks, func(status crownlabsalpha1.ReadinessStatus) {
+			setReadinessStatus(r, ctx, log, labInstance, vmName, status)
+		})
+		if result.Result == readiness.Passed {
+			msg := "VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace + " status update to VmiReady."
+			// the failure which caused the VM to be restarted has been solved
+			r.statusLock.Lock()
+			if failure := labInstance.Status.Failure; failure != nil && failure.Vm == vmName {
+				labInstance.Status.Failure = nil
+			}
+			r.statusLock.Unlock()
+			setVmStatus(r, ctx, log, msg, "Normal", "VmiReady", labInstance, vmName, ip, url)
+			if labInstance.Status.Phase == "VmiReady" {
+				r.readyUsageRecords(ctx, log, labInstance)
+				r.endInstanceTrace(labInstance, startTimeVM, "")
+			}
+			readyTime := time.Now()
+			bootTime := readyTime.Sub(startTimeVM)
+			bootTimes.WithLabelValues(templateLabelValues(labInstance)...).Observe(bootTime.Seconds())
+			return
+		}
+
+		log.Info(fmt.Sprintf("Readiness check %v of VirtualMachineInstance %v failed after %v attempts: %v",
+			result.Check, vmi.Name, result.Attempts, result.Reason))
+		probeFailures.WithLabelValues(templateLabelValues(labInstance)...).Inc()
+		setFailureStatus(r, ctx, log, labInstance, vmName, diagnostics.DiagnoseReadiness(result))
 	}
 
-	err := waitForConnection(log, host, port)
-	if err != nil {
-		log.Error(err, fmt.Sprintf("Unable to check whether %v:%v is reachable", host, port))
-	} else {
-		msg := "VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace + " status update to VmiReady."
-		setLabInstanceStatus(r, ctx, log, msg, "Normal", "VmiReady", labInstance, ip, url)
-		readyTime := time.Now()
-		bootTime := readyTime.Sub(startTimeVM)
-		bootTimes.Observe(bootTime.Seconds())
+	// the vm is restarted in case of failures, up to the retry limit, and monitored again by restartVmis
+	if !r.retryVmi(ctx, log, labInstance, vmName, url, &vmi) {
+		failure := "VirtualMachineInstance " + vmi.Name + " in namespace " + vmi.Namespace + " failed"
+		if vmiFailed {
+			r.stopUsageRecords(ctx, log, labInstance.Namespace, labInstance.Name, time.Now())
+		}
+		span.Fail(failure)
+		r.endInstanceTrace(labInstance, startTimeVM, failure)
 	}
 }
 
-func waitForConnection(log logr.Logger, host, port string) error {
-	for retries := 0; retries < 120; retries++ {
-		timeout := time.Second
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
-		if err != nil {
-			log.Info(fmt.Sprintf("Unable to check whether %v:%v is reachable: %v", host, port, err))
-			time.Sleep(time.Second)
-		} else {
-			// The connection succeeded, hence the VM is ready
-			defer conn.Close()
-			return nil
+// setReadinessStatus reports the result of the readiness checks of one of the VMs of the LabInstance, identified
+// by its name in multi-VM laboratories (and empty otherwise).
+func setReadinessStatus(r *LabInstanceReconciler, ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, vmName string, status crownlabsalpha1.ReadinessStatus) {
+
+	// the VMs are monitored by concurrent goroutines
+	r.statusLock.Lock()
+	defer r.statusLock.Unlock()
+
+	if vmName == "" {
+		labInstance.Status.Readiness = &status
+	}
+	for i := range labInstance.Status.Vms {
+		if labInstance.Status.Vms[i].Name == vmName {
+			labInstance.Status.Vms[i].Readiness = &status
 		}
 	}
-
-	return fmt.Errorf("Timeout while checking whether %v:%v is reachable", host, port)
+	if err := r.Status().Update(ctx, labInstance); err != nil {
+		log.Error(err, "unable to update LabInstance status")
+	}
 }
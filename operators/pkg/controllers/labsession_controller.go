@@ -0,0 +1,250 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/enrolment"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	sessionScheduled  = "Scheduled"
+	sessionPrewarming = "Prewarming"
+	sessionRunning    = "Running"
+	sessionEnded      = "Ended"
+	sessionRejected   = "Rejected"
+
+	// sessionFinalizer guarantees the deletion of the LabInstances of a LabSession, which cannot be owned by it
+	sessionFinalizer = "crownlabs.polito.it/labsession"
+
+	defaultSessionPrewarm = 10 * time.Minute
+)
+
+// LabSessionReconciler reconciles a LabSession object
+type LabSessionReconciler struct {
+	client.Client
+	Log            logr.Logger
+	Scheme         *runtime.Scheme
+	EventsRecorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labsessions,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labsessions/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labtemplates,verbs=get;list;watch
+
+func (r *LabSessionReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("labsession", req.NamespacedName)
+
+	var session crownlabsalpha1.LabSession
+	if err := r.Get(ctx, req.NamespacedName, &session); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !session.DeletionTimestamp.IsZero() {
+		if !containsString(session.Finalizers, sessionFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.deleteSessionInstances(ctx, log, &session, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		session.Finalizers = removeString(session.Finalizers, sessionFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &session)
+	}
+
+	if !containsString(session.Finalizers, sessionFinalizer) {
+		session.Finalizers = append(session.Finalizers, sessionFinalizer)
+		if err := r.Update(ctx, &session); err != nil {
+			log.Error(err, "unable to add the finalizer to LabSession "+session.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	rejection, err := r.authorizeSession(ctx, &session)
+	if err != nil {
+		log.Error(err, "unable to authorize LabSession "+session.Name)
+		return ctrl.Result{}, err
+	}
+
+	prewarm := defaultSessionPrewarm
+	if session.Spec.Prewarm != nil {
+		prewarm = session.Spec.Prewarm.Duration
+	}
+	now := time.Now()
+	prewarmTime := session.Spec.StartTime.Add(-prewarm)
+
+	status := session.Status
+	var result ctrl.Result
+	switch {
+	case rejection != "":
+		if err := r.deleteSessionInstances(ctx, log, &session, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		status.Phase = sessionRejected
+		status.Instances = 0
+		status.FailedStudents = nil
+
+	case now.Before(prewarmTime):
+		status.Phase = sessionScheduled
+		result.RequeueAfter = prewarmTime.Sub(now)
+
+	case now.Before(session.Spec.EndTime.Time):
+		status.Phase = sessionRunning
+		result.RequeueAfter = session.Spec.EndTime.Sub(now)
+		if now.Before(session.Spec.StartTime.Time) {
+			status.Phase = sessionPrewarming
+			result.RequeueAfter = session.Spec.StartTime.Sub(now)
+		}
+
+		status.FailedStudents = r.createSessionInstances(ctx, log, &session)
+		status.Instances = len(session.Spec.StudentIDs) - len(status.FailedStudents)
+		// the instances of the students removed from the session are deleted
+		if err := r.deleteSessionInstances(ctx, log, &session, session.Spec.StudentIDs); err != nil {
+			return ctrl.Result{}, err
+		}
+
+	default:
+		if err := r.deleteSessionInstances(ctx, log, &session, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		status.Phase = sessionEnded
+		status.Instances = 0
+		status.FailedStudents = nil
+	}
+
+	// the status is updated only if changed, since each update triggers a new reconciliation
+	if !reflect.DeepEqual(status, session.Status) {
+		if status.Phase == sessionRejected && session.Status.Phase != sessionRejected {
+			r.EventsRecorder.Event(&session, "Warning", status.Phase, "LabSession "+session.Name+" rejected: "+rejection)
+		} else if status.Phase != session.Status.Phase {
+			r.EventsRecorder.Event(&session, "Normal", status.Phase, "LabSession "+session.Name+" is now "+status.Phase)
+		}
+		session.Status = status
+		if err := r.Status().Update(ctx, &session); err != nil {
+			log.Error(err, "unable to update LabSession status")
+			return ctrl.Result{}, err
+		}
+	}
+	return result, nil
+}
+
+// authorizeSession verifies that the LabSession has been created by the teachers of the course of its LabTemplate,
+// since it creates LabInstances in the namespaces of other tenants. The creator of a resource is not recorded,
+// hence the LabSession is accepted only if it belongs to the namespace of its LabTemplate, which only the teachers
+// of the course can write to. It returns the reason of the rejection, or an empty string if accepted.
+func (r *LabSessionReconciler) authorizeSession(ctx context.Context, session *crownlabsalpha1.LabSession) (string, error) {
+	if session.Spec.LabTemplateNamespace != "" && session.Spec.LabTemplateNamespace != session.Namespace {
+		return "the LabTemplate must belong to the namespace of the LabSession", nil
+	}
+	var labTemplate crownlabsalpha1.LabTemplate
+	name := types.NamespacedName{Namespace: session.Namespace, Name: session.Spec.LabTemplateName}
+	if err := r.Get(ctx, name, &labTemplate); err != nil {
+		if errors.IsNotFound(err) {
+			return "LabTemplate " + session.Spec.LabTemplateName + " not found in namespace " + session.Namespace, nil
+		}
+		return "", err
+	}
+	return "", nil
+}
+
+// createSessionInstances creates the LabInstances of the students attending the session, if not already present.
+// It returns the students whose LabInstance could not be created (e.g. because their tenant namespace does not exist,
+// or they are not enrolled in the course).
+func (r *LabSessionReconciler) createSessionInstances(ctx context.Context, log logr.Logger,
+	session *crownlabsalpha1.LabSession) []string {
+
+	var failed []string
+	for _, studentID := range session.Spec.StudentIDs {
+		labInstance := instanceCreation.CreateSessionLabInstance(*session, studentID)
+		if authorized, err := enrolment.Authorized(ctx, r.Client, labInstance.Namespace, session.Namespace); err != nil || !authorized {
+			message := studentID + " is not enrolled in the course of namespace " + session.Namespace
+			if err != nil {
+				message = err.Error()
+			}
+			r.EventsRecorder.Event(session, "Warning", "LabInstanceNotCreated",
+				"Could not create LabInstance "+labInstance.Name+" in namespace "+labInstance.Namespace+": "+message)
+			failed = append(failed, studentID)
+			continue
+		}
+		if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, labInstance); err != nil {
+			r.EventsRecorder.Event(session, "Warning", "LabInstanceNotCreated",
+				"Could not create LabInstance "+labInstance.Name+" in namespace "+labInstance.Namespace+": "+err.Error())
+			failed = append(failed, studentID)
+		}
+	}
+	return failed
+}
+
+// deleteSessionInstances deletes the LabInstances created for the session, except the ones of the given students.
+func (r *LabSessionReconciler) deleteSessionInstances(ctx context.Context, log logr.Logger,
+	session *crownlabsalpha1.LabSession, keep []string) error {
+
+	var instances crownlabsalpha1.LabInstanceList
+	if err := r.List(ctx, &instances, client.MatchingLabels(instanceCreation.SessionLabels(*session))); err != nil {
+		log.Error(err, "unable to list the LabInstances of LabSession "+session.Name)
+		return err
+	}
+
+	for i := range instances.Items {
+		labInstance := &instances.Items[i]
+		if containsString(keep, labInstance.Spec.StudentID) {
+			continue
+		}
+		if err := r.Delete(ctx, labInstance); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to delete LabInstance "+labInstance.Name+" in namespace "+labInstance.Namespace)
+			return err
+		}
+		log.Info("LabInstance " + labInstance.Name + " in namespace " + labInstance.Namespace + " deleted")
+	}
+	return nil
+}
+
+func (r *LabSessionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&crownlabsalpha1.LabSession{}).
+		Complete(r)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	var result []string
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
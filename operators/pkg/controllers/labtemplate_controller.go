@@ -0,0 +1,247 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/revisions"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// poolResyncPeriod is the period the warm pools are checked at, to release the VMIs of deleted LabInstances
+const poolResyncPeriod = 30 * time.Second
+
+// LabTemplateReconciler reconciles a LabTemplate object, recording the revisions of its specification and
+// maintaining its warm pool of pre-booted VMs
+type LabTemplateReconciler struct {
+	client.Client
+	Log            logr.Logger
+	Scheme         *runtime.Scheme
+	EventsRecorder record.EventRecorder
+	// PriorityClasses maps the priority tiers to the PriorityClasses of the pooled VMIs
+	PriorityClasses map[crownlabsalpha1.PriorityTier]string
+}
+
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labtemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups=crownlabs.polito.it,resources=labtemplates/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;delete
+
+func (r *LabTemplateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("labtemplate", req.NamespacedName)
+
+	var labTemplate crownlabsalpha1.LabTemplate
+	if err := r.Get(ctx, req.NamespacedName, &labTemplate); err != nil {
+		// the pool is deleted together with the LabTemplate, through the owner references
+		poolSize.DeleteLabelValues(req.Namespace, req.Name)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	latest, err := revisions.Record(ctx, r.Client, r.Scheme, &labTemplate)
+	if err != nil {
+		log.Error(err, "unable to record the revision of the LabTemplate")
+		return ctrl.Result{}, err
+	}
+	released := latest
+	if labTemplate.Spec.Release != nil {
+		released = *labTemplate.Spec.Release
+	}
+	r.pruneRevisions(ctx, log, &labTemplate)
+
+	// the VMIs of the warm pool are created from the released revision, hence the pool is drained if it does not exist
+	pooled := labTemplate.DeepCopy()
+	if err := revisions.Apply(ctx, r.Client, pooled, released); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		r.EventsRecorder.Event(&labTemplate, "Warning", "RevisionNotFound", fmt.Sprintf("The released revision %v does not exist", released))
+		pooled.Spec.WarmPool = nil
+	}
+	revisionLabel := strconv.FormatInt(released, 10)
+
+	var vmis virtv1.VirtualMachineInstanceList
+	if err := r.List(ctx, &vmis, client.InNamespace(labTemplate.Namespace),
+		client.MatchingLabels{instanceCreation.PoolLabel: labTemplate.Name}); err != nil {
+		log.Error(err, "unable to list the VMIs of the warm pool")
+		return ctrl.Result{}, err
+	}
+
+	// the VMIs handed over to LabInstances are released once the LabInstances are deleted
+	var unassigned []virtv1.VirtualMachineInstance
+	for i := range vmis.Items {
+		vmi := &vmis.Items[i]
+		instanceName, assigned := vmi.Labels[instanceCreation.PoolInstanceNameLabel]
+		if !assigned {
+			// the VMIs of the other revisions are replaced, rolling out the released one
+			if vmi.Labels[instanceCreation.PoolRevisionLabel] != revisionLabel {
+				r.deletePooledVmi(ctx, log, vmi)
+				continue
+			}
+			unassigned = append(unassigned, *vmi)
+			continue
+		}
+		var labInstance crownlabsalpha1.LabInstance
+		name := types.NamespacedName{Namespace: vmi.Labels[instanceCreation.PoolInstanceNamespaceLabel], Name: instanceName}
+		if err := r.Get(ctx, name, &labInstance); errors.IsNotFound(err) {
+			r.deletePooledVmi(ctx, log, vmi)
+		}
+	}
+
+	var size int
+	if instanceCreation.PoolEnabled(*pooled) {
+		size = int(pooled.Spec.WarmPool.Size)
+		if err := r.createPoolResources(ctx, log, pooled); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// the pool is replenished, or shrunk in case its size has been reduced
+	for i := len(unassigned); i < size; i++ {
+		vmi := instanceCreation.CreatePoolVirtualMachineInstance(*pooled)
+		vmi.Labels[instanceCreation.PoolRevisionLabel] = revisionLabel
+		vmi.SetOwnerReferences(templateOwnerRef(&labTemplate))
+		vmi.Spec.PriorityClassName = r.PriorityClasses[instanceCreation.TemplatePriority(*pooled)]
+		if err := r.createPooledVmiSecrets(ctx, log, &labTemplate, &vmi); err != nil {
+			r.EventsRecorder.Event(&labTemplate, "Warning", "PoolVmiNotCreated", "Could not create the secrets of pooled vmi "+vmi.Name)
+			return ctrl.Result{}, err
+		}
+		if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, vmi); err != nil {
+			r.EventsRecorder.Event(&labTemplate, "Warning", "PoolVmiNotCreated", "Could not create pooled vmi "+vmi.Name)
+			return ctrl.Result{}, err
+		}
+		log.Info("Pooled VirtualMachineInstance " + vmi.Name + " created")
+		unassigned = append(unassigned, vmi)
+	}
+	for len(unassigned) > size {
+		r.deletePooledVmi(ctx, log, &unassigned[len(unassigned)-1])
+		unassigned = unassigned[:len(unassigned)-1]
+	}
+
+	status := crownlabsalpha1.LabTemplateStatus{LatestRevision: latest, ReleasedRevision: released}
+	for _, vmi := range unassigned {
+		if instanceCreation.PooledVmiIP(vmi) != "" {
+			status.PoolReady++
+		} else {
+			status.PoolBooting++
+		}
+	}
+	poolSize.WithLabelValues(labTemplate.Namespace, labTemplate.Name).Set(float64(status.PoolReady))
+	if status != labTemplate.Status {
+		labTemplate.Status = status
+		if err := r.Status().Update(ctx, &labTemplate); err != nil {
+			log.Error(err, "unable to update LabTemplate status")
+		}
+	}
+
+	if size == 0 && len(vmis.Items) == 0 {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: poolResyncPeriod}, nil
+}
+
+// createPoolResources creates the resources shared by the VMIs of the warm pool, i.e. the NetworkPolicy isolating them
+func (r *LabTemplateReconciler) createPoolResources(ctx context.Context, log logr.Logger, labTemplate *crownlabsalpha1.LabTemplate) error {
+	netpol := instanceCreation.CreatePoolNetworkPolicy(*labTemplate)
+	netpol.SetOwnerReferences(templateOwnerRef(labTemplate))
+	return instanceCreation.CreateOrUpdate(r.Client, ctx, log, netpol)
+}
+
+// createPooledVmiSecrets creates the cloud-init secret of a pooled VMI and the secret containing its key, which is
+// generated for each VMI, so that it grants access only to the VMI it is handed over with. The secrets are owned
+// by the LabTemplate, since they are created before the VMI, and deleted together with the VMI.
+func (r *LabTemplateReconciler) createPooledVmiSecrets(ctx context.Context, log logr.Logger,
+	labTemplate *crownlabsalpha1.LabTemplate, vmi *virtv1.VirtualMachineInstance) error {
+
+	privateKey, authorizedKey, err := instanceCreation.GenerateCollectorKey()
+	if err != nil {
+		log.Error(err, "unable to generate the key of pooled vmi "+vmi.Name)
+		return err
+	}
+	name := instanceCreation.PooledVmiName(*vmi)
+	secret := instanceCreation.CreateSecret(name, vmi.Namespace, nil, "",
+		&instanceCreation.CollectorAccount{AuthorizedKey: authorizedKey, Sudo: instanceCreation.ProvisioningSudo})
+	secret.SetOwnerReferences(templateOwnerRef(labTemplate))
+	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, secret); err != nil {
+		return err
+	}
+	keySecret := instanceCreation.CreateCollectorKeySecret(name, vmi.Namespace, privateKey)
+	keySecret.SetOwnerReferences(templateOwnerRef(labTemplate))
+	return instanceCreation.CreateOrUpdate(r.Client, ctx, log, keySecret)
+}
+
+// pruneRevisions deletes the oldest revisions of the LabTemplate exceeding its history limit, keeping the ones
+// the existing LabInstances have been created from (or are pinned to)
+func (r *LabTemplateReconciler) pruneRevisions(ctx context.Context, log logr.Logger, labTemplate *crownlabsalpha1.LabTemplate) {
+	var instances crownlabsalpha1.LabInstanceList
+	if err := r.List(ctx, &instances, client.MatchingLabels{
+		"template-name": labTemplate.Name, "template-namespace": labTemplate.Namespace}); err != nil {
+		log.Error(err, "unable to list the LabInstances of the LabTemplate")
+		return
+	}
+	inUse := map[int64]bool{}
+	for _, instance := range instances.Items {
+		inUse[instance.Spec.LabTemplateRevision], inUse[instance.Status.LabTemplateRevision] = true, true
+	}
+	deleted, err := revisions.Prune(ctx, r.Client, labTemplate, inUse)
+	for _, revision := range deleted {
+		log.Info(fmt.Sprintf("Revision %v of LabTemplate %v deleted", revision, labTemplate.Name))
+	}
+	if err != nil {
+		log.Error(err, "unable to prune the revisions of the LabTemplate")
+	}
+}
+
+func (r *LabTemplateReconciler) deletePooledVmi(ctx context.Context, log logr.Logger, vmi *virtv1.VirtualMachineInstance) {
+	if err := r.Delete(ctx, vmi); client.IgnoreNotFound(err) != nil {
+		log.Error(err, "unable to delete pooled vmi "+vmi.Name)
+		return
+	}
+	log.Info("Pooled VirtualMachineInstance " + vmi.Name + " deleted")
+
+	name := instanceCreation.PooledVmiName(*vmi)
+	for _, secretName := range []string{name + "-secret", name + "-collector-key"} {
+		secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: vmi.Namespace, Name: secretName}}
+		if err := r.Delete(ctx, &secret); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "unable to delete secret "+secretName)
+		}
+	}
+}
+
+func (r *LabTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&crownlabsalpha1.LabTemplate{}).
+		Owns(&virtv1.VirtualMachineInstance{}).
+		Complete(r)
+}
+
+func templateOwnerRef(labTemplate *crownlabsalpha1.LabTemplate) []metav1.OwnerReference {
+	return []metav1.OwnerReference{*metav1.NewControllerRef(labTemplate, crownlabsalpha1.GroupVersion.WithKind("LabTemplate"))}
+}
@@ -1,24 +1,181 @@
 package controllers
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
 	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
+// The metrics of the LabInstances are labelled by the namespace and the name of their LabTemplate,
+// to identify the courses and the templates which are slow or failing.
+var templateLabels = []string{"namespace", "template"}
+
 var (
-	bootTimes = prometheus.NewHistogram(prometheus.HistogramOpts{
+	bootTimes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "vmi_boot_time_seconds",
-		Help:    "The time required to boot for spawned VMs",
+		Help:    "The time required to boot for spawned VMs, per LabTemplate",
 		Buckets: prometheus.LinearBuckets(30, 10, 20),
-	})
-	elaborationTimes = prometheus.NewHistogram(prometheus.HistogramOpts{
+	}, templateLabels)
+	elaborationTimes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "vmi_elaboration_time_seconds",
-		Help:    "The time required to the operator logic to handle VMIs",
+		Help:    "The time required to the operator logic to handle VMIs, per LabTemplate",
 		Buckets: prometheus.LinearBuckets(0.5, 0.2, 20),
-	})
+	}, templateLabels)
+	phaseDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "labinstance_phase_duration_seconds",
+		Help:    "The time spent by the LabInstances in each phase, per LabTemplate",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 16),
+	}, append(templateLabels, "phase"))
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "labinstance_reconcile_errors_total",
+		Help: "The number of errors creating the resources of the LabInstances, per LabTemplate and step",
+	}, append(templateLabels, "step"))
+	probeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "vmi_readiness_probe_failures_total",
+		Help: "The number of VMs which did not become reachable after running, per LabTemplate",
+	}, templateLabels)
+	poolSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "warm_pool_ready_vmis",
+		Help: "The number of pre-booted VMs ready to be handed over, per LabTemplate",
+	}, templateLabels)
+	poolHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "warm_pool_hits_total",
+		Help: "The number of LabInstances served by a pre-booted VM, per LabTemplate",
+	}, templateLabels)
+	poolMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "warm_pool_misses_total",
+		Help: "The number of LabInstances of a LabTemplate with a warm pool which found it empty",
+	}, templateLabels)
 )
 
+// reconcileSteps maps the phases reporting a failure to the step of the reconciliation which failed
+var reconcileSteps = map[string]string{
+	"SecretNotCreated":        "secret",
+	"VmiNotProvisioned":       "vmi",
+	"NetworkNotCreated":       "network",
+	"ServiceNotCreated":       "service",
+	"EndpointsNotCreated":     "service",
+	"NetworkPolicyNotCreated": "network-policy",
+	"IngressNotCreated":       "ingress",
+	"Oauth2ServiceNotCreated": "oauth2",
+	"Oauth2IngressNotCreated": "oauth2",
+	"Oauth2DeployNotCreated":  "oauth2",
+	"VmiNotCreated":           "vmi",
+}
+
 func init() {
 	// Register custom metrics with the global prometheus registry
-	metrics.Registry.MustRegister(bootTimes, elaborationTimes)
+	metrics.Registry.MustRegister(bootTimes, elaborationTimes, phaseDurations, reconcileErrors,
+		probeFailures, poolSize, poolHits, poolMisses)
+}
+
+// templateLabelValues returns the values of the templateLabels of the metrics of a LabInstance
+func templateLabelValues(labInstance *crownlabsalpha1.LabInstance) []string {
+	return []string{labInstance.Spec.LabTemplateNamespace, labInstance.Spec.LabTemplateName}
+}
+
+// instanceKey returns the key identifying a LabInstance in the phaseTracker
+func instanceKey(labInstance *crownlabsalpha1.LabInstance) string {
+	return labInstance.Namespace + "/" + labInstance.Name
+}
+
+// phaseEntry is the phase of a LabInstance, together with the time it was entered and the labels of the LabInstance
+type phaseEntry struct {
+	phase   string
+	entered time.Time
+	labels  []string
+}
+
+// phaseTracker tracks the phase of the LabInstances, to measure the time spent in each phase. The phases are
+// tracked in memory, hence the transitions preceding a restart of the operator are not measured.
+type phaseTracker struct {
+	lock      sync.Mutex
+	instances map[string]phaseEntry
+}
+
+// transition records that the LabInstance with the given key is entering the given phase
+func (t *phaseTracker) transition(key string, labInstance *crownlabsalpha1.LabInstance, phase string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.instances == nil {
+		t.instances = map[string]phaseEntry{}
+	}
+	labels := templateLabelValues(labInstance)
+	if step, ok := reconcileSteps[phase]; ok {
+		reconcileErrors.WithLabelValues(append(labels, step)...).Inc()
+	}
+
+	previous, ok := t.instances[key]
+	if ok && previous.phase == phase {
+		return
+	}
+	if ok {
+		t.leave(previous)
+	}
+	t.instances[key] = phaseEntry{phase: phase, entered: time.Now(), labels: labels}
+}
+
+// forget stops tracking the LabInstance with the given key, e.g. since it has been deleted
+func (t *phaseTracker) forget(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if previous, ok := t.instances[key]; ok {
+		t.leave(previous)
+		delete(t.instances, key)
+	}
+}
+
+func (t *phaseTracker) leave(entry phaseEntry) {
+	labels := append(entry.labels, entry.phase)
+	phaseDurations.WithLabelValues(labels...).Observe(time.Since(entry.entered).Seconds())
+}
+
+// InstanceCollector exposes the number of LabInstances in each phase, per LabTemplate. The LabInstances are
+// listed at each scrape, so that the count does not depend on the ones handled since the operator started.
+type InstanceCollector struct {
+	reader    client.Reader
+	instances *prometheus.Desc
+}
+
+// NewInstanceCollector creates a collector counting the LabInstances available through the given reader
+func NewInstanceCollector(reader client.Reader) *InstanceCollector {
+	return &InstanceCollector{
+		reader: reader,
+		instances: prometheus.NewDesc("labinstances", "The number of LabInstances in each phase, per LabTemplate",
+			append(templateLabels, "phase"), nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *InstanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.instances
+}
+
+// Collect implements prometheus.Collector
+func (c *InstanceCollector) Collect(ch chan<- prometheus.Metric) {
+	var instances crownlabsalpha1.LabInstanceList
+	if err := c.reader.List(context.Background(), &instances); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.instances, err)
+		return
+	}
+
+	counts := map[[3]string]int{}
+	for i := range instances.Items {
+		labInstance := &instances.Items[i]
+		if labInstance.Status.Phase == "" {
+			continue
+		}
+		labels := templateLabelValues(labInstance)
+		counts[[3]string{labels[0], labels[1], labInstance.Status.Phase}]++
+	}
+	for labels, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.instances, prometheus.GaugeValue, float64(count), labels[:]...)
+	}
 }
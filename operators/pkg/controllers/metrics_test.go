@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestInstanceCollector(t *testing.T) {
+	s := runtime.NewScheme()
+	assert.NoError(t, crownlabsalpha1.AddToScheme(s))
+	instance := func(name, template, phase string) runtime.Object {
+		labInstance := &crownlabsalpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-s123456", Name: name}}
+		labInstance.Spec.LabTemplateNamespace, labInstance.Spec.LabTemplateName = "course-swnet", template
+		labInstance.Status.Phase = phase
+		return labInstance
+	}
+	// the LabInstances already existing when the operator starts are counted as well
+	c := fake.NewFakeClientWithScheme(s, instance("a", "lab1", "VmiReady"), instance("b", "lab1", "VmiReady"),
+		instance("c", "lab1", queued), instance("d", "lab2", "VmiReady"), instance("e", "lab2", ""))
+
+	expected := `
+# HELP labinstances The number of LabInstances in each phase, per LabTemplate
+# TYPE labinstances gauge
+labinstances{namespace="course-swnet",phase="Queued",template="lab1"} 1
+labinstances{namespace="course-swnet",phase="VmiReady",template="lab1"} 2
+labinstances{namespace="course-swnet",phase="VmiReady",template="lab2"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewInstanceCollector(c), strings.NewReader(expected)))
+}
@@ -0,0 +1,104 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// claimPooledVmi hands over a ready VMI of the warm pool of the LabTemplate to the LabInstance, labelling it
+// with the LabInstance it belongs to. It returns nil if the LabTemplate has no warm pool, or if it is empty.
+func (r *LabInstanceReconciler) claimPooledVmi(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate, name string) *virtv1.VirtualMachineInstance {
+
+	if !instanceCreation.PoolEnabled(*labTemplate) {
+		return nil
+	}
+
+	var vmis virtv1.VirtualMachineInstanceList
+	if err := r.List(ctx, &vmis, client.InNamespace(labTemplate.Namespace),
+		client.MatchingLabels{instanceCreation.PoolLabel: labTemplate.Name}); err != nil {
+		log.Error(err, "unable to list the VMIs of the warm pool")
+	}
+
+	for i := range vmis.Items {
+		vmi := &vmis.Items[i]
+		if _, assigned := vmi.Labels[instanceCreation.PoolInstanceNameLabel]; assigned || instanceCreation.PooledVmiIP(*vmi) == "" {
+			continue
+		}
+		// only the VMIs created from the revision of the LabInstance can be handed over
+		if vmi.Labels[instanceCreation.PoolRevisionLabel] != strconv.FormatInt(labInstance.Status.LabTemplateRevision, 10) {
+			continue
+		}
+		vmi.Labels[instanceCreation.PoolInstanceNameLabel] = labInstance.Name
+		vmi.Labels[instanceCreation.PoolInstanceNamespaceLabel] = labInstance.Namespace
+		vmi.Labels["instance-resources"] = name
+		// the update fails in case the VMI has been concurrently handed over to another LabInstance
+		if err := r.Update(ctx, vmi); err != nil {
+			log.Info("Unable to claim pooled vmi " + vmi.Name + ": " + err.Error())
+			continue
+		}
+
+		poolHits.WithLabelValues(labTemplate.Namespace, labTemplate.Name).Inc()
+		r.EventsRecorder.Event(labInstance, "Normal", "PooledVmiClaimed", "VirtualMachineInstance "+vmi.Name+" taken from the warm pool")
+		return vmi
+	}
+
+	poolMisses.WithLabelValues(labTemplate.Namespace, labTemplate.Name).Inc()
+	log.Info("The warm pool of LabTemplate " + labTemplate.Name + " is empty")
+	return nil
+}
+
+// provisionPooledVmi configures a pooled VMI handed over to the LabInstance, since it booted without the Nextcloud
+// drive and with its own key: the key is replaced by the collector one of the LabInstance (if any), so that the
+// access granted to the pool is revoked. The provisioning is performed through ssh by a job in the namespace of the VMI.
+func (r *LabInstanceReconciler) provisionPooledVmi(ctx context.Context, log logr.Logger, labInstance *crownlabsalpha1.LabInstance,
+	vmi *virtv1.VirtualMachineInstance, name string, drive *instanceCreation.WebdavCredentials, collector *instanceCreation.CollectorAccount) error {
+
+	// the VMs accept the connections of the provisioning jobs only from the labelled namespaces
+	if err := instanceCreation.LabelCollectorNamespace(r.Client, ctx, vmi.Namespace); err != nil {
+		return err
+	}
+	settings := r.settings(labInstance)
+	input, err := instanceCreation.ProvisioningInput(drive, settings.NextcloudBaseUrl, collector)
+	if err != nil {
+		return err
+	}
+	keySecretName := instanceCreation.PooledVmiName(*vmi) + "-collector-key"
+	labels := map[string]string{"instance-name": labInstance.Name, "instance-namespace": labInstance.Namespace}
+	job := instanceCreation.CreateProvisioningJob(name, vmi.Namespace, settings.CollectorImage, instanceCreation.PooledVmiIP(*vmi), keySecretName, labels)
+	if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, job); err != nil {
+		return err
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: job.Namespace, Name: job.Name}, &job); err != nil {
+		return err
+	}
+
+	// the input contains the credentials of the student, hence it is deleted together with the job
+	inputSecret := instanceCreation.CreateProvisioningInputSecret(job.Name, job.Namespace, input)
+	inputSecret.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(&job, batchv1.SchemeGroupVersion.WithKind("Job"))})
+	return instanceCreation.CreateOrUpdate(r.Client, ctx, log, inputSecret)
+}
@@ -0,0 +1,159 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/readiness"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const vmiStopped = "VmiStopped"
+
+// reconcilePower stops or starts again the VMs of the LabInstance, according to the running annotation. It
+// returns whether the VMs have been stopped or started (or queued), and the result to be returned by the reconciler.
+func (r *LabInstanceReconciler) reconcilePower(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) (bool, ctrl.Result, error) {
+
+	stopped := instanceCreation.IsStopped(*labInstance)
+	// the VMs being started again remain down while the LabInstance is queued, waiting for the admission
+	down := labInstance.Status.Phase == vmiStopped || labInstance.Status.Phase == queued
+	switch {
+	case stopped && labInstance.Status.Phase == queued:
+		labInstance.Status.QueuePosition = 0
+		setLabInstanceStatus(r, ctx, log, "The VMs of LabInstance "+labInstance.Name+" have been stopped",
+			"Normal", vmiStopped, labInstance, "", labInstance.Status.Url)
+		return true, ctrl.Result{}, nil
+	case stopped == down:
+		return false, ctrl.Result{}, nil
+	case stopped:
+		changed, err := r.stopVmis(ctx, log, labInstance)
+		return changed, ctrl.Result{}, err
+	default:
+		return r.startVmis(ctx, log, labInstance, labTemplate)
+	}
+}
+
+// stopVmis deletes the VMIs of the LabInstance, preserving the other resources so that they can be started again.
+// The VMIs taken from the warm pool cannot be stopped, since they belong to the LabTemplate.
+func (r *LabInstanceReconciler) stopVmis(ctx context.Context, log logr.Logger, labInstance *crownlabsalpha1.LabInstance) (bool, error) {
+	name, err := r.getInstanceResourceName(ctx, labInstance)
+	if err != nil {
+		// the resources of the instance have not been created (e.g. it is waiting for the admission)
+		log.Info("LabInstance " + labInstance.Name + " cannot be stopped: " + err.Error())
+		return false, nil
+	}
+
+	var vmis virtv1.VirtualMachineInstanceList
+	if err := r.List(ctx, &vmis, client.InNamespace(labInstance.Namespace),
+		client.MatchingLabels{"instance-name": labInstance.Name}); err != nil {
+		return false, err
+	}
+	if len(vmis.Items) == 0 {
+		r.EventsRecorder.Event(labInstance, "Warning", "StopNotSupported",
+			"LabInstance "+labInstance.Name+" cannot be stopped, since its VM has been taken from the warm pool")
+		return false, nil
+	}
+
+	// the name of the resources is recorded, since it cannot be retrieved from the VMIs once they are deleted
+	if labInstance.Status.ResourceName != name {
+		labInstance.Status.ResourceName = name
+		if err := r.Status().Update(ctx, labInstance); err != nil {
+			return false, err
+		}
+	}
+	for i := range vmis.Items {
+		if err := r.Delete(ctx, &vmis.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	r.stopUsageRecords(ctx, log, labInstance.Namespace, labInstance.Name, time.Now())
+
+	for i := range labInstance.Status.Vms {
+		labInstance.Status.Vms[i].Phase = vmiStopped
+		labInstance.Status.Vms[i].IP = ""
+		labInstance.Status.Vms[i].Readiness = nil
+	}
+	labInstance.Status.Readiness = nil
+	labInstance.Status.Failure = nil
+	setLabInstanceStatus(r, ctx, log, "The VMs of LabInstance "+labInstance.Name+" have been stopped",
+		"Normal", vmiStopped, labInstance, "", labInstance.Status.Url)
+	return true, nil
+}
+
+// startVmis creates again the VMIs of a stopped LabInstance, once admitted as the new LabInstances, and monitors
+// them until they are ready.
+func (r *LabInstanceReconciler) startVmis(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance, labTemplate *crownlabsalpha1.LabTemplate) (bool, ctrl.Result, error) {
+
+	if admitted, result := r.admit(ctx, log, labInstance, labTemplate); !admitted {
+		return true, result, nil
+	}
+
+	start := time.Now()
+	name, err := r.getInstanceResourceName(ctx, labInstance)
+	if err != nil {
+		return false, ctrl.Result{}, err
+	}
+
+	ownerRef := []metav1.OwnerReference{*metav1.NewControllerRef(labInstance, crownlabsalpha1.GroupVersion.WithKind("LabInstance"))}
+	priority, err := r.instancePriority(ctx, labInstance, labTemplate)
+	if err != nil {
+		return false, ctrl.Result{}, err
+	}
+	priorityClass := r.PriorityClasses[priority]
+	vms := instanceCreation.TemplateVms(*labTemplate)
+	vmis := make([]virtv1.VirtualMachineInstance, len(vms))
+	for i, vm := range vms {
+		vmis[i] = instanceCreation.CreateVirtualMachineInstance(name, labInstance.Namespace, *labTemplate, vm, labInstance.Name, name+"-secret")
+		vmis[i].SetOwnerReferences(ownerRef)
+		vmis[i].Spec.PriorityClassName = priorityClass
+		if err := instanceCreation.CreateOrUpdate(r.Client, ctx, log, vmis[i]); err != nil {
+			setLabInstanceStatus(r, ctx, log, "Could not create vmi "+vmis[i].Name+" in namespace "+vmis[i].Namespace,
+				"Warning", "VmiNotCreated", labInstance, "", labInstance.Status.Url)
+			return false, ctrl.Result{}, err
+		}
+	}
+	r.startUsageRecord(ctx, log, labInstance, labTemplate, name, start)
+
+	urls := make([]string, len(vms))
+	for i := range vms {
+		urls[i] = labInstance.Status.Url
+		if i < len(labInstance.Status.Vms) {
+			urls[i] = labInstance.Status.Vms[i].Url
+			labInstance.Status.Vms[i].Phase = "VmiCreated"
+		}
+	}
+	setLabInstanceStatus(r, ctx, log, "The VMs of LabInstance "+labInstance.Name+" have been started",
+		"Normal", "VmiCreated", labInstance, "", labInstance.Status.Url)
+
+	if len(labTemplate.Spec.ReadinessChecks) == 0 {
+		labTemplate.Spec.ReadinessChecks = r.settings(labInstance).Readiness.DefaultChecks
+	}
+	for i, vm := range vms {
+		go getVmiStatus(r, ctx, log, vm.Name, readiness.Checks(*labTemplate, vm), urls[i], labInstance, vmis[i], start)
+	}
+	return true, ctrl.Result{}, nil
+}
@@ -0,0 +1,61 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// preemptedCondition reports that the VM of a LabInstance has been evicted in favour of a higher priority one
+const preemptedCondition = "Preempted"
+
+// preemptionMessage returns the message of the event reporting the preemption of the pod of the given vmi,
+// if any. Events are retrieved directly from the API server, to avoid caching all the events of the cluster.
+func (r *LabInstanceReconciler) preemptionMessage(ctx context.Context, vmi *virtv1.VirtualMachineInstance) (string, bool) {
+	if r.APIReader == nil {
+		return "", false
+	}
+
+	var events v1.EventList
+	if err := r.APIReader.List(ctx, &events, client.InNamespace(vmi.Namespace),
+		client.MatchingFields{"reason": "Preempted", "involvedObject.kind": "Pod"}); err != nil {
+		return "", false
+	}
+	for _, event := range events.Items {
+		if strings.HasPrefix(event.InvolvedObject.Name, "virt-launcher-"+vmi.Name+"-") {
+			return event.Message, true
+		}
+	}
+	return "", false
+}
+
+// setPreemptedCondition records in the status of the LabInstance that it has been preempted.
+func setPreemptedCondition(labInstance *crownlabsalpha1.LabInstance, vmiName string, message string) {
+	meta.SetStatusCondition(&labInstance.Status.Conditions, metav1.Condition{
+		Type:    preemptedCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  preemptedCondition,
+		Message: "VirtualMachineInstance " + vmiName + ": " + message,
+	})
+}
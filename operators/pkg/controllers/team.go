@@ -0,0 +1,112 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/enrolment"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	quotaExceeded = "QuotaExceeded"
+	notEnrolled   = "NotEnrolled"
+	invalidTeam   = "InvalidTeam"
+)
+
+// checkInstanceQuota verifies that none of the students the LabInstance is accounted to exceeds the maximum
+// number of LabInstances, including the ones shared with their teams: the one of their Tenant, if specified,
+// or the one of the settings otherwise. It returns the description of the violation, if any, and an error if the
+// verification failed.
+func (r *LabInstanceReconciler) checkInstanceQuota(ctx context.Context, labInstance *crownlabsalpha1.LabInstance) (string, error) {
+	defaultMax := *r.settings(labInstance).MaxInstancesPerStudent
+	for _, tenant := range instanceCreation.InstanceTenants(*labInstance) {
+		maxInstances, err := enrolment.MaxInstances(ctx, r.Client, tenant, defaultMax)
+		if err != nil {
+			return "", err
+		}
+		if maxInstances <= 0 {
+			continue
+		}
+
+		var instances crownlabsalpha1.LabInstanceList
+		if err := r.List(ctx, &instances, client.HasLabels{instanceCreation.MemberLabelPrefix + tenant}); err != nil {
+			return "", err
+		}
+		// the labels are only an index, since they can be set by the owners of the LabInstances: the instances
+		// are counted only if accounted to the tenant according to the team resolved by the operator
+		count := 0
+		for _, instance := range instances.Items {
+			if instance.UID != labInstance.UID && accountedTo(instance, tenant) {
+				count++
+			}
+		}
+		if count >= maxInstances {
+			return fmt.Sprintf("%v already owns %v LabInstances, the maximum allowed is %v", tenant, count, maxInstances), nil
+		}
+	}
+	return "", nil
+}
+
+// checkEnrolment verifies that all the students the LabInstance is accounted to are enrolled in the course of the
+// LabTemplate, if the course is managed through a Course resource. It returns the description of the violation, if
+// any, and an error if the verification failed.
+func (r *LabInstanceReconciler) checkEnrolment(ctx context.Context, labInstance *crownlabsalpha1.LabInstance) (string, error) {
+	for _, tenant := range instanceCreation.InstanceTenants(*labInstance) {
+		authorized, err := enrolment.Authorized(ctx, r.Client, tenant, labInstance.Spec.LabTemplateNamespace)
+		if err != nil {
+			return "", err
+		}
+		if !authorized {
+			return fmt.Sprintf("%v is not enrolled in the course of LabTemplate %v", tenant, labInstance.Spec.LabTemplateName), nil
+		}
+	}
+	return "", nil
+}
+
+// accountedTo returns whether the LabInstance is accounted to the given tenant
+func accountedTo(labInstance crownlabsalpha1.LabInstance, tenant string) bool {
+	for _, t := range instanceCreation.InstanceTenants(labInstance) {
+		if t == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// getDrive returns the credentials of the Nextcloud drive to be mounted in the VMs of the LabInstance, i.e. the one
+// of the owner of the namespace, or nil for the instances of the teams: their VMs are shared by all the members, who
+// could read the credentials of the drives of the others from the cloud-init configuration.
+func (r *LabInstanceReconciler) getDrive(ctx context.Context, log logr.Logger,
+	labInstance *crownlabsalpha1.LabInstance) *instanceCreation.WebdavCredentials {
+
+	if team := labInstance.Status.Team; team != nil {
+		return nil
+	}
+
+	var drive instanceCreation.WebdavCredentials
+	err := instanceCreation.GetWebdavCredentials(r.Client, ctx, log, r.settings(labInstance).WebdavSecretName, labInstance.Namespace, &drive.Username, &drive.Password)
+	if err != nil {
+		log.Error(err, "unable to get Webdav Credentials in namespace "+labInstance.Namespace)
+		return nil
+	}
+	return &drive
+}
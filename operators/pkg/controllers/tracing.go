@@ -0,0 +1,43 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// instanceAttributes returns the attributes identifying a LabInstance in the spans
+func instanceAttributes(labInstance *crownlabsalpha1.LabInstance) map[string]string {
+	return map[string]string{
+		"crownlabs.labinstance.namespace": labInstance.Namespace,
+		"crownlabs.labinstance.name":      labInstance.Name,
+		"crownlabs.labtemplate.namespace": labInstance.Spec.LabTemplateNamespace,
+		"crownlabs.labtemplate.name":      labInstance.Spec.LabTemplateName,
+	}
+}
+
+// endInstanceTrace exports the root span of the current generation of a LabInstance, once its VMs are ready or failed.
+// The span starts when the LabInstance is created, or when its VMs are started in case of later generations.
+func (r *LabInstanceReconciler) endInstanceTrace(labInstance *crownlabsalpha1.LabInstance, vmStart time.Time, failure string) {
+	start := vmStart
+	if labInstance.Generation <= 1 && !labInstance.CreationTimestamp.IsZero() {
+		start = labInstance.CreationTimestamp.Time
+	}
+	r.Tracer.EndInstance(labInstance.UID, labInstance.Generation, "labinstance "+labInstance.Namespace+"/"+labInstance.Name,
+		start, failure, instanceAttributes(labInstance))
+}
@@ -0,0 +1,319 @@
+// Package courses generates the resources of the courses declared through Course resources: the namespace of
+// the course with its RoleBindings and LabTemplates, and the namespaces of the enrolled tenants with their
+// RoleBinding, ResourceQuota and NetworkPolicies. It replaces the setup-courses.py provisioning script.
+package courses
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+const (
+	// CourseLabel identifies the resources generated for a course
+	CourseLabel = "crownlabs.polito.it/course"
+
+	// RoleTeacher and RoleStudent are the roles of the members of a course, stored in the enrolment label of their namespaces
+	RoleTeacher = "admin"
+	RoleStudent = "student"
+
+	// The ClusterRoles bound to the groups of the course and to the tenants
+	templateConsumerRole      = "labtemplate-consumer"
+	templateAdminRole         = "labtemplate-consumer-course-admin"
+	instanceConsumerRole      = "labinstance-consumer"
+	instanceAdminRole         = "labinstance-consumer-course-admin"
+	registryCredentialsSecret = "registry-credentials"
+)
+
+// TenantQuota is the ResourceQuota of the namespace of each tenant
+var TenantQuota = corev1.ResourceList{
+	corev1.ResourceLimitsCPU:                 resource.MustParse("20"),
+	corev1.ResourceLimitsMemory:              resource.MustParse("30Gi"),
+	corev1.ResourceRequestsCPU:               resource.MustParse("20"),
+	corev1.ResourceRequestsMemory:            resource.MustParse("30Gi"),
+	"count/labinstances.crownlabs.polito.it": resource.MustParse("5"),
+}
+
+// NamespaceName returns the namespace of the course, containing its LabTemplates
+func NamespaceName(course *crownlabsv1alpha1.Course) string {
+	return "course-" + course.Name
+}
+
+// GroupName returns the OIDC group of the members of the course
+func GroupName(course *crownlabsv1alpha1.Course) string {
+	return "course-" + course.Name
+}
+
+// AdminGroupName returns the OIDC group of the teachers of the course
+func AdminGroupName(course *crownlabsv1alpha1.Course) string {
+	return GroupName(course) + "-admin"
+}
+
+// EnrolmentLabel returns the label of the namespaces of the tenants enrolled in the course, whose value is their role
+func EnrolmentLabel(course *crownlabsv1alpha1.Course) string {
+	return "course-" + course.Name
+}
+
+// LabTemplateName returns the name of the LabTemplate generated for a laboratory of the course
+func LabTemplateName(course *crownlabsv1alpha1.Course, lab crownlabsv1alpha1.CourseLaboratory) string {
+	return course.Name + "-lab" + strconv.Itoa(int(lab.Number))
+}
+
+// Member is a tenant enrolled in a course, with its role
+type Member struct {
+	crownlabsv1alpha1.CourseMember
+	Role string
+}
+
+// Members returns the members of the course indexed by namespace, i.e. the ones listed in the Course and the
+// given ones, enrolled through Enrolments. Teachers listed also as students keep the teacher role.
+func Members(course *crownlabsv1alpha1.Course, enrolled ...Member) map[string]Member {
+	members := map[string]Member{}
+	add := func(member Member) {
+		namespace := instanceCreation.TenantName(member.Username)
+		if members[namespace].Role != RoleTeacher {
+			members[namespace] = member
+		}
+	}
+	for _, student := range course.Spec.Students {
+		add(Member{CourseMember: student, Role: RoleStudent})
+	}
+	for _, teacher := range course.Spec.Teachers {
+		add(Member{CourseMember: teacher, Role: RoleTeacher})
+	}
+	for _, member := range enrolled {
+		add(member)
+	}
+	return members
+}
+
+// Object is a Kubernetes resource
+type Object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Resource is a resource generated for a course
+type Resource struct {
+	Object Object
+	// Mutate enforces the desired state on the object, once retrieved, preserving the fields not managed by the course
+	Mutate func()
+	// Shared resources (i.e. the ones of the tenants) are generated by all the courses of the tenant, hence they are not owned by any of them
+	Shared bool
+}
+
+// Kind returns the kind of the resource
+func (r Resource) Kind() string {
+	return reflect.TypeOf(r.Object).Elem().Name()
+}
+
+// String identifies the resource in events and logs
+func (r Resource) String() string {
+	if namespace := r.Object.GetNamespace(); namespace != "" {
+		return r.Kind() + " " + namespace + "/" + r.Object.GetName()
+	}
+	return r.Kind() + " " + r.Object.GetName()
+}
+
+// Resources returns the resources generated for the course and for the given members
+func Resources(course *crownlabsv1alpha1.Course, members map[string]Member) []Resource {
+	namespace := NamespaceName(course)
+	resources := []Resource{
+		namespaceResource(course, namespace, course.Spec.Name, "course"),
+		roleBinding(course, namespace, "get-labs", templateConsumerRole, groupSubject(GroupName(course))),
+		roleBinding(course, namespace, "manage-labs", templateAdminRole, groupSubject(AdminGroupName(course))),
+		clusterRoleBinding(course),
+	}
+	for _, lab := range course.Spec.Laboratories {
+		resources = append(resources, labTemplate(course, lab))
+	}
+
+	for tenantNamespace, member := range members {
+		ns := namespaceResource(nil, tenantNamespace, member.FirstName+" "+member.LastName, "tenant")
+		role := member.Role
+		mutate := ns.Mutate
+		ns.Mutate = func() {
+			mutate()
+			ns.Object.GetLabels()[EnrolmentLabel(course)] = role
+		}
+		tenantRoleBinding := roleBinding(nil, tenantNamespace, "create-labs-"+member.Username, instanceConsumerRole, rbacv1.Subject{
+			Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: member.Username,
+		})
+		resources = append(resources, ns, tenantRoleBinding, resourceQuota(tenantNamespace))
+		resources = append(resources, networkPolicies(tenantNamespace)...)
+	}
+	return resources
+}
+
+// namespaceResource generates a namespace, whose display name is stored in the name label. The course is nil for tenant namespaces.
+func namespaceResource(course *crownlabsv1alpha1.Course, name, displayName, namespaceType string) Resource {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	displayName = labelValue(displayName)
+	return Resource{Object: namespace, Shared: course == nil, Mutate: func() {
+		if namespace.Labels == nil {
+			namespace.Labels = map[string]string{}
+		}
+		if displayName != "" {
+			namespace.Labels["name"] = displayName
+		}
+		namespace.Labels["type"] = namespaceType
+		namespace.Labels["production"] = "true"
+		if course != nil {
+			namespace.Labels[CourseLabel] = course.Name
+			// the collector jobs run in the namespace of the course
+			namespace.Labels[instanceCreation.CollectorNamespaceLabel] = name
+		}
+	}}
+}
+
+func groupSubject(group string) rbacv1.Subject {
+	return rbacv1.Subject{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "kubernetes:" + group}
+}
+
+// roleBinding generates a RoleBinding to a ClusterRole. The course is nil for the RoleBindings of the tenants.
+func roleBinding(course *crownlabsv1alpha1.Course, namespace, name, clusterRole string, subject rbacv1.Subject) Resource {
+	binding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	return Resource{Object: binding, Shared: course == nil, Mutate: func() {
+		if course != nil {
+			setLabel(&binding.ObjectMeta, course)
+		}
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRole}
+		binding.Subjects = []rbacv1.Subject{subject}
+	}}
+}
+
+// clusterRoleBinding grants the teachers of the course the access to the LabInstances of the students
+func clusterRoleBinding(course *crownlabsv1alpha1.Course) Resource {
+	binding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "labinstance-consumer-" + AdminGroupName(course)}}
+	return Resource{Object: binding, Mutate: func() {
+		setLabel(&binding.ObjectMeta, course)
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: instanceAdminRole}
+		binding.Subjects = []rbacv1.Subject{groupSubject(AdminGroupName(course))}
+	}}
+}
+
+func resourceQuota(namespace string) Resource {
+	quota := &corev1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "namespace-quota"}}
+	return Resource{Object: quota, Shared: true, Mutate: func() {
+		quota.Spec.Hard = TenantQuota.DeepCopy()
+	}}
+}
+
+// networkPolicies drop the ingress traffic towards the tenant namespace, except the one originated from the trusted
+// namespaces (labelled with access-vm=allowed). The operator generates a further NetworkPolicy for each LabInstance,
+// allowing only the required traffic (including the one of the collector jobs of the course).
+func networkPolicies(namespace string) []Resource {
+	deny := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "deny-ingress-traffic"}}
+	allow := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "allow-trusted-ingress-traffic"}}
+	return []Resource{
+		{Object: deny, Shared: true, Mutate: func() {
+			deny.Spec = networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}}
+		}},
+		{Object: allow, Shared: true, Mutate: func() {
+			allow.Spec = networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{
+					{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"access-vm": "allowed"}}},
+				}}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			}
+		}},
+	}
+}
+
+// labTemplate generates the LabTemplate of a laboratory. Only the fields derived from the laboratory are
+// enforced, hence teachers can still configure the other ones (e.g. the exam profile).
+func labTemplate(course *crownlabsv1alpha1.Course, lab crownlabsv1alpha1.CourseLaboratory) Resource {
+	name := LabTemplateName(course, lab)
+	namespace := NamespaceName(course)
+	template := &crownlabsv1alpha1.LabTemplate{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	return Resource{Object: template, Mutate: func() {
+		setLabel(&template.ObjectMeta, course)
+		vmType := lab.VmType
+		if vmType == "" {
+			vmType = crownlabsv1alpha1.TypeGUI
+		}
+		template.Spec.CourseName = course.Name
+		template.Spec.LabName = name
+		template.Spec.LabNum = *resource.NewQuantity(int64(lab.Number), resource.DecimalSI)
+		template.Spec.Description = lab.Description
+		template.Spec.VmType = vmType
+		template.Spec.Vm = labVmi(name, namespace, lab)
+	}}
+}
+
+// labVmi generates the VM of a laboratory: its limits exceed the resources of the VM by half a core and
+// 500MB, to account for the overhead of the virtualization, while only half of the cores are requested.
+func labVmi(name, namespace string, lab crownlabsv1alpha1.CourseLaboratory) virtv1.VirtualMachineInstance {
+	memory := lab.Memory.DeepCopy()
+	memoryLimit := lab.Memory.DeepCopy()
+	memoryLimit.Add(resource.MustParse("500M"))
+	cpuMillis := int64(lab.Cpu) * 1000
+	gracePeriod := int64(30)
+
+	return virtv1.VirtualMachineInstance{
+		TypeMeta: metav1.TypeMeta{APIVersion: "kubevirt.io/v1alpha3", Kind: "VirtualMachineInstance"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"name": name},
+		},
+		Spec: virtv1.VirtualMachineInstanceSpec{
+			Domain: virtv1.DomainSpec{
+				CPU: &virtv1.CPU{Cores: lab.Cpu},
+				Devices: virtv1.Devices{Disks: []virtv1.Disk{
+					{Name: "containerdisk", DiskDevice: virtv1.DiskDevice{Disk: &virtv1.DiskTarget{Bus: "virtio"}}},
+					{Name: "cloudinitdisk", DiskDevice: virtv1.DiskDevice{Disk: &virtv1.DiskTarget{Bus: "virtio"}}},
+				}},
+				Memory: &virtv1.Memory{Guest: &memory},
+				Resources: virtv1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						corev1.ResourceCPU:    *resource.NewMilliQuantity(cpuMillis+500, resource.DecimalSI),
+						corev1.ResourceMemory: memoryLimit,
+					},
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    *resource.NewMilliQuantity(cpuMillis/2, resource.DecimalSI),
+						corev1.ResourceMemory: lab.Memory.DeepCopy(),
+					},
+				},
+			},
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Volumes: []virtv1.Volume{
+				{Name: "containerdisk", VolumeSource: virtv1.VolumeSource{
+					ContainerDisk: &virtv1.ContainerDiskSource{Image: lab.Image, ImagePullSecret: registryCredentialsSecret},
+				}},
+				// the secret containing the cloud-init configuration is set by the operator when creating the instances
+				{Name: "cloudinitdisk", VolumeSource: virtv1.VolumeSource{CloudInitNoCloud: &virtv1.CloudInitNoCloudSource{}}},
+			},
+		},
+	}
+}
+
+func setLabel(meta *metav1.ObjectMeta, course *crownlabsv1alpha1.Course) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels[CourseLabel] = course.Name
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// labelValue turns a display name into a valid label value (e.g. "Software Networking" into "Software_Networking")
+func labelValue(name string) string {
+	value := invalidLabelChars.ReplaceAllString(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"), "")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "_.-")
+}
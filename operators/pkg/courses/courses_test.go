@@ -0,0 +1,295 @@
+package courses
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/keycloak"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testCourse() *crownlabsv1alpha1.Course {
+	return &crownlabsv1alpha1.Course{
+		ObjectMeta: metav1.ObjectMeta{Name: "swnet", UID: "uid"},
+		Spec: crownlabsv1alpha1.CourseSpec{
+			Name:     "Software Networking",
+			Teachers: []crownlabsv1alpha1.CourseMember{{Username: "william.brown", FirstName: "William", LastName: "Brown"}},
+			Students: []crownlabsv1alpha1.CourseMember{
+				{Username: "john.doe", FirstName: "John", LastName: "Doe"},
+				{Username: "jane.smith", FirstName: "Jane", LastName: "O'Smith"},
+			},
+			Laboratories: []crownlabsv1alpha1.CourseLaboratory{{
+				Number: 1, Image: "registry.internal.crownlabs.polito.it/repo/image1:v1.1",
+				Cpu: 2, Memory: resource.MustParse("4500M"), Description: "The first laboratory",
+			}},
+		},
+	}
+}
+
+func TestResources(t *testing.T) {
+	course := testCourse()
+	byName := map[string]Resource{}
+	for _, res := range Resources(course, Members(course)) {
+		res.Mutate()
+		byName[res.String()] = res
+	}
+
+	namespace := byName["Namespace course-swnet"].Object.(*corev1.Namespace)
+	assert.Equal(t, namespace.Labels["name"], "Software_Networking")
+	assert.Equal(t, namespace.Labels["type"], "course")
+	assert.False(t, byName["Namespace course-swnet"].Shared)
+
+	tenant := byName["Namespace tenant-jane-smith"]
+	assert.True(t, tenant.Shared, "The namespaces of the tenants should not be owned by the course.")
+	assert.Equal(t, tenant.Object.GetLabels()["name"], "Jane_OSmith")
+	assert.Equal(t, tenant.Object.GetLabels()["course-swnet"], RoleStudent)
+	assert.Equal(t, byName["Namespace tenant-william-brown"].Object.GetLabels()["course-swnet"], RoleTeacher)
+
+	binding := byName["RoleBinding tenant-john-doe/create-labs-john.doe"].Object.(*rbacv1.RoleBinding)
+	assert.Equal(t, binding.Subjects[0].Name, "john.doe")
+	assert.Equal(t, binding.RoleRef.Name, instanceConsumerRole)
+	admins := byName["ClusterRoleBinding labinstance-consumer-course-swnet-admin"].Object.(*rbacv1.ClusterRoleBinding)
+	assert.Equal(t, admins.Subjects[0].Name, "kubernetes:course-swnet-admin")
+
+	template := byName["LabTemplate course-swnet/swnet-lab1"].Object.(*crownlabsv1alpha1.LabTemplate)
+	assert.Equal(t, template.Spec.VmType, crownlabsv1alpha1.TypeGUI)
+	assert.Equal(t, template.Spec.LabNum.String(), "1")
+	resources := template.Spec.Vm.Spec.Domain.Resources
+	assert.Equal(t, resources.Limits.Cpu().String(), "2500m")
+	assert.Equal(t, resources.Requests.Cpu().String(), "1")
+	assert.Equal(t, resources.Limits.Memory().String(), "5G")
+	assert.Equal(t, template.Spec.Vm.Spec.Volumes[0].ContainerDisk.Image, "registry.internal.crownlabs.polito.it/repo/image1:v1.1")
+}
+
+func TestSync(t *testing.T) {
+	s := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(s))
+	assert.NoError(t, crownlabsv1alpha1.AddToScheme(s))
+	course := testCourse()
+	// a namespace of a student enrolled in the course, who has been removed from the course in the meantime
+	removed := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-old-student", Labels: map[string]string{"course-swnet": RoleStudent}}}
+	// a teacher enrolled through an Enrolment
+	teacher := &crownlabsv1alpha1.Enrolment{ObjectMeta: metav1.ObjectMeta{Name: "swnet-mary-jones"},
+		Spec: crownlabsv1alpha1.EnrolmentSpec{Tenant: "mary-jones", Course: "swnet", Role: crownlabsv1alpha1.RoleTeacher}}
+	tenant := &crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "mary-jones"},
+		Spec: crownlabsv1alpha1.TenantSpec{StudentID: "mary.jones", FirstName: "Mary", LastName: "Jones"}}
+	c := fake.NewFakeClientWithScheme(s, course, removed, teacher, tenant)
+	ctx := context.Background()
+
+	result, err := Sync(ctx, c, s, course)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Failed)
+	assert.Contains(t, result.Updated, "LabTemplate course-swnet/swnet-lab1")
+	assert.Contains(t, result.Updated, "RoleBinding tenant-mary-jones/create-labs-mary.jones")
+	assert.Len(t, result.Members, 4)
+
+	namespace := func(name string) corev1.Namespace {
+		var ns corev1.Namespace
+		assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: name}, &ns))
+		return ns
+	}
+	assert.Equal(t, namespace("course-swnet").OwnerReferences[0].Name, "swnet", "The namespace of the course should be owned by it.")
+	assert.Empty(t, namespace("tenant-john-doe").OwnerReferences)
+	assert.NotContains(t, namespace("tenant-old-student").Labels, "course-swnet", "The removed student should be unenrolled.")
+	assert.Equal(t, namespace("tenant-mary-jones").Labels["course-swnet"], RoleTeacher)
+
+	result, err = Sync(ctx, c, s, course)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Updated, "Nothing should be updated when the resources are in sync.")
+
+	// the drift of the resources is corrected
+	var quota corev1.ResourceQuota
+	assert.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "tenant-john-doe", Name: "namespace-quota"}, &quota))
+	quota.Spec.Hard[corev1.ResourceLimitsCPU] = resource.MustParse("100")
+	assert.NoError(t, c.Update(ctx, &quota))
+	result, err = Sync(ctx, c, s, course)
+	assert.NoError(t, err)
+	assert.Equal(t, result.Updated, []string{"ResourceQuota tenant-john-doe/namespace-quota"})
+
+	// the LabTemplates of the removed laboratories are deleted, while the other ones of the namespace are kept
+	manual := &crownlabsv1alpha1.LabTemplate{ObjectMeta: metav1.ObjectMeta{Namespace: "course-swnet", Name: "manual"}}
+	assert.NoError(t, c.Create(ctx, manual))
+	course.Spec.Laboratories = nil
+	course.Spec.Students = course.Spec.Students[1:]
+	_, err = Sync(ctx, c, s, course)
+	assert.NoError(t, err)
+	var templates crownlabsv1alpha1.LabTemplateList
+	assert.NoError(t, c.List(ctx, &templates))
+	assert.Len(t, templates.Items, 1)
+	assert.Equal(t, templates.Items[0].Name, "manual")
+	assert.NotContains(t, namespace("tenant-john-doe").Labels, "course-swnet")
+
+	assert.NoError(t, Unenrol(ctx, c, course))
+	var enrolled corev1.NamespaceList
+	assert.NoError(t, c.List(ctx, &enrolled, client.HasLabels{"course-swnet"}))
+	assert.Empty(t, enrolled.Items)
+}
+
+func TestParseCSV(t *testing.T) {
+	parsed, err := ParseCSV(strings.NewReader("Course (code),Name\nSWNET,Software Networking\ncloud,Cloud Computing\n"),
+		strings.NewReader(`Course (code),Lab number,Image,Cpu,Memory,Needs GUI,Description
+swnet,1,registry.internal.crownlabs.polito.it/repo/image1:v1.1,2,4.5,True,The first laboratory
+swnet,2,registry.internal.crownlabs.polito.it/repo/image2:v0.1,5,8,False,The second laboratory
+`))
+	assert.NoError(t, err)
+	assert.Len(t, parsed, 2)
+	swnet := parsed[1]
+	assert.Equal(t, swnet.Name, "swnet")
+	assert.Equal(t, swnet.Spec.Name, "Software Networking")
+	assert.Len(t, swnet.Spec.Laboratories, 2)
+	assert.Equal(t, swnet.Spec.Laboratories[0].Memory.String(), "4500M")
+	assert.Equal(t, swnet.Spec.Laboratories[0].VmType, crownlabsv1alpha1.TypeGUI)
+	assert.Equal(t, swnet.Spec.Laboratories[1].VmType, crownlabsv1alpha1.TypeCLI)
+	assert.Empty(t, parsed[0].Spec.Laboratories)
+
+	_, err = ParseCSV(strings.NewReader("Course (code),Name\nswnet,Software Networking\n"),
+		strings.NewReader("Course (code),Lab number,Image,Cpu,Memory,Needs GUI,Description\nother,1,image,2,4,True,Lab\n"))
+	assert.Error(t, err, "The laboratories of unknown courses should be rejected.")
+}
+
+// mockKeycloak is an in-memory implementation of the subset of the admin API of Keycloak used to provision the groups
+type mockKeycloak struct {
+	users   map[string]keycloak.User
+	groups  map[string]keycloak.Group
+	members map[string]map[string]bool
+	roles   map[string]keycloak.Role
+	mapped  map[string][]keycloak.Role
+}
+
+func (m *mockKeycloak) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(v interface{}) { _ = json.NewEncoder(w).Encode(v) }
+	if r.URL.Path == "/realms/crownlabs/protocol/openid-connect/token" {
+		reply(map[string]interface{}{"access_token": "token", "expires_in": 300})
+		return
+	}
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/realms/crownlabs/"), "/")
+	switch {
+	case path[0] == "clients" && len(path) == 1:
+		reply([]map[string]string{{"id": "uuid", "clientId": "k8s"}})
+	case path[0] == "clients" && len(path) == 3 && r.Method == http.MethodPost:
+		var role keycloak.Role
+		_ = json.NewDecoder(r.Body).Decode(&role)
+		role.ID = "role-" + role.Name
+		m.roles[role.Name] = role
+		w.WriteHeader(http.StatusCreated)
+	case path[0] == "clients" && len(path) == 4:
+		reply(m.roles[path[3]])
+	case path[0] == "groups" && len(path) == 1 && r.Method == http.MethodPost:
+		var group keycloak.Group
+		_ = json.NewDecoder(r.Body).Decode(&group)
+		group.ID = "group-" + group.Name
+		m.groups[group.ID], m.members[group.ID] = group, map[string]bool{}
+		w.WriteHeader(http.StatusCreated)
+	case path[0] == "groups" && len(path) == 1:
+		var groups []keycloak.Group
+		for _, group := range m.groups {
+			if strings.Contains(group.Name, r.URL.Query().Get("search")) {
+				groups = append(groups, group)
+			}
+		}
+		reply(groups)
+	case path[0] == "groups" && len(path) == 3:
+		var users []keycloak.User
+		for id := range m.members[path[1]] {
+			users = append(users, m.users[id])
+		}
+		reply(users)
+	case path[0] == "groups" && len(path) == 5 && r.Method == http.MethodPost:
+		var roles []keycloak.Role
+		_ = json.NewDecoder(r.Body).Decode(&roles)
+		m.mapped[path[1]] = append(m.mapped[path[1]], roles...)
+		w.WriteHeader(http.StatusNoContent)
+	case path[0] == "groups" && len(path) == 5:
+		reply(m.mapped[path[1]])
+	case path[0] == "users" && len(path) == 1:
+		var users []keycloak.User
+		for _, user := range m.users {
+			if user.Username == r.URL.Query().Get("username") {
+				users = append(users, user)
+			}
+		}
+		reply(users)
+	case path[0] == "users" && len(path) == 4:
+		if r.Method == http.MethodDelete {
+			delete(m.members[path[3]], path[1])
+		} else {
+			m.members[path[3]][path[1]] = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *mockKeycloak) groupMembers(name string) []string {
+	var usernames []string
+	for id := range m.members["group-"+name] {
+		usernames = append(usernames, m.users[id].Username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func TestKeycloakGroups(t *testing.T) {
+	mock := &mockKeycloak{
+		users: map[string]keycloak.User{
+			"1": {ID: "1", Username: "john.doe"}, "2": {ID: "2", Username: "jane.smith"},
+			"3": {ID: "3", Username: "william.brown"}, "4": {ID: "4", Username: "old.student"},
+		},
+		groups:  map[string]keycloak.Group{"group-course-swnet": {ID: "group-course-swnet", Name: "course-swnet"}},
+		members: map[string]map[string]bool{"group-course-swnet": {"4": true}},
+		roles:   map[string]keycloak.Role{},
+		mapped:  map[string][]keycloak.Role{},
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	groups := &KeycloakGroups{
+		Client:   &keycloak.Client{BaseURL: server.URL, Realm: "crownlabs", ClientID: "operator", ClientSecret: "secret"},
+		ClientID: "k8s",
+	}
+	course := testCourse()
+	course.Spec.Students = append(course.Spec.Students, crownlabsv1alpha1.CourseMember{Username: "missing.student"})
+	ctx := context.Background()
+
+	result, err := groups.Sync(ctx, course, Members(course))
+	assert.NoError(t, err)
+	assert.Equal(t, result.Missing, []string{"missing.student"})
+	assert.Equal(t, result.Removed, []string{"old.student/course-swnet"})
+	assert.Equal(t, mock.groupMembers("course-swnet"), []string{"jane.smith", "john.doe", "william.brown"})
+	assert.Equal(t, mock.groupMembers("course-swnet-admin"), []string{"william.brown"})
+	assert.Equal(t, mock.groupMembers("tenant-john-doe"), []string{"john.doe"})
+	assert.Equal(t, mock.mapped["group-course-swnet-admin"], []keycloak.Role{{ID: "role-course-swnet-admin", Name: "course-swnet-admin", ClientRole: true}},
+		"The client role with the same name should be mapped to each group.")
+
+	result, err = groups.Sync(ctx, course, Members(course))
+	assert.NoError(t, err)
+	assert.Empty(t, result.Added, "Nothing should be changed when the groups are in sync.")
+	assert.Empty(t, result.Removed)
+	assert.Len(t, mock.mapped["group-course-swnet"], 1)
+
+	course.Spec.Teachers = nil
+	result, err = groups.Sync(ctx, course, Members(course))
+	assert.NoError(t, err)
+	assert.Equal(t, result.Removed, []string{"william.brown/course-swnet", "william.brown/course-swnet-admin"})
+
+	result, err = groups.Clear(ctx, course)
+	assert.NoError(t, err)
+	assert.Equal(t, result.Removed, []string{"jane.smith/course-swnet", "john.doe/course-swnet"})
+	assert.Empty(t, mock.groupMembers("course-swnet"), "The groups of the deleted courses should be emptied.")
+	assert.Equal(t, mock.groupMembers("tenant-john-doe"), []string{"john.doe"}, "The groups of the tenants should be preserved.")
+}
@@ -0,0 +1,83 @@
+package courses
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/enrolment"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ParseCSV parses the courses.csv and laboratories.csv files of the provisioning script, returning the Courses
+// they describe. As in the script, the memory of the laboratories is expressed in GB and they need a GUI unless
+// specified otherwise. The members of the Courses are not set, since they are enrolled through Enrolments.
+func ParseCSV(coursesFile, laboratoriesFile io.Reader) ([]crownlabsv1alpha1.Course, error) {
+	rows, err := enrolment.ReadCSV(coursesFile)
+	if err != nil {
+		return nil, fmt.Errorf("courses: %v", err)
+	}
+	byName := map[string]*crownlabsv1alpha1.Course{}
+	var names []string
+	for i, row := range rows {
+		name := strings.ToLower(row[enrolment.ColumnCourse])
+		if name == "" {
+			return nil, fmt.Errorf("courses: line %v: missing %v", i+2, enrolment.ColumnCourse)
+		}
+		byName[name] = &crownlabsv1alpha1.Course{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       crownlabsv1alpha1.CourseSpec{Name: row["Name"]},
+		}
+		names = append(names, name)
+	}
+
+	if laboratoriesFile != nil {
+		if rows, err = enrolment.ReadCSV(laboratoriesFile); err != nil {
+			return nil, fmt.Errorf("laboratories: %v", err)
+		}
+		for i, row := range rows {
+			course, found := byName[strings.ToLower(row[enrolment.ColumnCourse])]
+			if !found {
+				return nil, fmt.Errorf("laboratories: line %v: unknown course %v", i+2, row[enrolment.ColumnCourse])
+			}
+			laboratory, err := parseLaboratory(row)
+			if err != nil {
+				return nil, fmt.Errorf("laboratories: line %v: %v", i+2, err)
+			}
+			course.Spec.Laboratories = append(course.Spec.Laboratories, laboratory)
+		}
+	}
+
+	sort.Strings(names)
+	result := make([]crownlabsv1alpha1.Course, len(names))
+	for i, name := range names {
+		result[i] = *byName[name]
+	}
+	return result, nil
+}
+
+func parseLaboratory(row map[string]string) (crownlabsv1alpha1.CourseLaboratory, error) {
+	laboratory := crownlabsv1alpha1.CourseLaboratory{Image: row["Image"], Description: row["Description"], VmType: crownlabsv1alpha1.TypeGUI}
+	number, err := strconv.ParseInt(row["Lab number"], 10, 32)
+	if err != nil {
+		return laboratory, fmt.Errorf("invalid lab number %v", row["Lab number"])
+	}
+	laboratory.Number = int32(number)
+	cpu, err := strconv.ParseUint(row["Cpu"], 10, 32)
+	if err != nil {
+		return laboratory, fmt.Errorf("invalid cpu %v", row["Cpu"])
+	}
+	laboratory.Cpu = uint32(cpu)
+	if laboratory.Memory, err = resource.ParseQuantity(row["Memory"] + "G"); err != nil {
+		return laboratory, fmt.Errorf("invalid memory %v", row["Memory"])
+	}
+	if strings.EqualFold(row["Needs GUI"], "false") {
+		laboratory.VmType = crownlabsv1alpha1.TypeCLI
+	}
+	return laboratory, nil
+}
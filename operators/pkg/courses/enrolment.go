@@ -0,0 +1,76 @@
+package courses
+
+import (
+	"context"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/enrolment"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Enrolled returns the members of the course enrolled through Enrolments, whose details are taken from their Tenants
+func Enrolled(ctx context.Context, c client.Client, course *crownlabsv1alpha1.Course) ([]Member, error) {
+	enrolments, err := enrolment.CourseEnrolments(ctx, c, course.Name)
+	if err != nil {
+		return nil, err
+	}
+	var tenants crownlabsv1alpha1.TenantList
+	if err := c.List(ctx, &tenants); err != nil {
+		return nil, err
+	}
+	byName := map[string]*crownlabsv1alpha1.Tenant{}
+	for i := range tenants.Items {
+		byName[tenants.Items[i].Name] = &tenants.Items[i]
+	}
+
+	var members []Member
+	for _, e := range enrolments {
+		member := Member{CourseMember: crownlabsv1alpha1.CourseMember{Username: e.Spec.Tenant}, Role: RoleStudent}
+		if tenant, found := byName[e.Spec.Tenant]; found {
+			member.CourseMember = crownlabsv1alpha1.CourseMember{
+				Username:  tenant.Spec.StudentID,
+				Email:     tenant.Spec.Email,
+				FirstName: tenant.Spec.FirstName,
+				LastName:  tenant.Spec.LastName,
+			}
+		}
+		if e.Spec.Role == crownlabsv1alpha1.RoleTeacher {
+			member.Role = RoleTeacher
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// TenantCourses returns the namespaces of the Courses the owner of the given namespace is enrolled in, either
+// through Enrolments or by being listed in the Courses, and the ones among them the owner teaches
+func TenantCourses(ctx context.Context, c client.Client, namespace string) (enrolled []string, taught []string, err error) {
+	tenant := enrolment.TenantNameFromNamespace(namespace)
+	enrolments, err := enrolment.TenantEnrolments(ctx, c, tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range enrolments {
+		courseNamespace := NamespaceName(&crownlabsv1alpha1.Course{ObjectMeta: metav1.ObjectMeta{Name: e.Spec.Course}})
+		enrolled = append(enrolled, courseNamespace)
+		if e.Spec.Role == crownlabsv1alpha1.RoleTeacher {
+			taught = append(taught, courseNamespace)
+		}
+	}
+
+	var courses crownlabsv1alpha1.CourseList
+	if err := c.List(ctx, &courses); err != nil {
+		return nil, nil, err
+	}
+	for i := range courses.Items {
+		if member, found := Members(&courses.Items[i])[namespace]; found {
+			enrolled = append(enrolled, NamespaceName(&courses.Items[i]))
+			if member.Role == RoleTeacher {
+				taught = append(taught, NamespaceName(&courses.Items[i]))
+			}
+		}
+	}
+	return enrolled, taught, nil
+}
@@ -0,0 +1,182 @@
+package courses
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/keycloak"
+)
+
+// KeycloakGroups provisions the Keycloak groups of the courses, as the provisioning script: the course-<name> group
+// of all the members, the course-<name>-admin group of the teachers and the tenant-<studentId> group of each member.
+// A role with the same name of each group is created in the OIDC client and mapped to the group, so that the tokens
+// carry the groups claims the RoleBindings and the oauth2-proxies of the instances rely on.
+type KeycloakGroups struct {
+	Client *keycloak.Client
+	// ClientID is the OIDC client the tokens are issued for (e.g. k8s)
+	ClientID string
+}
+
+// GroupsResult is the outcome of the synchronization of the Keycloak groups of a course
+type GroupsResult struct {
+	// Added are the memberships added, as username/group
+	Added []string
+	// Removed are the memberships removed, as username/group
+	Removed []string
+	// Missing are the members of the course which do not exist in the realm
+	Missing []string
+}
+
+// Sync creates the groups of the course and of its members, and keeps the memberships of the groups of the course in
+// sync with the given members: the users no longer members of the course are removed from its groups, while the
+// groups of the tenants are shared among the courses, hence their members are only added.
+func (k *KeycloakGroups) Sync(ctx context.Context, course *crownlabsv1alpha1.Course, members map[string]Member) (GroupsResult, error) {
+	var result GroupsResult
+	clientUUID, err := k.Client.ClientUUID(ctx, k.ClientID)
+	if err != nil {
+		return result, err
+	}
+
+	// the users are identified by their lower-cased username, as in Keycloak
+	users := map[string]*keycloak.User{}
+	desired := map[string]map[string]bool{GroupName(course): {}, AdminGroupName(course): {}}
+	usernames := make([]string, 0, len(members))
+	for _, member := range members {
+		usernames = append(usernames, strings.ToLower(member.Username))
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		user, err := k.Client.User(ctx, username)
+		if err != nil {
+			return result, err
+		}
+		if user == nil {
+			result.Missing = append(result.Missing, username)
+			continue
+		}
+		users[username] = user
+		desired[GroupName(course)][username] = true
+		if members[instanceCreation.TenantName(username)].Role == RoleTeacher {
+			desired[AdminGroupName(course)][username] = true
+		}
+	}
+
+	for _, name := range []string{GroupName(course), AdminGroupName(course)} {
+		group, err := k.group(ctx, name, clientUUID)
+		if err != nil {
+			return result, err
+		}
+		current, err := k.Client.GroupMembers(ctx, group.ID)
+		if err != nil {
+			return result, err
+		}
+		existing := map[string]bool{}
+		for _, user := range current {
+			username := strings.ToLower(user.Username)
+			existing[username] = true
+			if desired[name][username] {
+				continue
+			}
+			if err := k.Client.RemoveGroupMember(ctx, group.ID, user.ID); err != nil {
+				return result, err
+			}
+			result.Removed = append(result.Removed, username+"/"+name)
+		}
+		if err := k.addMembers(ctx, group, users, desired[name], existing, &result); err != nil {
+			return result, err
+		}
+	}
+
+	for username, user := range users {
+		name := instanceCreation.TenantName(username)
+		group, err := k.group(ctx, name, clientUUID)
+		if err != nil {
+			return result, err
+		}
+		current, err := k.Client.GroupMembers(ctx, group.ID)
+		if err != nil {
+			return result, err
+		}
+		existing := map[string]bool{}
+		for _, member := range current {
+			existing[strings.ToLower(member.Username)] = true
+		}
+		if err := k.addMembers(ctx, group, map[string]*keycloak.User{username: user}, map[string]bool{username: true}, existing, &result); err != nil {
+			return result, err
+		}
+	}
+	sort.Strings(result.Added)
+	return result, nil
+}
+
+// Clear removes all the members from the groups of the course, once it is deleted, so that the former members are no
+// longer granted the access to its namespace and instances if a course with the same name is created again. The groups
+// of the tenants are shared among the courses, hence they are preserved, as well as the empty groups of the course.
+func (k *KeycloakGroups) Clear(ctx context.Context, course *crownlabsv1alpha1.Course) (GroupsResult, error) {
+	var result GroupsResult
+	for _, name := range []string{GroupName(course), AdminGroupName(course)} {
+		group, err := k.Client.Group(ctx, name)
+		if err != nil || group == nil {
+			return result, err
+		}
+		current, err := k.Client.GroupMembers(ctx, group.ID)
+		if err != nil {
+			return result, err
+		}
+		for _, user := range current {
+			if err := k.Client.RemoveGroupMember(ctx, group.ID, user.ID); err != nil {
+				return result, err
+			}
+			result.Removed = append(result.Removed, strings.ToLower(user.Username)+"/"+name)
+		}
+	}
+	sort.Strings(result.Removed)
+	return result, nil
+}
+
+// addMembers adds the desired users which are not yet members of the group
+func (k *KeycloakGroups) addMembers(ctx context.Context, group *keycloak.Group, users map[string]*keycloak.User,
+	desired, existing map[string]bool, result *GroupsResult) error {
+	for username := range desired {
+		if existing[username] {
+			continue
+		}
+		if err := k.Client.AddGroupMember(ctx, group.ID, users[username].ID); err != nil {
+			return err
+		}
+		result.Added = append(result.Added, username+"/"+group.Name)
+	}
+	return nil
+}
+
+// group returns the group with the given name, creating it (and mapping to it the client role with the same name)
+// if it does not exist yet
+func (k *KeycloakGroups) group(ctx context.Context, name, clientUUID string) (*keycloak.Group, error) {
+	group, err := k.Client.Group(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		if group, err = k.Client.CreateGroup(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	roles, err := k.Client.GroupClientRoles(ctx, group.ID, clientUUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return group, nil
+		}
+	}
+	role, err := k.Client.CreateClientRole(ctx, clientUUID, name)
+	if err != nil {
+		return nil, err
+	}
+	return group, k.Client.AddGroupClientRoles(ctx, group.ID, clientUUID, []keycloak.Role{*role})
+}
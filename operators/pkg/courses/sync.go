@@ -0,0 +1,112 @@
+package courses
+
+import (
+	"context"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Result is the outcome of the synchronization of a course
+type Result struct {
+	// Updated are the resources created or updated, either because the course changed or to correct their drift
+	Updated []string
+	// Failed are the resources which could not be created or updated
+	Failed []string
+	// Errors are the errors of the failed resources
+	Errors []error
+	// Members are the members of the course indexed by namespace, either listed in it or enrolled through Enrolments
+	Members map[string]Member
+}
+
+// Sync creates or updates the resources generated for the course and for its members, including the ones enrolled
+// through Enrolments, and removes the ones no longer generated: the
+// LabTemplates of the deleted laboratories and the enrolment label of the namespaces of the unenrolled tenants. The
+// resources belonging only to the course are owned by it, hence they are deleted together with the course.
+// The failures concerning single resources are reported in the result, while the returned error is fatal.
+func Sync(ctx context.Context, c client.Client, scheme *runtime.Scheme, course *crownlabsv1alpha1.Course) (Result, error) {
+	var result Result
+	enrolled, err := Enrolled(ctx, c, course)
+	if err != nil {
+		return result, err
+	}
+	result.Members = Members(course, enrolled...)
+	resources := Resources(course, result.Members)
+	for _, res := range resources {
+		res := res
+		op, err := controllerutil.CreateOrUpdate(ctx, c, res.Object, func() error {
+			res.Mutate()
+			if res.Shared {
+				return nil
+			}
+			return controllerutil.SetControllerReference(course, res.Object, scheme)
+		})
+		switch {
+		case err != nil:
+			result.Failed = append(result.Failed, res.String())
+			result.Errors = append(result.Errors, err)
+		case op != controllerutil.OperationResultNone:
+			result.Updated = append(result.Updated, res.String())
+		}
+	}
+
+	if err := pruneLabTemplates(ctx, c, course, resources); err != nil {
+		return result, err
+	}
+	return result, unenrol(ctx, c, course, result.Members)
+}
+
+// Unenrol removes the enrolment label of the course from the namespaces of all its tenants, which are not deleted
+// together with the course since they may belong to other courses.
+func Unenrol(ctx context.Context, c client.Client, course *crownlabsv1alpha1.Course) error {
+	return unenrol(ctx, c, course, nil)
+}
+
+// unenrol removes the enrolment label of the course from the namespaces of the tenants not among the given members.
+// The namespaces are kept, with the LabInstances of the tenants.
+func unenrol(ctx context.Context, c client.Client, course *crownlabsv1alpha1.Course, members map[string]Member) error {
+	var namespaces corev1.NamespaceList
+	if err := c.List(ctx, &namespaces, client.HasLabels{EnrolmentLabel(course)}); err != nil {
+		return err
+	}
+	for i := range namespaces.Items {
+		namespace := &namespaces.Items[i]
+		if _, enrolled := members[namespace.Name]; enrolled {
+			continue
+		}
+		delete(namespace.Labels, EnrolmentLabel(course))
+		if err := c.Update(ctx, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneLabTemplates deletes the LabTemplates generated for the laboratories no longer part of the course
+func pruneLabTemplates(ctx context.Context, c client.Client, course *crownlabsv1alpha1.Course, resources []Resource) error {
+	var templates crownlabsv1alpha1.LabTemplateList
+	if err := c.List(ctx, &templates, client.InNamespace(NamespaceName(course)),
+		client.MatchingLabels{CourseLabel: course.Name}); err != nil {
+		return err
+	}
+
+	desired := map[string]bool{}
+	for _, res := range resources {
+		if template, ok := res.Object.(*crownlabsv1alpha1.LabTemplate); ok {
+			desired[template.Name] = true
+		}
+	}
+	for i := range templates.Items {
+		if desired[templates.Items[i].Name] {
+			continue
+		}
+		if err := c.Delete(ctx, &templates.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package crownlabsctl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/apiserver"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+)
+
+// eventsPollPeriod is the period the events of a LabInstance are polled with, when tailing them from Kubernetes
+const eventsPollPeriod = 2 * time.Second
+
+// Backend manages the LabInstances of the user, either through the API of the operator or directly through Kubernetes.
+// An empty namespace identifies the one of the user.
+type Backend interface {
+	// Templates lists the LabTemplates of the given course namespace (of all the courses of the user, if empty)
+	Templates(ctx context.Context, course string) ([]crownlabsv1alpha1.LabTemplate, error)
+	Template(ctx context.Context, namespace, name string) (*crownlabsv1alpha1.LabTemplate, error)
+	Instances(ctx context.Context) ([]crownlabsv1alpha1.LabInstance, error)
+	Instance(ctx context.Context, namespace, name string) (*crownlabsv1alpha1.LabInstance, error)
+	CreateInstance(ctx context.Context, course, template string) (*crownlabsv1alpha1.LabInstance, error)
+	DeleteInstance(ctx context.Context, namespace, name string) error
+	SetRunning(ctx context.Context, namespace, name string, running bool) error
+	// Events invokes the handler with the description of each event concerning the LabInstance, until the context is cancelled
+	Events(ctx context.Context, namespace, name string, handler func(string)) error
+}
+
+// apiBackend manages the LabInstances through the API of the operator
+type apiBackend struct {
+	client *apiserver.Client
+}
+
+// NewAPIBackend returns a backend invoking the API of the operator through the given client
+func NewAPIBackend(c *apiserver.Client) Backend {
+	return &apiBackend{client: c}
+}
+
+func (b *apiBackend) Templates(ctx context.Context, course string) ([]crownlabsv1alpha1.LabTemplate, error) {
+	templates, err := b.client.Templates(ctx)
+	if err != nil || course == "" {
+		return templates, err
+	}
+	var filtered []crownlabsv1alpha1.LabTemplate
+	for _, template := range templates {
+		if template.Namespace == course {
+			filtered = append(filtered, template)
+		}
+	}
+	return filtered, nil
+}
+
+func (b *apiBackend) Template(ctx context.Context, namespace, name string) (*crownlabsv1alpha1.LabTemplate, error) {
+	templates, err := b.Templates(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for i := range templates {
+		if templates[i].Name == name {
+			return &templates[i], nil
+		}
+	}
+	return nil, fmt.Errorf("LabTemplate %v/%v not found", namespace, name)
+}
+
+func (b *apiBackend) Instances(ctx context.Context) ([]crownlabsv1alpha1.LabInstance, error) {
+	return b.client.Instances(ctx)
+}
+
+func (b *apiBackend) Instance(ctx context.Context, namespace, name string) (*crownlabsv1alpha1.LabInstance, error) {
+	return b.client.Instance(ctx, namespace, name)
+}
+
+func (b *apiBackend) CreateInstance(ctx context.Context, course, template string) (*crownlabsv1alpha1.LabInstance, error) {
+	return b.client.CreateInstance(ctx, course, template)
+}
+
+func (b *apiBackend) DeleteInstance(ctx context.Context, namespace, name string) error {
+	return b.client.DeleteInstance(ctx, namespace, name)
+}
+
+func (b *apiBackend) SetRunning(ctx context.Context, namespace, name string, running bool) error {
+	return b.client.SetRunning(ctx, namespace, name, running)
+}
+
+// Events reports the changes of the phase of the LabInstance, since the API does not expose the Kubernetes events
+func (b *apiBackend) Events(ctx context.Context, namespace, name string, handler func(string)) error {
+	phase := ""
+	return b.client.Watch(ctx, func(event apiserver.WatchEvent) error {
+		labInstance := event.Object
+		if labInstance.Name != name || (namespace != "" && labInstance.Namespace != namespace) {
+			return nil
+		}
+		if event.Type == apiserver.Deleted {
+			handler("LabInstance " + name + " deleted")
+			return fmt.Errorf("LabInstance %v deleted", name)
+		}
+		if labInstance.Status.Phase != phase {
+			phase = labInstance.Status.Phase
+			msg := "Phase " + phase
+			if failure := labInstance.Status.Failure; failure != nil {
+				msg += ": " + failure.Reason + " " + failure.Message
+			}
+			handler(msg)
+		}
+		return nil
+	})
+}
+
+// kubeBackend manages the LabInstances directly through Kubernetes, with the credentials of the kubeconfig
+type kubeBackend struct {
+	client    client.Client
+	namespace string
+	studentID string
+}
+
+// NewKubeBackend returns a backend managing the LabInstances of the given namespace through the given client
+func NewKubeBackend(c client.Client, namespace, studentID string) Backend {
+	return &kubeBackend{client: c, namespace: namespace, studentID: studentID}
+}
+
+func (b *kubeBackend) ns(namespace string) string {
+	if namespace == "" {
+		return b.namespace
+	}
+	return namespace
+}
+
+func (b *kubeBackend) Templates(ctx context.Context, course string) ([]crownlabsv1alpha1.LabTemplate, error) {
+	if course == "" {
+		return nil, fmt.Errorf("the course namespace is required when using the kubeconfig")
+	}
+	var list crownlabsv1alpha1.LabTemplateList
+	err := b.client.List(ctx, &list, client.InNamespace(course))
+	return list.Items, err
+}
+
+func (b *kubeBackend) Template(ctx context.Context, namespace, name string) (*crownlabsv1alpha1.LabTemplate, error) {
+	var template crownlabsv1alpha1.LabTemplate
+	err := b.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &template)
+	return &template, err
+}
+
+func (b *kubeBackend) Instances(ctx context.Context) ([]crownlabsv1alpha1.LabInstance, error) {
+	var list crownlabsv1alpha1.LabInstanceList
+	err := b.client.List(ctx, &list, client.InNamespace(b.namespace))
+	return list.Items, err
+}
+
+func (b *kubeBackend) Instance(ctx context.Context, namespace, name string) (*crownlabsv1alpha1.LabInstance, error) {
+	var labInstance crownlabsv1alpha1.LabInstance
+	err := b.client.Get(ctx, types.NamespacedName{Namespace: b.ns(namespace), Name: name}, &labInstance)
+	return &labInstance, err
+}
+
+func (b *kubeBackend) CreateInstance(ctx context.Context, course, template string) (*crownlabsv1alpha1.LabInstance, error) {
+	labTemplate, err := b.Template(ctx, course, template)
+	if err != nil {
+		return nil, err
+	}
+	labInstance := apiserver.NewInstance(labTemplate, b.namespace, b.studentID)
+	if err := b.client.Create(ctx, &labInstance); err != nil {
+		return nil, err
+	}
+	return &labInstance, nil
+}
+
+func (b *kubeBackend) DeleteInstance(ctx context.Context, namespace, name string) error {
+	labInstance, err := b.Instance(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	return b.client.Delete(ctx, labInstance)
+}
+
+func (b *kubeBackend) SetRunning(ctx context.Context, namespace, name string, running bool) error {
+	labInstance, err := b.Instance(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	if !instanceCreation.SetRunning(labInstance, running) {
+		return nil
+	}
+	return b.client.Update(ctx, labInstance)
+}
+
+// Events polls the Kubernetes events concerning the LabInstance, reporting them in chronological order
+func (b *kubeBackend) Events(ctx context.Context, namespace, name string, handler func(string)) error {
+	seen := map[types.UID]int32{}
+	for {
+		var list corev1.EventList
+		if err := b.client.List(ctx, &list, client.InNamespace(b.ns(namespace))); err != nil {
+			return err
+		}
+		events := list.Items
+		sort.SliceStable(events, func(i, j int) bool { return events[i].LastTimestamp.Before(&events[j].LastTimestamp) })
+		for _, event := range events {
+			if event.InvolvedObject.Kind != "LabInstance" || event.InvolvedObject.Name != name || seen[event.UID] == event.Count {
+				continue
+			}
+			seen[event.UID] = event.Count
+			handler(fmt.Sprintf("%v %v %v: %v", event.LastTimestamp.Format(time.RFC3339), event.Type, event.Reason, event.Message))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(eventsPollPeriod):
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package crownlabsctl implements the commands of the command-line client of CrownLabs, which manages the
+// LabInstances of the user either through the API of the operator (authenticating with the OIDC device flow)
+// or directly through Kubernetes (with the credentials of the kubeconfig).
+package crownlabsctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+const (
+	readyPhase  = "VmiReady"
+	failedPhase = "VmiFailed"
+	// waitPollPeriod is the period the phase of a LabInstance is checked with, while waiting for it to be ready
+	waitPollPeriod = 2 * time.Second
+)
+
+// Usage describes the commands of the client
+const Usage = `Commands:
+  login                                     log in again with the OIDC device flow (only through the API)
+  templates [--course <namespace>]          list the LabTemplates of a course (of all the courses, if not specified)
+  instances                                 list the LabInstances of the user
+  create --course <namespace> <template>    create a LabInstance of a LabTemplate
+  start <instance>                          start again the VMs of a stopped LabInstance
+  stop <instance>                           stop the VMs of a LabInstance
+  delete <instance>                         delete a LabInstance
+  wait [--timeout <duration>] <instance>    wait until the VMs of a LabInstance are ready
+  url <instance>                            print the URL of a LabInstance
+  ssh [--user <user>] [--jump <host>] <instance> [-- <ssh arguments>]
+                                            open an SSH session to the VM of a CLI laboratory
+  events <instance>                         tail the events of a LabInstance
+`
+
+// CLI executes the commands of the client
+type CLI struct {
+	Backend Backend
+	Out     io.Writer
+	// Namespace is the namespace of the LabInstances the commands refer to (e.g. the ones of a team), empty for the one of the user
+	Namespace string
+	// Exec runs an external command (i.e. ssh) attached to the terminal
+	Exec func(name string, args ...string) error
+}
+
+// Run executes the command with the given arguments
+func (c *CLI) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%v", Usage)
+	}
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(c.Out)
+	course := flags.String("course", "", "The namespace of the course")
+	timeout := flags.Duration("timeout", 10*time.Minute, "The maximum time waited")
+	user := flags.String("user", "crownlabs", "The user of the SSH session")
+	jump := flags.String("jump", "", "The host the SSH connection is tunnelled through, when the VMs are not directly reachable")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	switch command {
+	case "templates":
+		return c.templates(ctx, *course)
+	case "instances":
+		return c.instances(ctx)
+	case "create":
+		if *course == "" || len(args) != 1 {
+			return fmt.Errorf("usage: create --course <namespace> <template>")
+		}
+		labInstance, err := c.Backend.CreateInstance(ctx, *course, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.Out, labInstance.Name)
+		return nil
+	case "start", "stop":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %v <instance>", command)
+		}
+		return c.Backend.SetRunning(ctx, c.Namespace, args[0], command == "start")
+	case "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: delete <instance>")
+		}
+		return c.Backend.DeleteInstance(ctx, c.Namespace, args[0])
+	case "wait":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: wait [--timeout <duration>] <instance>")
+		}
+		ctx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		labInstance, err := c.wait(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.Out, labInstance.Status.Url)
+		return nil
+	case "url":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: url <instance>")
+		}
+		return c.url(ctx, args[0])
+	case "ssh":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: ssh [--user <user>] [--jump <host>] <instance> [-- <ssh arguments>]")
+		}
+		return c.ssh(ctx, args[0], *user, *jump, args[1:])
+	case "events":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: events <instance>")
+		}
+		return c.Backend.Events(ctx, c.Namespace, args[0], func(event string) {
+			fmt.Fprintln(c.Out, event)
+		})
+	default:
+		return fmt.Errorf("unknown command %q\n%v", command, Usage)
+	}
+}
+
+func (c *CLI) templates(ctx context.Context, course string) error {
+	templates, err := c.Backend.Templates(ctx, course)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "COURSE\tNAME\tTYPE\tDESCRIPTION")
+	for _, template := range templates {
+		vmType := string(template.Spec.VmType)
+		if len(template.Spec.Vms) > 0 {
+			vmType = fmt.Sprintf("%v VMs", len(template.Spec.Vms))
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", template.Namespace, template.Name, vmType, template.Spec.Description)
+	}
+	return w.Flush()
+}
+
+func (c *CLI) instances(ctx context.Context) error {
+	instances, err := c.Backend.Instances(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.Out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tTEMPLATE\tPHASE\tURL")
+	for _, labInstance := range instances {
+		fmt.Fprintf(w, "%v\t%v\t%v/%v\t%v\t%v\n", labInstance.Namespace, labInstance.Name,
+			labInstance.Spec.LabTemplateNamespace, labInstance.Spec.LabTemplateName, labInstance.Status.Phase, labInstance.Status.Url)
+	}
+	return w.Flush()
+}
+
+// wait polls the LabInstance until its VMs are ready, failing if they failed or the context expires
+func (c *CLI) wait(ctx context.Context, name string) (*crownlabsv1alpha1.LabInstance, error) {
+	for {
+		labInstance, err := c.Backend.Instance(ctx, c.Namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		switch labInstance.Status.Phase {
+		case readyPhase:
+			return labInstance, nil
+		case failedPhase:
+			msg := "LabInstance " + name + " failed"
+			if failure := labInstance.Status.Failure; failure != nil {
+				msg += ": " + failure.Reason + " " + failure.Message
+				if failure.Hint != "" {
+					msg += " (" + failure.Hint + ")"
+				}
+			}
+			return nil, fmt.Errorf("%v", msg)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("LabInstance %v not ready (phase %q): %v", name, labInstance.Status.Phase, ctx.Err())
+		case <-time.After(waitPollPeriod):
+		}
+	}
+}
+
+// url prints the URL of the LabInstance, and the one of each VM of multi-VM laboratories
+func (c *CLI) url(ctx context.Context, name string) error {
+	labInstance, err := c.Backend.Instance(ctx, c.Namespace, name)
+	if err != nil {
+		return err
+	}
+	if labInstance.Status.Url == "" {
+		return fmt.Errorf("LabInstance %v is not exposed yet (phase %q)", name, labInstance.Status.Phase)
+	}
+	fmt.Fprintln(c.Out, labInstance.Status.Url)
+	for _, vm := range labInstance.Status.Vms {
+		if vm.Name != "" && vm.Url != "" {
+			fmt.Fprintf(c.Out, "%v: %v\n", vm.Name, vm.Url)
+		}
+	}
+	return nil
+}
+
+// ssh opens an SSH session to the VM of a CLI laboratory, through its IP. Since the IPs of the VMs belong to the
+// network of the cluster, the connection can be tunnelled through a jump host.
+func (c *CLI) ssh(ctx context.Context, name, user, jump string, extra []string) error {
+	labInstance, err := c.Backend.Instance(ctx, c.Namespace, name)
+	if err != nil {
+		return err
+	}
+	template, err := c.Backend.Template(ctx, labInstance.Spec.LabTemplateNamespace, labInstance.Spec.LabTemplateName)
+	if err != nil {
+		return err
+	}
+	if len(template.Spec.Vms) == 0 && template.Spec.VmType != crownlabsv1alpha1.TypeCLI {
+		return fmt.Errorf("LabInstance %v is not a CLI laboratory", name)
+	}
+	if labInstance.Status.Phase != readyPhase || labInstance.Status.IP == "" {
+		return fmt.Errorf("LabInstance %v is not ready (phase %q)", name, labInstance.Status.Phase)
+	}
+
+	args := []string{}
+	if jump != "" {
+		args = append(args, "-J", jump)
+	}
+	args = append(args, user+"@"+labInstance.Status.IP)
+	args = append(args, extra...)
+	return c.Exec("ssh", args...)
+}
+
+// ExecAttached runs a command attached to the standard streams of the client
+func ExecAttached(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v %v: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
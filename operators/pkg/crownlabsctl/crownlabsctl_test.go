@@ -0,0 +1,122 @@
+package crownlabsctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	polls := 0
+	var provider *httptest.Server
+	provider = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"token_endpoint": provider.URL + "/token", "device_authorization_endpoint": provider.URL + "/device"})
+		case "/device":
+			assert.Equal(t, r.Form.Get("client_id"), "crownlabsctl")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"device_code": "device", "user_code": "ABCD-EFGH",
+				"verification_uri": "https://auth.example.com/device", "expires_in": 600, "interval": 1})
+		case "/token":
+			polls++
+			if r.Form.Get("grant_type") == deviceGrantType && polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token-" + r.Form.Get("grant_type"), "refresh_token": "refresh", "expires_in": 300})
+		}
+	}))
+	defer provider.Close()
+
+	dir, err := ioutil.TempDir("", "crownlabsctl")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var prompt bytes.Buffer
+	device := &DeviceFlow{Issuer: provider.URL, ClientID: "crownlabsctl", CachePath: filepath.Join(dir, "token.json"), Prompt: &prompt}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	token, err := device.Token(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, token, "token-"+deviceGrantType)
+	assert.Contains(t, prompt.String(), "ABCD-EFGH")
+	assert.Equal(t, polls, 2, "The token endpoint should be polled while the authorization is pending.")
+
+	token, err = device.Token(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, token, "token-"+deviceGrantType, "The cached token should be reused.")
+	assert.Equal(t, polls, 2)
+
+	cached, _ := device.load()
+	cached.Expiry = time.Now()
+	_, _ = device.store(&tokenResponse{AccessToken: cached.AccessToken, RefreshToken: cached.RefreshToken})
+	token, err = device.Token(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, token, "token-refresh_token", "The expired token should be refreshed.")
+}
+
+func TestCLI(t *testing.T) {
+	template := &crownlabsv1alpha1.LabTemplate{ObjectMeta: metav1.ObjectMeta{Namespace: "course-sdn", Name: "lab1"},
+		Spec: crownlabsv1alpha1.LabTemplateSpec{VmType: crownlabsv1alpha1.TypeCLI, Description: "First lab"}}
+	ready := &crownlabsv1alpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-s123456", Name: "ready"},
+		Spec:   crownlabsv1alpha1.LabInstanceSpec{LabTemplateName: "lab1", LabTemplateNamespace: "course-sdn"},
+		Status: crownlabsv1alpha1.LabInstanceStatus{Phase: readyPhase, IP: "10.0.0.1", Url: "https://crownlabs.polito.it/uuid"}}
+	failed := &crownlabsv1alpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-s123456", Name: "failed"},
+		Status: crownlabsv1alpha1.LabInstanceStatus{Phase: failedPhase, Failure: &crownlabsv1alpha1.FailureStatus{Reason: "OutOfMemory"}}}
+	s := runtime.NewScheme()
+	assert.NoError(t, crownlabsv1alpha1.AddToScheme(s))
+	c := fake.NewFakeClientWithScheme(s, template, ready, failed)
+
+	var out bytes.Buffer
+	var executed []string
+	cli := &CLI{Backend: NewKubeBackend(c, "tenant-s123456", "s123456"), Out: &out, Exec: func(name string, args ...string) error {
+		executed = append([]string{name}, args...)
+		return nil
+	}}
+	ctx := context.Background()
+	run := func(args ...string) error {
+		out.Reset()
+		return cli.Run(ctx, args)
+	}
+
+	assert.NoError(t, run("templates", "--course", "course-sdn"))
+	assert.Contains(t, out.String(), "First lab")
+	assert.Error(t, run("templates"), "The course should be required with the kubeconfig.")
+
+	assert.NoError(t, run("create", "--course", "course-sdn", "lab1"))
+	assert.True(t, strings.HasPrefix(out.String(), "lab1-s123456-"))
+
+	assert.NoError(t, run("wait", "ready"))
+	assert.Equal(t, out.String(), "https://crownlabs.polito.it/uuid\n")
+	err := run("wait", "--timeout", "1s", "failed")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "OutOfMemory", "The diagnosis of the failure should be reported.")
+
+	assert.NoError(t, run("ssh", "--jump", "bastion.example.com", "ready", "uptime"))
+	assert.Equal(t, executed, []string{"ssh", "-J", "bastion.example.com", "crownlabs@10.0.0.1", "uptime"})
+
+	assert.NoError(t, run("stop", "ready"))
+	labInstance, err := cli.Backend.Instance(ctx, "", "ready")
+	assert.NoError(t, err)
+	assert.True(t, instanceCreation.IsStopped(*labInstance))
+
+	assert.Error(t, run("unknown"))
+}
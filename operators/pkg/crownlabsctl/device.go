@@ -0,0 +1,231 @@
+package crownlabsctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// deviceGrantType is the grant type of the OAuth 2.0 device authorization grant (RFC 8628)
+	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	// expiryMargin is the validity the cached tokens must still have to be used
+	expiryMargin = 30 * time.Second
+)
+
+// Token is the token cached between the invocations of the CLI
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// DeviceFlow obtains the tokens of the user from the OIDC provider through the device authorization grant: the user
+// logs in with a browser, possibly on another device, while the CLI waits for the tokens. The tokens are cached in
+// the given file and refreshed when expired.
+type DeviceFlow struct {
+	Issuer    string
+	ClientID  string
+	CachePath string
+	HTTP      *http.Client
+	// Prompt receives the instructions for the user
+	Prompt io.Writer
+}
+
+// tokenResponse is the response of the token endpoint
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// Token returns a valid access token, from the cache if possible
+func (d *DeviceFlow) Token(ctx context.Context) (string, error) {
+	cached, _ := d.load()
+	if cached != nil && time.Until(cached.Expiry) > expiryMargin {
+		return cached.AccessToken, nil
+	}
+
+	endpoints, err := d.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	if cached != nil && cached.RefreshToken != "" {
+		resp, err := d.post(ctx, endpoints.Token, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {cached.RefreshToken}})
+		if err == nil && resp.Error == "" {
+			return d.store(resp)
+		}
+	}
+	return d.Login(ctx)
+}
+
+// Login performs the device authorization grant, regardless of the cached token
+func (d *DeviceFlow) Login(ctx context.Context) (string, error) {
+	endpoints, err := d.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	if endpoints.DeviceAuthorization == "" {
+		return "", fmt.Errorf("the provider does not support the device authorization grant")
+	}
+
+	var authorization struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}
+	if err := d.postJSON(ctx, endpoints.DeviceAuthorization, url.Values{"scope": {"openid"}}, &authorization); err != nil {
+		return "", fmt.Errorf("unable to start the device authorization: %v", err)
+	}
+	verification := authorization.VerificationURIComplete
+	if verification == "" {
+		verification = authorization.VerificationURI
+	}
+	fmt.Fprintf(d.prompt(), "To log in, open %v and confirm the code %v\n", verification, authorization.UserCode)
+
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+
+		resp, err := d.post(ctx, endpoints.Token, url.Values{"grant_type": {deviceGrantType}, "device_code": {authorization.DeviceCode}})
+		if err != nil {
+			return "", err
+		}
+		switch resp.Error {
+		case "":
+			return d.store(resp)
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return "", fmt.Errorf("login failed: %v %v", resp.Error, resp.Description)
+		}
+		if authorization.ExpiresIn > 0 && time.Now().After(deadline) {
+			return "", fmt.Errorf("login failed: the code is expired")
+		}
+	}
+}
+
+type providerEndpoints struct {
+	Token               string `json:"token_endpoint"`
+	DeviceAuthorization string `json:"device_authorization_endpoint"`
+}
+
+func (d *DeviceFlow) endpoints(ctx context.Context) (*providerEndpoints, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(d.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to retrieve the configuration of the provider: status %v", resp.StatusCode)
+	}
+	var endpoints providerEndpoints
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, err
+	}
+	return &endpoints, nil
+}
+
+// post invokes the token endpoint, whose errors are reported in the response
+func (d *DeviceFlow) post(ctx context.Context, endpoint string, form url.Values) (*tokenResponse, error) {
+	var resp tokenResponse
+	if err := d.postJSON(ctx, endpoint, form, &resp); err != nil && resp.Error == "" {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (d *DeviceFlow) postJSON(ctx context.Context, endpoint string, form url.Values, target interface{}) error {
+	form.Set("client_id", d.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decodeErr := json.NewDecoder(resp.Body).Decode(target)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v from %v", resp.StatusCode, endpoint)
+	}
+	return decodeErr
+}
+
+func (d *DeviceFlow) store(resp *tokenResponse) (string, error) {
+	token := Token{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	if d.CachePath != "" {
+		data, err := json.Marshal(&token)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(d.CachePath), 0700); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(d.CachePath, data, 0600); err != nil {
+			return "", err
+		}
+	}
+	return token.AccessToken, nil
+}
+
+func (d *DeviceFlow) load() (*Token, error) {
+	if d.CachePath == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(d.CachePath)
+	if err != nil {
+		return nil, err
+	}
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (d *DeviceFlow) client() *http.Client {
+	if d.HTTP != nil {
+		return d.HTTP
+	}
+	return http.DefaultClient
+}
+
+func (d *DeviceFlow) prompt() io.Writer {
+	if d.Prompt != nil {
+		return d.Prompt
+	}
+	return os.Stderr
+}
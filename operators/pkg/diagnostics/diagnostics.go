@@ -0,0 +1,151 @@
+// Package diagnostics summarises the cause of the failures of the VMs of the LabInstances, from the
+// conditions of the VMIs, the state of their virt-launcher pods and the related events.
+package diagnostics
+
+import (
+	"sort"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+// The summarised causes of the failures
+const (
+	Unschedulable   = "Unschedulable"
+	ImagePullFailed = "ImagePullFailed"
+	OutOfMemory     = "OutOfMemory"
+	Preempted       = "Preempted"
+	LauncherFailed  = "LauncherFailed"
+	NotReady        = "NotReady"
+	Unknown         = "Unknown"
+)
+
+// hints suggest how to solve each cause of failure
+var hints = map[string]string{
+	Unschedulable:   "The cluster has not enough free resources to run the VM: try again later, or ask the administrators to reduce the resources of the LabTemplate.",
+	ImagePullFailed: "The disk image of the VM cannot be downloaded: ask the teachers to check the image of the LabTemplate and the credentials of the registry.",
+	OutOfMemory:     "The VM exceeded its memory limit: ask the teachers to increase the memory of the LabTemplate.",
+	Preempted:       "The VM has been evicted in favour of a higher priority instance (e.g. an exam): try again once the contention is over.",
+	LauncherFailed:  "The hypervisor of the VM terminated unexpectedly: try again, and contact the administrators if the failure persists.",
+	NotReady:        "The VM is running, but it does not reply to the readiness checks: check that the services of the VM start correctly.",
+	Unknown:         "Try again, and contact the administrators if the failure persists.",
+}
+
+// Diagnosis is the summarised cause of a failure, with a hint about how to solve it
+type Diagnosis struct {
+	Reason  string
+	Message string
+	Hint    string
+}
+
+// Status converts the diagnosis into the failure status of a LabInstance
+func (d Diagnosis) Status() crownlabsv1alpha1.FailureStatus {
+	return crownlabsv1alpha1.FailureStatus{Reason: d.Reason, Message: d.Message, Hint: d.Hint}
+}
+
+func diagnosis(reason string, message string) Diagnosis {
+	return Diagnosis{Reason: reason, Message: message, Hint: hints[reason]}
+}
+
+// Diagnose returns the cause of the failure of a VMI, given its virt-launcher pods and the events concerning
+// the VMI and the pods. The most specific causes are preferred: first the ones reported by the pods (e.g. image
+// pull and scheduling failures), then the conditions of the VMI, and finally the last warning event.
+func Diagnose(vmi virtv1.VirtualMachineInstance, pods []corev1.Pod, events []corev1.Event) Diagnosis {
+	for _, event := range events {
+		if event.Reason == "Preempted" {
+			return diagnosis(Preempted, event.Message)
+		}
+	}
+
+	for _, pod := range pods {
+		if d, ok := diagnosePod(pod); ok {
+			return d
+		}
+	}
+
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Status != corev1.ConditionFalse || condition.Message == "" {
+			continue
+		}
+		if condition.Reason == corev1.PodReasonUnschedulable {
+			return diagnosis(Unschedulable, condition.Message)
+		}
+	}
+
+	if event, ok := lastWarning(events); ok {
+		switch {
+		case event.Reason == "FailedScheduling":
+			return diagnosis(Unschedulable, event.Message)
+		case strings.Contains(event.Reason, "Pull") || strings.Contains(event.Message, "pull"):
+			return diagnosis(ImagePullFailed, event.Message)
+		}
+		return diagnosis(Unknown, event.Reason+": "+event.Message)
+	}
+
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Status == corev1.ConditionFalse && condition.Message != "" {
+			return diagnosis(Unknown, string(condition.Type)+": "+condition.Message)
+		}
+	}
+	return diagnosis(Unknown, "VirtualMachineInstance "+vmi.Name+" is in phase "+string(vmi.Status.Phase))
+}
+
+// DiagnoseReadiness returns the cause of the failure of the readiness checks of a running VM
+func DiagnoseReadiness(status crownlabsv1alpha1.ReadinessStatus) Diagnosis {
+	return diagnosis(NotReady, "Readiness check "+string(status.Check)+" failed: "+status.Reason)
+}
+
+func diagnosePod(pod corev1.Pod) (Diagnosis, bool) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == corev1.PodReasonUnschedulable {
+			return diagnosis(Unschedulable, condition.Message), true
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+				return diagnosis(ImagePullFailed, waiting.Message), true
+			}
+		}
+		terminated := status.State.Terminated
+		if terminated == nil {
+			terminated = status.LastTerminationState.Terminated
+		}
+		if terminated == nil || terminated.ExitCode == 0 {
+			continue
+		}
+		if terminated.Reason == "OOMKilled" {
+			return diagnosis(OutOfMemory, "Container "+status.Name+" of pod "+pod.Name+" has been killed since out of memory"), true
+		}
+		message := terminated.Message
+		if message == "" {
+			message = "Container " + status.Name + " of pod " + pod.Name + " terminated with reason " + terminated.Reason
+		}
+		return diagnosis(LauncherFailed, message), true
+	}
+	return Diagnosis{}, false
+}
+
+// lastWarning returns the most recent warning event
+func lastWarning(events []corev1.Event) (corev1.Event, bool) {
+	var warnings []corev1.Event
+	for _, event := range events {
+		if event.Type == corev1.EventTypeWarning {
+			warnings = append(warnings, event)
+		}
+	}
+	if len(warnings) == 0 {
+		return corev1.Event{}, false
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].LastTimestamp.Before(&warnings[j].LastTimestamp)
+	})
+	return warnings[len(warnings)-1], true
+}
@@ -0,0 +1,74 @@
+package diagnostics
+
+import (
+	"testing"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+func launcher(status corev1.ContainerStatus) corev1.Pod {
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "virt-launcher-vmi-abcde"}}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{status}
+	return pod
+}
+
+func warning(reason string, message string, at time.Time) corev1.Event {
+	return corev1.Event{Type: corev1.EventTypeWarning, Reason: reason, Message: message, LastTimestamp: metav1.NewTime(at)}
+}
+
+func TestDiagnose(t *testing.T) {
+	vmi := virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "vmi"}}
+	vmi.Status.Phase = virtv1.Failed
+	now := time.Now()
+
+	unschedulable := corev1.Pod{}
+	unschedulable.Status.Conditions = []corev1.PodCondition{{
+		Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable, Message: "0/3 nodes are available: 3 Insufficient memory.",
+	}}
+	imagePull := launcher(corev1.ContainerStatus{State: corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}}})
+	oom := launcher(corev1.ContainerStatus{LastTerminationState: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}}})
+	crashed := launcher(corev1.ContainerStatus{State: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}})
+
+	tests := []struct {
+		name   string
+		pods   []corev1.Pod
+		events []corev1.Event
+		reason string
+	}{
+		{"unschedulable", []corev1.Pod{unschedulable}, nil, Unschedulable},
+		{"image pull", []corev1.Pod{imagePull}, nil, ImagePullFailed},
+		{"out of memory", []corev1.Pod{oom}, nil, OutOfMemory},
+		{"launcher crash", []corev1.Pod{crashed}, nil, LauncherFailed},
+		{"preemption", []corev1.Pod{crashed}, []corev1.Event{{Reason: "Preempted", Message: "Preempted by exam"}}, Preempted},
+		{"scheduling event", nil, []corev1.Event{warning("FailedScheduling", "no nodes available", now)}, Unschedulable},
+		{"pull event", nil, []corev1.Event{
+			warning("FailedScheduling", "no nodes available", now.Add(-time.Minute)),
+			warning("Failed", "Failed to pull image \"registry/vm:latest\"", now),
+		}, ImagePullFailed},
+		{"unknown", nil, nil, Unknown},
+	}
+
+	for _, test := range tests {
+		diagnosis := Diagnose(vmi, test.pods, test.events)
+		assert.Equal(t, diagnosis.Reason, test.reason, test.name)
+		assert.NotEmpty(t, diagnosis.Message, test.name)
+		assert.NotEmpty(t, diagnosis.Hint, test.name)
+	}
+}
+
+func TestDiagnoseReadiness(t *testing.T) {
+	diagnosis := DiagnoseReadiness(crownlabsv1alpha1.ReadinessStatus{Result: "Failed", Check: crownlabsv1alpha1.ReadinessSSH, Reason: "connection refused"})
+	assert.Equal(t, diagnosis.Reason, NotReady)
+	assert.Contains(t, diagnosis.Message, "connection refused")
+
+	status := diagnosis.Status()
+	assert.Equal(t, status.Hint, hints[NotReady])
+}
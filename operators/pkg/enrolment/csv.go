@@ -0,0 +1,114 @@
+package enrolment
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// The columns of the CSV files of the provisioning script
+const (
+	ColumnCourse    = "Course (code)"
+	columnUsername  = "Username"
+	columnEmail     = "Email"
+	columnLastName  = "Last name"
+	columnFirstName = "First name"
+)
+
+// ReadCSV reads a CSV file with a header, returning each row as a map from the columns to the values. As in
+// the provisioning script, the lines starting with # are ignored.
+func ReadCSV(r io.Reader) ([]map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	header := lines[0]
+	var rows []map[string]string
+	for i, line := range lines[1:] {
+		if len(line) == 1 && strings.TrimSpace(line[0]) == "" {
+			continue
+		}
+		if len(line) != len(header) {
+			return nil, fmt.Errorf("line %v: %v fields, while the header has %v", i+2, len(line), len(header))
+		}
+		row := map[string]string{}
+		for j, column := range header {
+			row[strings.TrimSpace(column)] = strings.TrimSpace(line[j])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ParseMembers parses a teachers.csv or students.csv file of the provisioning script, whose rows are
+// Username,Email,Last name,First name,Course (code), enrolling the tenants with the given role.
+func ParseMembers(r io.Reader, role crownlabsv1alpha1.EnrolmentRole) ([]Record, error) {
+	rows, err := ReadCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for i, row := range rows {
+		if row[columnUsername] == "" || row[ColumnCourse] == "" {
+			return nil, fmt.Errorf("line %v: missing %v or %v", i+2, columnUsername, ColumnCourse)
+		}
+		records = append(records, Record{
+			TenantSpec: crownlabsv1alpha1.TenantSpec{
+				StudentID: row[columnUsername],
+				Email:     row[columnEmail],
+				FirstName: strings.Title(strings.ToLower(row[columnFirstName])),
+				LastName:  strings.Title(strings.ToLower(row[columnLastName])),
+			},
+			Courses: map[string]crownlabsv1alpha1.EnrolmentRole{strings.ToLower(row[ColumnCourse]): role},
+		})
+	}
+	return records, nil
+}
+
+// CSVSource provides the tenants listed in the teachers.csv and students.csv files of the provisioning script,
+// which are read again at each synchronization.
+type CSVSource struct {
+	Teachers string
+	Students string
+}
+
+// Name identifies the source
+func (s *CSVSource) Name() string {
+	return "csv"
+}
+
+// Records returns the tenants listed in the files
+func (s *CSVSource) Records(ctx context.Context) ([]Record, error) {
+	var records []Record
+	for _, file := range []struct {
+		path string
+		role crownlabsv1alpha1.EnrolmentRole
+	}{{s.Teachers, crownlabsv1alpha1.RoleTeacher}, {s.Students, crownlabsv1alpha1.RoleStudent}} {
+		if file.path == "" {
+			continue
+		}
+		f, err := os.Open(file.path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := ParseMembers(f, file.role)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file.path, err)
+		}
+		records = append(records, parsed...)
+	}
+	return Merge(records), nil
+}
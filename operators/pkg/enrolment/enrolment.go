@@ -0,0 +1,115 @@
+// Package enrolment manages the Tenants and their Enrolments in the Courses, which are imported from the CSV files
+// of the provisioning script or periodically synchronized from an external directory (e.g. Keycloak). They are
+// used to authorize the access to the LabTemplates of the courses and to enforce the quotas of the tenants.
+package enrolment
+
+import (
+	"context"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/instanceCreation"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CourseLabel is the label of the namespaces generated for the Courses, whose value is the name of the Course
+const CourseLabel = "crownlabs.polito.it/course"
+
+// TenantName returns the name of the Tenant with the given StudentID, i.e. the name of its namespace without the tenant- prefix
+func TenantName(studentID string) string {
+	return strings.TrimPrefix(instanceCreation.TenantName(studentID), "tenant-")
+}
+
+// TenantNameFromNamespace returns the name of the Tenant owning the given namespace
+func TenantNameFromNamespace(namespace string) string {
+	return strings.TrimPrefix(namespace, "tenant-")
+}
+
+// Name returns the name of the Enrolment of a tenant in a course
+func Name(course, tenant string) string {
+	return course + "-" + tenant
+}
+
+// GetTenant returns the Tenant owning the given namespace, or nil if it does not exist
+func GetTenant(ctx context.Context, c client.Client, namespace string) (*crownlabsv1alpha1.Tenant, error) {
+	var tenant crownlabsv1alpha1.Tenant
+	if err := c.Get(ctx, types.NamespacedName{Name: TenantNameFromNamespace(namespace)}, &tenant); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// TenantEnrolments returns the Enrolments of the given Tenant
+func TenantEnrolments(ctx context.Context, c client.Client, tenant string) ([]crownlabsv1alpha1.Enrolment, error) {
+	return list(ctx, c, func(enrolment *crownlabsv1alpha1.Enrolment) bool { return enrolment.Spec.Tenant == tenant })
+}
+
+// CourseEnrolments returns the Enrolments in the given Course
+func CourseEnrolments(ctx context.Context, c client.Client, course string) ([]crownlabsv1alpha1.Enrolment, error) {
+	return list(ctx, c, func(enrolment *crownlabsv1alpha1.Enrolment) bool { return enrolment.Spec.Course == course })
+}
+
+func list(ctx context.Context, c client.Client, filter func(*crownlabsv1alpha1.Enrolment) bool) ([]crownlabsv1alpha1.Enrolment, error) {
+	var enrolments crownlabsv1alpha1.EnrolmentList
+	if err := c.List(ctx, &enrolments); err != nil {
+		return nil, err
+	}
+	var result []crownlabsv1alpha1.Enrolment
+	for i := range enrolments.Items {
+		if filter(&enrolments.Items[i]) {
+			result = append(result, enrolments.Items[i])
+		}
+	}
+	return result, nil
+}
+
+// MaxInstances returns the maximum number of LabInstances the owner of the given namespace can own: the one of
+// its Tenant, if specified, or the given default otherwise
+func MaxInstances(ctx context.Context, c client.Client, namespace string, defaultMax int) (int, error) {
+	tenant, err := GetTenant(ctx, c, namespace)
+	if err != nil || tenant == nil || tenant.Spec.MaxInstances == nil {
+		return defaultMax, err
+	}
+	return int(*tenant.Spec.MaxInstances), nil
+}
+
+// Authorized returns whether the owner of the given namespace can use the LabTemplates of the given course namespace.
+// The enrolment (through an Enrolment or the Course itself) is required only if the course namespace has been
+// generated for a Course, so that the courses not yet managed through the CRDs are not affected; instead, the owners
+// of the namespaces without a Tenant are authorized only if listed in the Course, like any other tenant.
+func Authorized(ctx context.Context, c client.Client, namespace, courseNamespace string) (bool, error) {
+	var ns corev1.Namespace
+	if err := c.Get(ctx, types.NamespacedName{Name: courseNamespace}, &ns); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	course, managed := ns.Labels[CourseLabel]
+	if !managed || namespace == courseNamespace {
+		return true, nil
+	}
+	tenant := TenantNameFromNamespace(namespace)
+
+	// the tenants can be enrolled also by listing them in the Course itself
+	var courseObj crownlabsv1alpha1.Course
+	if err := c.Get(ctx, types.NamespacedName{Name: course}, &courseObj); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	for _, member := range append(courseObj.Spec.Teachers, courseObj.Spec.Students...) {
+		if TenantName(member.Username) == tenant {
+			return true, nil
+		}
+	}
+	enrolments, err := TenantEnrolments(ctx, c, tenant)
+	for _, enrolment := range enrolments {
+		if enrolment.Spec.Course == course {
+			return true, nil
+		}
+	}
+	return false, err
+}
@@ -0,0 +1,163 @@
+package enrolment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/keycloak"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const students = `Username,Email,Last name,First name,Course (code)
+# a commented line
+john.doe,john.doe@email.com,DOE,john,SWNET
+jane.smith,jane.smith@email.com,Smith,Jane,swnet
+jane.smith,jane.smith@email.com,Smith,Jane,cloud
+`
+
+func newClient(t *testing.T, objs ...runtime.Object) client.Client {
+	s := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(s))
+	assert.NoError(t, crownlabsv1alpha1.AddToScheme(s))
+	return fake.NewFakeClientWithScheme(s, objs...)
+}
+
+func TestParseMembers(t *testing.T) {
+	records, err := ParseMembers(strings.NewReader(students), crownlabsv1alpha1.RoleStudent)
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, records[0].FirstName, "John")
+	assert.Equal(t, records[0].LastName, "Doe")
+	assert.Equal(t, records[0].Courses, map[string]crownlabsv1alpha1.EnrolmentRole{"swnet": crownlabsv1alpha1.RoleStudent})
+
+	merged := Merge(append(records, Record{
+		TenantSpec: crownlabsv1alpha1.TenantSpec{StudentID: "jane.smith"},
+		Courses:    map[string]crownlabsv1alpha1.EnrolmentRole{"swnet": crownlabsv1alpha1.RoleTeacher},
+	}))
+	assert.Len(t, merged, 2)
+	assert.Equal(t, merged[1].Courses, map[string]crownlabsv1alpha1.EnrolmentRole{
+		"swnet": crownlabsv1alpha1.RoleTeacher, "cloud": crownlabsv1alpha1.RoleStudent,
+	}, "The records of the same tenant should be merged, keeping the teacher role.")
+
+	_, err = ParseMembers(strings.NewReader("Username,Email\njohn.doe,john.doe@email.com\n"), crownlabsv1alpha1.RoleStudent)
+	assert.Error(t, err, "The rows without a course should be rejected.")
+}
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	max := int32(2)
+	// the quota of an existing tenant, which is not provided by the source
+	existing := &crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "jane-smith"}, Spec: crownlabsv1alpha1.TenantSpec{MaxInstances: &max}}
+	c := newClient(t, existing)
+	records, err := ParseMembers(strings.NewReader(students), crownlabsv1alpha1.RoleStudent)
+	assert.NoError(t, err)
+
+	result, err := Apply(ctx, c, "csv", records, true)
+	assert.NoError(t, err)
+	assert.Contains(t, result.Updated, "Enrolment cloud-jane-smith")
+	tenant, err := GetTenant(ctx, c, "tenant-jane-smith")
+	assert.NoError(t, err)
+	assert.Equal(t, tenant.Spec.StudentID, "jane.smith")
+	assert.Equal(t, *tenant.Spec.MaxInstances, int32(2), "The quota of the tenant should be preserved.")
+
+	result, err = Apply(ctx, c, "csv", records, true)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Updated, "Nothing should be updated when the records are unchanged.")
+
+	// the tenants imported from another source are not pruned
+	other := &crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "other", Labels: map[string]string{SourceLabel: "keycloak"}}}
+	assert.NoError(t, c.Create(ctx, other))
+	result, err = Apply(ctx, c, "csv", records[2:], true)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, result.Deleted, []string{"Enrolment swnet-john-doe", "Enrolment swnet-jane-smith", "Tenant john-doe"})
+	enrolments, err := CourseEnrolments(ctx, c, "swnet")
+	assert.NoError(t, err)
+	assert.Empty(t, enrolments)
+	tenant, err = GetTenant(ctx, c, "tenant-other")
+	assert.NoError(t, err)
+	assert.NotNil(t, tenant)
+}
+
+func TestAuthorized(t *testing.T) {
+	ctx := context.Background()
+	max := int32(3)
+	c := newClient(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "course-swnet", Labels: map[string]string{CourseLabel: "swnet"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "course-legacy"}},
+		&crownlabsv1alpha1.Course{ObjectMeta: metav1.ObjectMeta{Name: "swnet"}, Spec: crownlabsv1alpha1.CourseSpec{
+			Teachers: []crownlabsv1alpha1.CourseMember{{Username: "william.brown"}},
+		}},
+		&crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "john-doe"}, Spec: crownlabsv1alpha1.TenantSpec{StudentID: "john.doe", MaxInstances: &max}},
+		&crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "jane-smith"}},
+		&crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: "william-brown"}},
+		&crownlabsv1alpha1.Enrolment{ObjectMeta: metav1.ObjectMeta{Name: "swnet-john-doe"},
+			Spec: crownlabsv1alpha1.EnrolmentSpec{Tenant: "john-doe", Course: "swnet", Role: crownlabsv1alpha1.RoleStudent}},
+	)
+
+	for namespace, expected := range map[string]bool{
+		"tenant-john-doe":      true,
+		"tenant-william-brown": true,
+		"tenant-jane-smith":    false,
+		"tenant-unknown":       false,
+		"course-swnet":         true,
+	} {
+		authorized, err := Authorized(ctx, c, namespace, "course-swnet")
+		assert.NoError(t, err)
+		assert.Equal(t, authorized, expected, "Unexpected authorization of %v.", namespace)
+	}
+	authorized, err := Authorized(ctx, c, "tenant-jane-smith", "course-legacy")
+	assert.NoError(t, err)
+	assert.True(t, authorized, "The courses not managed through a Course should not be affected.")
+
+	maxInstances, err := MaxInstances(ctx, c, "tenant-john-doe", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, maxInstances, 3)
+	maxInstances, err = MaxInstances(ctx, c, "tenant-jane-smith", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, maxInstances, 5)
+}
+
+func TestKeycloakSource(t *testing.T) {
+	members := map[string][]keycloak.User{
+		"1": {{Username: "john.doe", Email: "john.doe@email.com", FirstName: "John", LastName: "Doe"}},
+		"2": {{Username: "william.brown"}},
+		"3": {{Username: "admin"}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/realms/crownlabs/protocol/openid-connect/token":
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, r.Form.Get("grant_type"), "client_credentials")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 300})
+		case r.Header.Get("Authorization") != "Bearer token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/admin/realms/crownlabs/groups":
+			_ = json.NewEncoder(w).Encode([]keycloak.Group{{ID: "1", Name: "course-swnet"}, {ID: "2", Name: "course-swnet-admin"}, {ID: "3", Name: "admins"}})
+		case strings.HasPrefix(r.URL.Path, "/admin/realms/crownlabs/groups/"):
+			id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/realms/crownlabs/groups/"), "/members")
+			_ = json.NewEncoder(w).Encode(members[id])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	source := &KeycloakSource{Client: &keycloak.Client{BaseURL: server.URL, Realm: "crownlabs", ClientID: "operator", ClientSecret: "secret"}}
+	records, err := source.Records(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, records, 2, "Only the members of the groups of the courses should be provided.")
+	assert.Equal(t, records[0].Email, "john.doe@email.com")
+	assert.Equal(t, records[0].Courses, map[string]crownlabsv1alpha1.EnrolmentRole{"swnet": crownlabsv1alpha1.RoleStudent})
+	assert.Equal(t, records[1].Courses, map[string]crownlabsv1alpha1.EnrolmentRole{"swnet": crownlabsv1alpha1.RoleTeacher})
+}
@@ -0,0 +1,63 @@
+package enrolment
+
+import (
+	"context"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/keycloak"
+)
+
+const (
+	// courseGroupPrefix is the prefix of the Keycloak groups of the members of the courses (i.e. course-<name>)
+	courseGroupPrefix = "course-"
+	// adminGroupSuffix is the suffix of the Keycloak groups of the teachers of the courses (i.e. course-<name>-admin)
+	adminGroupSuffix = "-admin"
+)
+
+// KeycloakSource provides the users of the Keycloak realm which are members of the groups of the courses, as
+// created by the provisioning script. Since Keycloak can federate an LDAP directory, it also covers the tenants
+// defined in LDAP.
+type KeycloakSource struct {
+	Client *keycloak.Client
+}
+
+// Name identifies the source
+func (s *KeycloakSource) Name() string {
+	return "keycloak"
+}
+
+// Records returns the members of the groups of the courses
+func (s *KeycloakSource) Records(ctx context.Context) ([]Record, error) {
+	groups, err := s.Client.Groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, group := range groups {
+		if !strings.HasPrefix(group.Name, courseGroupPrefix) {
+			continue
+		}
+		course, role := strings.TrimPrefix(group.Name, courseGroupPrefix), crownlabsv1alpha1.RoleStudent
+		if strings.HasSuffix(course, adminGroupSuffix) {
+			course, role = strings.TrimSuffix(course, adminGroupSuffix), crownlabsv1alpha1.RoleTeacher
+		}
+		members, err := s.Client.GroupMembers(ctx, group.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			records = append(records, Record{
+				TenantSpec: crownlabsv1alpha1.TenantSpec{
+					StudentID: member.Username,
+					Email:     member.Email,
+					FirstName: member.FirstName,
+					LastName:  member.LastName,
+				},
+				Courses: map[string]crownlabsv1alpha1.EnrolmentRole{course: role},
+			})
+		}
+	}
+	return Merge(records), nil
+}
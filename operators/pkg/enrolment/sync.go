@@ -0,0 +1,202 @@
+package enrolment
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// SourceLabel identifies the source the Tenants and the Enrolments have been imported from
+const SourceLabel = "crownlabs.polito.it/enrolment-source"
+
+// Record is a tenant provided by a source, with its enrolments
+type Record struct {
+	crownlabsv1alpha1.TenantSpec
+	// Courses maps the names of the Courses the tenant is enrolled in to its role
+	Courses map[string]crownlabsv1alpha1.EnrolmentRole
+}
+
+// Source provides the tenants and their enrolments, e.g. from CSV files or from an external directory. New sources
+// (e.g. LDAP) can be plugged in by implementing this interface, while tests can rely on a stub.
+type Source interface {
+	// Name identifies the source, whose Tenants and Enrolments are labelled with it
+	Name() string
+	// Records returns the tenants known by the source, with their enrolments
+	Records(ctx context.Context) ([]Record, error)
+}
+
+// Merge merges the records of the same tenant, which may be listed once per course. Teachers listed also as students keep the teacher role.
+func Merge(records []Record) []Record {
+	merged := map[string]*Record{}
+	var names []string
+	for _, record := range records {
+		name := TenantName(record.StudentID)
+		existing, found := merged[name]
+		if !found {
+			record := record
+			record.Courses = map[string]crownlabsv1alpha1.EnrolmentRole{}
+			merged[name], existing = &record, &record
+			names = append(names, name)
+		}
+		for course, role := range record.Courses {
+			if existing.Courses[course] != crownlabsv1alpha1.RoleTeacher {
+				existing.Courses[course] = role
+			}
+		}
+	}
+
+	result := make([]Record, len(names))
+	for i, name := range names {
+		result[i] = *merged[name]
+	}
+	return result
+}
+
+// Result is the outcome of the import of the records of a source
+type Result struct {
+	// Updated are the Tenants and the Enrolments created or updated
+	Updated []string
+	// Deleted are the Tenants and the Enrolments no longer provided by the source
+	Deleted []string
+}
+
+// Apply creates or updates the Tenants and the Enrolments of the records, labelled with the given source. If prune is
+// set, the ones imported from the same source which are no longer provided are deleted; otherwise the import is
+// incremental, as the one of the provisioning script. The quotas of the Tenants are preserved.
+func Apply(ctx context.Context, c client.Client, source string, records []Record, prune bool) (Result, error) {
+	var result Result
+	tenants, enrolments := map[string]bool{}, map[string]bool{}
+	for _, record := range Merge(records) {
+		tenant := &crownlabsv1alpha1.Tenant{ObjectMeta: metav1.ObjectMeta{Name: TenantName(record.StudentID)}}
+		spec := record.TenantSpec
+		op, err := controllerutil.CreateOrUpdate(ctx, c, tenant, func() error {
+			setSource(&tenant.ObjectMeta, source)
+			spec.MaxInstances = tenant.Spec.MaxInstances
+			tenant.Spec = spec
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+		tenants[tenant.Name] = true
+		if op != controllerutil.OperationResultNone {
+			result.Updated = append(result.Updated, "Tenant "+tenant.Name)
+		}
+
+		courses := make([]string, 0, len(record.Courses))
+		for course := range record.Courses {
+			courses = append(courses, course)
+		}
+		sort.Strings(courses)
+		for _, course := range courses {
+			enrolment := &crownlabsv1alpha1.Enrolment{ObjectMeta: metav1.ObjectMeta{Name: Name(course, tenant.Name)}}
+			role := record.Courses[course]
+			op, err := controllerutil.CreateOrUpdate(ctx, c, enrolment, func() error {
+				setSource(&enrolment.ObjectMeta, source)
+				enrolment.Spec = crownlabsv1alpha1.EnrolmentSpec{Tenant: tenant.Name, Course: course, Role: role}
+				return nil
+			})
+			if err != nil {
+				return result, err
+			}
+			enrolments[enrolment.Name] = true
+			if op != controllerutil.OperationResultNone {
+				result.Updated = append(result.Updated, "Enrolment "+enrolment.Name)
+			}
+		}
+	}
+	if !prune {
+		return result, nil
+	}
+
+	var existingEnrolments crownlabsv1alpha1.EnrolmentList
+	if err := c.List(ctx, &existingEnrolments, client.MatchingLabels{SourceLabel: source}); err != nil {
+		return result, err
+	}
+	for i := range existingEnrolments.Items {
+		if enrolment := &existingEnrolments.Items[i]; !enrolments[enrolment.Name] {
+			if err := c.Delete(ctx, enrolment); client.IgnoreNotFound(err) != nil {
+				return result, err
+			}
+			result.Deleted = append(result.Deleted, "Enrolment "+enrolment.Name)
+		}
+	}
+	var existingTenants crownlabsv1alpha1.TenantList
+	if err := c.List(ctx, &existingTenants, client.MatchingLabels{SourceLabel: source}); err != nil {
+		return result, err
+	}
+	for i := range existingTenants.Items {
+		if tenant := &existingTenants.Items[i]; !tenants[tenant.Name] {
+			if err := c.Delete(ctx, tenant); client.IgnoreNotFound(err) != nil {
+				return result, err
+			}
+			result.Deleted = append(result.Deleted, "Tenant "+tenant.Name)
+		}
+	}
+	return result, nil
+}
+
+func setSource(meta *metav1.ObjectMeta, source string) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	meta.Labels[SourceLabel] = source
+}
+
+// Syncer periodically imports the Tenants and the Enrolments provided by a source, deleting the ones no longer provided
+type Syncer struct {
+	Client client.Client
+	Source Source
+	Period time.Duration
+	Log    logr.Logger
+}
+
+// NeedLeaderElection guarantees that a single replica of the operator synchronizes the source
+func (s *Syncer) NeedLeaderElection() bool {
+	return true
+}
+
+// Start synchronizes the source until the stop channel is closed
+func (s *Syncer) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		s.sync(ctx)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(s.Period):
+		}
+	}
+}
+
+func (s *Syncer) sync(ctx context.Context) {
+	records, err := s.Source.Records(ctx)
+	if err != nil {
+		s.Log.Error(err, "unable to retrieve the tenants from "+s.Source.Name())
+		return
+	}
+	// the tenants are not pruned when the source provides none of them, which is most likely a misconfiguration
+	result, err := Apply(ctx, s.Client, s.Source.Name(), records, len(records) > 0)
+	for _, updated := range result.Updated {
+		s.Log.Info(updated + " imported from " + s.Source.Name())
+	}
+	for _, deleted := range result.Deleted {
+		s.Log.Info(deleted + " no longer provided by " + s.Source.Name() + ", deleted")
+	}
+	if err != nil {
+		s.Log.Error(err, "unable to import the tenants from "+s.Source.Name())
+	}
+}
@@ -0,0 +1,179 @@
+// Package exposure abstracts the resources exposing the instances outside the cluster, which are either
+// Ingresses or HTTPRoutes of the Gateway API, according to the configuration and to the APIs served by the cluster.
+package exposure
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// Kind identifies the resources exposing the instances
+type Kind string
+
+const (
+	// Auto selects the first kind served by the cluster among Ingress and LegacyIngress
+	Auto Kind = "auto"
+	// Ingress exposes the instances through networking.k8s.io/v1 Ingresses
+	Ingress Kind = "ingress"
+	// LegacyIngress exposes the instances through extensions/v1beta1 Ingresses, which are not served since Kubernetes 1.22
+	LegacyIngress Kind = "ingress-v1beta1"
+	// HTTPRoute exposes the instances through HTTPRoutes of the Gateway API
+	HTTPRoute Kind = "httproute"
+	// Proxy exposes the instances through the reverse proxy run by the operator, which implements the routes
+	// described by ConfigMaps, independently of the ingress controller
+	Proxy Kind = "proxy"
+)
+
+// RewriteAnnotation is the annotation of the nginx ingress controller removing the path of the route from the requests
+const RewriteAnnotation = "nginx.ingress.kubernetes.io/rewrite-target"
+
+// ReservedAnnotation returns whether an annotation cannot be set by the ingress settings: the ones authenticating the
+// requests and removing the path of the routes, which are set by the exposers, the ones of the operator, and the
+// snippets, which inject arbitrary configurations in the ingress controller.
+func ReservedAnnotation(key string) bool {
+	return strings.HasPrefix(key, "nginx.ingress.kubernetes.io/auth-") || key == RewriteAnnotation ||
+		strings.HasSuffix(key, "-snippet") || strings.HasPrefix(key, "crownlabs.polito.it/")
+}
+
+// Route describes how a service is exposed, independently of the resources implementing it
+type Route struct {
+	Name            string
+	Namespace       string
+	Annotations     map[string]string
+	OwnerReferences []metav1.OwnerReference
+
+	// Host and Path identify the URLs of the route, i.e. https://Host/Path/...
+	Host string
+	Path string
+	// StripPrefix removes the Path from the requests forwarded to the service
+	StripPrefix bool
+	ServiceName string
+	ServicePort int32
+	// Auth is the oauth2-proxy authenticating the requests, if required
+	Auth *RouteAuth
+	// BaseHref is the base URL (without host) injected in the head of the HTML pages, so that the relative
+	// links of the applications unaware of the Path are resolved correctly
+	BaseHref string
+
+	// TLSSecretName is the secret containing the certificate of the host. It is ignored by HTTPRoutes, whose
+	// connections are terminated by the Gateway.
+	TLSSecretName string
+	// IngressClass is the class of the Ingresses
+	IngressClass string
+	// Gateway is the Gateway the HTTPRoutes are attached to, identified as namespace/name (or name, if it
+	// belongs to the namespace of the route)
+	Gateway string
+}
+
+// RouteAuth identifies the oauth2-proxy authenticating the requests of a route
+type RouteAuth struct {
+	ServiceName string
+	ServicePort int32
+	// Path is the prefix of the endpoints of the oauth2-proxy (i.e. Path/auth and Path/start)
+	Path string
+}
+
+// URL returns the URL of the route
+func (r *Route) URL() string {
+	return "https://" + r.Host + strings.TrimSuffix(r.Path, "/")
+}
+
+// Exposer creates the resources implementing the routes
+type Exposer interface {
+	// Kind returns the kind of the resources created by the exposer
+	Kind() Kind
+	// Object returns the resource implementing the route, which can be created with instanceCreation.CreateOrUpdate,
+	// or an error if the features required by the route are not supported by the resources of the exposer
+	Object(route Route) (interface{}, error)
+}
+
+var (
+	ingressGroupVersion       = schema.GroupVersion{Group: "networking.k8s.io", Version: "v1"}
+	legacyIngressGroupVersion = schema.GroupVersion{Group: "extensions", Version: "v1beta1"}
+	// the HTTPRoutes are served as v1 since Gateway API 1.0, and as v1beta1 by the previous releases
+	httpRouteGroupVersions = []schema.GroupVersion{
+		{Group: "gateway.networking.k8s.io", Version: "v1"},
+		{Group: "gateway.networking.k8s.io", Version: "v1beta1"},
+	}
+)
+
+// New returns the exposer of the given kind, verifying that its resources are served by the cluster. If kind
+// is Auto, the first kind served by the cluster is selected, in order: Ingress and LegacyIngress, while the
+// HTTPRoute (which cannot authenticate the requests) and the Proxy must be selected explicitly.
+func New(kind Kind, client discovery.DiscoveryInterface) (Exposer, error) {
+	if kind == Proxy {
+		// the routes of the proxy are described by ConfigMaps, which are always served
+		return proxyExposer{}, nil
+	}
+
+	groups, err := client.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover the APIs served by the cluster: %v", err)
+	}
+	served := func(gv schema.GroupVersion, resource string) (bool, error) {
+		if !groupVersionServed(groups, gv) {
+			return false, nil
+		}
+		resources, err := client.ServerResourcesForGroupVersion(gv.String())
+		if err != nil {
+			return false, fmt.Errorf("unable to discover the resources of %v: %v", gv, err)
+		}
+		for _, r := range resources.APIResources {
+			if r.Name == resource {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	candidates := []Kind{kind}
+	if kind == Auto {
+		candidates = []Kind{Ingress, LegacyIngress}
+	}
+	for _, candidate := range candidates {
+		var exposer Exposer
+		var ok bool
+		switch candidate {
+		case Ingress:
+			exposer = ingressExposer{}
+			ok, err = served(ingressGroupVersion, "ingresses")
+		case LegacyIngress:
+			exposer = legacyIngressExposer{}
+			ok, err = served(legacyIngressGroupVersion, "ingresses")
+		case HTTPRoute:
+			for _, gv := range httpRouteGroupVersions {
+				if ok, err = served(gv, "httproutes"); ok || err != nil {
+					exposer = httpRouteExposer{groupVersion: gv}
+					break
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown exposure kind %q", candidate)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return exposer, nil
+		}
+	}
+	return nil, fmt.Errorf("the resources required by the %q exposure are not served by the cluster", kind)
+}
+
+func groupVersionServed(groups *metav1.APIGroupList, gv schema.GroupVersion) bool {
+	for _, group := range groups.Groups {
+		if group.Name != gv.Group {
+			continue
+		}
+		for _, version := range group.Versions {
+			if version.Version == gv.Version {
+				return true
+			}
+		}
+	}
+	return false
+}
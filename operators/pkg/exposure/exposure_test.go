@@ -0,0 +1,146 @@
+package exposure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var route = Route{
+	Name:          "vm-ingress",
+	Namespace:     "ns",
+	Annotations:   map[string]string{"crownlabs.polito.it/probe-url": "https://crownlabs.polito.it/uuid"},
+	Host:          "crownlabs.polito.it",
+	Path:          "/uuid",
+	StripPrefix:   true,
+	ServiceName:   "vm-svc",
+	ServicePort:   6080,
+	Auth:          &RouteAuth{ServiceName: "oauth2-svc", ServicePort: 4180, Path: "/uuid/oauth2"},
+	BaseHref:      "/uuid/index.html",
+	TLSSecretName: "crownlabs-labinstances-secret",
+	IngressClass:  "nginx",
+	Gateway:       "gateways/crownlabs",
+}
+
+func fakeDiscovery(resources ...*metav1.APIResourceList) *fakediscovery.FakeDiscovery {
+	return &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: resources}}
+}
+
+func resourceList(groupVersion string, resources ...string) *metav1.APIResourceList {
+	list := &metav1.APIResourceList{GroupVersion: groupVersion}
+	for _, resource := range resources {
+		list.APIResources = append(list.APIResources, metav1.APIResource{Name: resource})
+	}
+	return list
+}
+
+func TestNew(t *testing.T) {
+	legacy := fakeDiscovery(resourceList("networking.k8s.io/v1", "networkpolicies"), resourceList("extensions/v1beta1", "ingresses"))
+	current := fakeDiscovery(resourceList("networking.k8s.io/v1", "networkpolicies", "ingresses"), resourceList("gateway.networking.k8s.io/v1beta1", "httproutes"))
+
+	exposer, err := New(Auto, legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, exposer.Kind(), LegacyIngress, "The legacy Ingresses should be selected when the v1 ones are not served.")
+
+	exposer, err = New(Auto, current)
+	assert.NoError(t, err)
+	assert.Equal(t, exposer.Kind(), Ingress)
+	_, err = New(Auto, fakeDiscovery(resourceList("gateway.networking.k8s.io/v1", "httproutes")))
+	assert.Error(t, err, "The HTTPRoutes should be selected only explicitly.")
+
+	exposer, err = New(HTTPRoute, current)
+	assert.NoError(t, err)
+	assert.Equal(t, exposer.(httpRouteExposer).groupVersion.Version, "v1beta1")
+
+	_, err = New(HTTPRoute, legacy)
+	assert.Error(t, err, "The HTTPRoutes are not served.")
+	_, err = New(Kind("service"), current)
+	assert.Error(t, err)
+
+	exposer, err = New(Proxy, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, exposer.Kind(), Proxy, "The proxy should not require any API.")
+}
+
+func TestIngress(t *testing.T) {
+	overriding := route
+	overriding.Annotations = map[string]string{"crownlabs.polito.it/probe-url": "https://crownlabs.polito.it/uuid", RewriteAnnotation: "/"}
+	object, err := ingressExposer{}.Object(overriding)
+	assert.NoError(t, err)
+	ingress := object.(networkingv1.Ingress)
+	assert.Equal(t, ingress.Name, route.Name)
+	assert.Equal(t, ingress.Annotations[RewriteAnnotation], "/$2", "The annotations of the exposer should not be overridden.")
+	assert.Equal(t, ingress.Annotations["crownlabs.polito.it/probe-url"], "https://crownlabs.polito.it/uuid")
+	assert.Equal(t, ingress.Annotations["nginx.ingress.kubernetes.io/auth-url"], "https://$host/uuid/oauth2/auth")
+	assert.Equal(t, ingress.Annotations["nginx.ingress.kubernetes.io/configuration-snippet"],
+		`sub_filter '<head>' '<head> <base href="https://$host/uuid/index.html">';`)
+	assert.Equal(t, ingress.Spec.TLS[0].SecretName, route.TLSSecretName)
+	assert.Equal(t, *ingress.Spec.IngressClassName, "nginx")
+	path := ingress.Spec.Rules[0].HTTP.Paths[0]
+	assert.Equal(t, path.Path, "/uuid(/|$)(.*)")
+	assert.Equal(t, path.Backend.Service.Port.Number, int32(6080))
+
+	oauth2 := route
+	oauth2.StripPrefix, oauth2.Auth, oauth2.BaseHref = false, nil, ""
+	object, err = legacyIngressExposer{}.Object(oauth2)
+	assert.NoError(t, err)
+	legacy := object.(v1beta1.Ingress)
+	assert.Equal(t, legacy.Spec.Rules[0].HTTP.Paths[0].Path, "/uuid")
+	assert.Equal(t, legacy.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort.IntVal, int32(6080))
+	assert.NotContains(t, legacy.Annotations, RewriteAnnotation)
+}
+
+func TestHTTPRoute(t *testing.T) {
+	exposer := httpRouteExposer{groupVersion: httpRouteGroupVersions[0]}
+	_, err := exposer.Object(route)
+	assert.Error(t, err, "The authenticated routes should not be exposed without authentication.")
+	subdomain := route
+	subdomain.Auth = nil
+	_, err = exposer.Object(subdomain)
+	assert.Error(t, err, "The base URL cannot be injected by the HTTPRoutes.")
+
+	subdomain.BaseHref = ""
+	object, err := exposer.Object(subdomain)
+	assert.NoError(t, err)
+	httpRoute := object.(unstructured.Unstructured)
+	assert.Equal(t, httpRoute.GetAPIVersion(), "gateway.networking.k8s.io/v1")
+	assert.Equal(t, httpRoute.GetKind(), "HTTPRoute")
+	assert.Equal(t, httpRoute.GetAnnotations(), route.Annotations)
+
+	hostnames, _, _ := unstructured.NestedStringSlice(httpRoute.Object, "spec", "hostnames")
+	assert.Equal(t, hostnames, []string{"crownlabs.polito.it"})
+	parents, _, _ := unstructured.NestedSlice(httpRoute.Object, "spec", "parentRefs")
+	assert.Equal(t, parents, []interface{}{map[string]interface{}{"namespace": "gateways", "name": "crownlabs"}})
+
+	rules, _, _ := unstructured.NestedSlice(httpRoute.Object, "spec", "rules")
+	rule := rules[0].(map[string]interface{})
+	prefix, _, _ := unstructured.NestedString(rule["matches"].([]interface{})[0].(map[string]interface{}), "path", "value")
+	assert.Equal(t, prefix, "/uuid")
+	assert.Len(t, rule["filters"], 1, "The prefix should be removed by a rewrite.")
+	// the objects must be JSON compatible to be sent to the API server
+	assert.NotPanics(t, func() { httpRoute.DeepCopy() })
+}
+
+func TestProxyRoute(t *testing.T) {
+	object, err := proxyExposer{}.Object(route)
+	assert.NoError(t, err)
+	configMap := object.(corev1.ConfigMap)
+	assert.Equal(t, configMap.Labels, map[string]string{ProxyRouteLabel: "true"})
+
+	decoded, err := ProxyRoute(configMap)
+	assert.NoError(t, err)
+	expected := route
+	expected.TLSSecretName, expected.IngressClass, expected.Gateway = "", "", ""
+	assert.Equal(t, decoded, expected, "The route should be preserved by the ConfigMap.")
+
+	delete(configMap.Data, "port")
+	_, err = ProxyRoute(configMap)
+	assert.Error(t, err)
+}
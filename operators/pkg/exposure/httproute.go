@@ -0,0 +1,70 @@
+package exposure
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// httpRouteExposer creates the HTTPRoutes of the given version of the Gateway API
+type httpRouteExposer struct {
+	groupVersion schema.GroupVersion
+}
+
+func (httpRouteExposer) Kind() Kind {
+	return HTTPRoute
+}
+
+// Object returns the HTTPRoute implementing the route. The Gateway API provides no portable filter to authenticate
+// the requests through the oauth2-proxy of the instances or to inject the base URL in the HTML pages, hence the
+// routes requiring them are refused rather than exposed without authentication.
+func (e httpRouteExposer) Object(route Route) (interface{}, error) {
+	if route.Auth != nil {
+		return nil, fmt.Errorf("route %v requires authentication, which is not supported by the HTTPRoutes: use the %q exposure", route.Name, Proxy)
+	}
+	if route.BaseHref != "" {
+		return nil, fmt.Errorf("route %v requires the injection of the base URL, which is not supported by the HTTPRoutes: use the subdomain mode or the %q exposure", route.Name, Proxy)
+	}
+
+	rule := map[string]interface{}{
+		"matches": []interface{}{
+			map[string]interface{}{
+				"path": map[string]interface{}{"type": "PathPrefix", "value": route.Path},
+			},
+		},
+		"backendRefs": []interface{}{
+			map[string]interface{}{"name": route.ServiceName, "port": int64(route.ServicePort)},
+		},
+	}
+	if route.StripPrefix {
+		rule["filters"] = []interface{}{
+			map[string]interface{}{
+				"type": "URLRewrite",
+				"urlRewrite": map[string]interface{}{
+					"path": map[string]interface{}{"type": "ReplacePrefixMatch", "replacePrefixMatch": "/"},
+				},
+			},
+		}
+	}
+	spec := map[string]interface{}{
+		"hostnames": []interface{}{route.Host},
+		"rules":     []interface{}{rule},
+	}
+	if route.Gateway != "" {
+		parent := map[string]interface{}{"name": route.Gateway}
+		if parts := strings.SplitN(route.Gateway, "/", 2); len(parts) == 2 {
+			parent = map[string]interface{}{"namespace": parts[0], "name": parts[1]}
+		}
+		spec["parentRefs"] = []interface{}{parent}
+	}
+
+	httpRoute := unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	httpRoute.SetGroupVersionKind(e.groupVersion.WithKind("HTTPRoute"))
+	httpRoute.SetName(route.Name)
+	httpRoute.SetNamespace(route.Namespace)
+	httpRoute.SetAnnotations(route.Annotations)
+	httpRoute.SetOwnerReferences(route.OwnerReferences)
+	return httpRoute, nil
+}
@@ -0,0 +1,134 @@
+package exposure
+
+import (
+	"k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ingressExposer creates networking.k8s.io/v1 Ingresses
+type ingressExposer struct{}
+
+func (ingressExposer) Kind() Kind {
+	return Ingress
+}
+
+func (ingressExposer) Object(route Route) (interface{}, error) {
+	pathType := networkingv1.PathTypeImplementationSpecific
+	ingress := networkingv1.Ingress{
+		ObjectMeta: ingressMeta(route),
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{
+				{
+					Hosts:      []string{route.Host},
+					SecretName: route.TLSSecretName,
+				},
+			},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: route.Host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     ingressPath(route),
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: route.ServiceName,
+											Port: networkingv1.ServiceBackendPort{Number: route.ServicePort},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if route.IngressClass != "" {
+		ingress.Spec.IngressClassName = &route.IngressClass
+	}
+	return ingress, nil
+}
+
+// legacyIngressExposer creates extensions/v1beta1 Ingresses
+type legacyIngressExposer struct{}
+
+func (legacyIngressExposer) Kind() Kind {
+	return LegacyIngress
+}
+
+func (legacyIngressExposer) Object(route Route) (interface{}, error) {
+	ingress := v1beta1.Ingress{
+		ObjectMeta: ingressMeta(route),
+		Spec: v1beta1.IngressSpec{
+			TLS: []v1beta1.IngressTLS{
+				{
+					Hosts:      []string{route.Host},
+					SecretName: route.TLSSecretName,
+				},
+			},
+			Rules: []v1beta1.IngressRule{
+				{
+					Host: route.Host,
+					IngressRuleValue: v1beta1.IngressRuleValue{
+						HTTP: &v1beta1.HTTPIngressRuleValue{
+							Paths: []v1beta1.HTTPIngressPath{
+								{
+									Path: ingressPath(route),
+									Backend: v1beta1.IngressBackend{
+										ServiceName: route.ServiceName,
+										ServicePort: intstr.FromInt(int(route.ServicePort)),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if route.IngressClass != "" {
+		ingress.Spec.IngressClassName = &route.IngressClass
+	}
+	return ingress, nil
+}
+
+// ingressMeta returns the metadata of the Ingress implementing a route. The prefix of the route is removed,
+// the requests are authenticated and the base URL is injected through the annotations of the nginx ingress
+// controller, which cannot be overridden by the ones of the route.
+func ingressMeta(route Route) metav1.ObjectMeta {
+	annotations := map[string]string{}
+	for key, value := range route.Annotations {
+		annotations[key] = value
+	}
+	if route.StripPrefix {
+		annotations[RewriteAnnotation] = "/$2"
+	}
+	if route.Auth != nil {
+		annotations["nginx.ingress.kubernetes.io/auth-signin"] = "https://$host" + route.Auth.Path + "/start?rd=$escaped_request_uri"
+		annotations["nginx.ingress.kubernetes.io/auth-url"] = "https://$host" + route.Auth.Path + "/auth"
+	}
+	if route.BaseHref != "" {
+		annotations["nginx.ingress.kubernetes.io/configuration-snippet"] = `sub_filter '<head>' '<head> <base href="https://$host` + route.BaseHref + `">';`
+	}
+
+	return metav1.ObjectMeta{
+		Name:            route.Name,
+		Namespace:       route.Namespace,
+		Annotations:     annotations,
+		OwnerReferences: route.OwnerReferences,
+	}
+}
+
+// ingressPath returns the path of the Ingress implementing a route. When the prefix is stripped, it is the
+// regular expression whose second group is the path forwarded to the service.
+func ingressPath(route Route) string {
+	if route.StripPrefix {
+		return route.Path + "(/|$)(.*)"
+	}
+	return route.Path
+}
@@ -0,0 +1,81 @@
+package exposure
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProxyRouteLabel identifies the ConfigMaps describing the routes of the proxy
+const ProxyRouteLabel = "crownlabs.polito.it/proxy-route"
+
+// proxyExposer creates the ConfigMaps describing the routes implemented by the proxy of the operator
+type proxyExposer struct{}
+
+func (proxyExposer) Kind() Kind {
+	return Proxy
+}
+
+func (proxyExposer) Object(route Route) (interface{}, error) {
+	data := map[string]string{
+		"host":        route.Host,
+		"path":        route.Path,
+		"stripPrefix": strconv.FormatBool(route.StripPrefix),
+		"service":     route.ServiceName,
+		"port":        strconv.Itoa(int(route.ServicePort)),
+	}
+	if route.Auth != nil {
+		data["authService"] = route.Auth.ServiceName
+		data["authPort"] = strconv.Itoa(int(route.Auth.ServicePort))
+		data["authPath"] = route.Auth.Path
+	}
+	if route.BaseHref != "" {
+		data["baseHref"] = route.BaseHref
+	}
+
+	return corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            route.Name,
+			Namespace:       route.Namespace,
+			Labels:          map[string]string{ProxyRouteLabel: "true"},
+			Annotations:     route.Annotations,
+			OwnerReferences: route.OwnerReferences,
+		},
+		Data: data,
+	}, nil
+}
+
+// ProxyRoute returns the route described by a ConfigMap created by the proxy exposer
+func ProxyRoute(configMap corev1.ConfigMap) (Route, error) {
+	data := configMap.Data
+	route := Route{
+		Name:        configMap.Name,
+		Namespace:   configMap.Namespace,
+		Annotations: configMap.Annotations,
+		Host:        data["host"],
+		Path:        data["path"],
+		StripPrefix: data["stripPrefix"] == "true",
+		ServiceName: data["service"],
+		BaseHref:    data["baseHref"],
+	}
+	if route.Path == "" || route.ServiceName == "" {
+		return Route{}, fmt.Errorf("invalid route %v/%v: the path and the service are required", configMap.Namespace, configMap.Name)
+	}
+
+	port, err := strconv.ParseInt(data["port"], 10, 32)
+	if err != nil {
+		return Route{}, fmt.Errorf("invalid port of route %v/%v: %v", configMap.Namespace, configMap.Name, err)
+	}
+	route.ServicePort = int32(port)
+
+	if data["authService"] != "" {
+		port, err := strconv.ParseInt(data["authPort"], 10, 32)
+		if err != nil {
+			return Route{}, fmt.Errorf("invalid auth port of route %v/%v: %v", configMap.Namespace, configMap.Name, err)
+		}
+		route.Auth = &RouteAuth{ServiceName: data["authService"], ServicePort: int32(port), Path: data["authPath"]}
+	}
+	return route, nil
+}
@@ -0,0 +1,201 @@
+package instanceCreation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CollectorUser is the VM account used to collect the work of the students
+const CollectorUser = "crownlabs-collector"
+
+// CollectorKeyName is the key of the secret containing the private key of the collector
+const CollectorKeyName = "id_ecdsa"
+
+const (
+	// CollectorPodLabel identifies the pods of the jobs reaching the VMs through the collector account
+	CollectorPodLabel = "crownlabs.polito.it/collector"
+	// CollectorNamespaceLabel identifies the namespaces the jobs reaching the VMs run in. Its value is the name of
+	// the namespace itself, and it is set only by the operator, since tenants are not allowed to label namespaces.
+	CollectorNamespaceLabel = "crownlabs.polito.it/collector-namespace"
+)
+
+// CollectorAccount is the account of the VM used by the jobs of the operator, i.e. the collection of the work
+// of the students and the provisioning of the pooled VMIs
+type CollectorAccount struct {
+	// AuthorizedKey is the public key authorized for the account, in the authorized_keys format
+	AuthorizedKey string
+	// Sudo is the sudo rule of the account, which grants only the commands run by the jobs
+	Sudo string
+	// CollectionPath is the path archived by the collection jobs, from which the provisioning script of the pooled
+	// VMIs builds the same rule
+	CollectionPath string
+}
+
+// CollectorSudo returns the sudo rule allowing only to archive the collection path, as done by the collection jobs
+func CollectorSudo(collectionPath string) string {
+	// the characters with a special meaning in the sudoers files are escaped
+	escaped := strings.NewReplacer(`\`, `\\`, ",", `\,`, ":", `\:`, "=", `\=`, "(", `\(`, ")", `\)`, "!", `\!`, " ", `\ `).Replace(collectionPath)
+	command := "tar -C " + escaped + " -czf - ."
+	return "ALL=(root) NOPASSWD: /bin/" + command + ", /usr/bin/" + command
+}
+
+// CollectorPeer returns the NetworkPolicy peer matching the pods of the jobs reaching the VMs from the given namespace
+func CollectorPeer(namespace string) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{CollectorNamespaceLabel: namespace}},
+		PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{CollectorPodLabel: "true"}},
+	}
+}
+
+// LabelCollectorNamespace labels the namespace the jobs reaching the VMs run in, so that they are matched by CollectorPeer
+func LabelCollectorNamespace(c client.Client, ctx context.Context, namespace string) error {
+	var ns corev1.Namespace
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return err
+	}
+	if ns.Labels[CollectorNamespaceLabel] == namespace {
+		return nil
+	}
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	ns.Labels[CollectorNamespaceLabel] = namespace
+	return c.Update(ctx, &ns)
+}
+
+// GenerateCollectorKey generates the key pair used to collect the work of the students. It returns
+// the PEM encoded private key and the public key in the authorized_keys format.
+func GenerateCollectorKey() ([]byte, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return privateKey, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))), nil
+}
+
+func CreateCollectorKeySecret(name string, namespace string, privateKey []byte) corev1.Secret {
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-collector-key",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{CollectorKeyName: privateKey},
+		Type: corev1.SecretTypeOpaque,
+	}
+
+	return secret
+}
+
+// CollectionDestination returns the directory of the course storage where the work of the student is collected. The
+// StudentID is set by the creator of the LabInstance, hence it is used only if it identifies the owner of the
+// namespace, and the elements of the directory are rejected if they could escape the directory of the student.
+func CollectionDestination(studentID string, namespace string, instanceName string) (string, error) {
+	student := namespace
+	if studentID != "" && TenantName(studentID) == namespace {
+		student = studentID
+	}
+	for _, element := range []string{student, instanceName} {
+		if element == "" || strings.Contains(element, "/") || strings.Contains(element, "..") {
+			return "", fmt.Errorf("invalid collection directory %q", element)
+		}
+	}
+	return path.Join(student, instanceName), nil
+}
+
+// CreateCollectionJob creates the job copying the content of the collection path of the VM reachable at host
+// into the destination directory of the course storage. The job expects the private key of the collector
+// to be stored in the secret returned by CreateCollectorKeySecret(job.Name, ...).
+func CreateCollectionJob(name string, namespace string, image string, host string,
+	collection crownlabsv1alpha1.CollectionSpec, destination string, labels map[string]string) batchv1.Job {
+
+	jobName := fmt.Sprintf("%v-collect-%.4s", name, uuid.New().String())
+	target := path.Join("/collect", destination)
+	command := fmt.Sprintf("mkdir -p %v && ssh -i /keys/%v -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null %v@%v %v | tar -C %v -xzf -",
+		shellQuote(target), CollectorKeyName, CollectorUser, host,
+		shellQuote("sudo tar -C "+shellQuote(collection.Path)+" -czf - ."), shellQuote(target))
+
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: pointer.Int32Ptr(3),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{CollectorPodLabel: "true"},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "collector",
+							Image:   image,
+							Command: []string{"/bin/sh", "-c", command},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "keys", MountPath: "/keys", ReadOnly: true},
+								{Name: "collect", MountPath: "/collect"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "keys",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName:  jobName + "-collector-key",
+									DefaultMode: pointer.Int32Ptr(0400),
+								},
+							},
+						},
+						{
+							Name: "collect",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: collection.ClaimName,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return job
+}
+
+// shellQuote quotes a string so that it is interpreted literally by the shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -3,18 +3,25 @@ package instanceCreation
 import (
 	"context"
 	"encoding/base64"
+	"strings"
+
 	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/exposure"
 
 	"github.com/go-logr/logr"
 	"github.com/google/uuid"
 	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
@@ -22,18 +29,22 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func CreateVirtualMachineInstance(name string, namespace string, template crownlabsv1alpha1.LabTemplate, instanceName string, secretName string) virtv1.VirtualMachineInstance {
-	vm := template.Spec.Vm
-	vm.Name = name + "-vmi"
-	vm.Namespace = namespace
-	vm.Labels = map[string]string{"name": name, "template-name": template.Name, "instance-name": instanceName}
+// CreateVirtualMachineInstance creates the VMI corresponding to one of the VMs of the template. The name is the
+// prefix shared by all the resources of the LabInstance, and it is extended with the name of the VM, if any.
+func CreateVirtualMachineInstance(name string, namespace string, template crownlabsv1alpha1.LabTemplate, vm crownlabsv1alpha1.NamedVm, instanceName string, secretName string) virtv1.VirtualMachineInstance {
+	vmName := VmResourceName(name, vm)
+	vmi := *vm.Vm.DeepCopy()
+	vmi.Name = vmName + "-vmi"
+	vmi.Namespace = namespace
+	vmi.Labels = map[string]string{"name": vmName, "template-name": template.Name, "instance-name": instanceName, "instance-resources": name}
 
-	for _, volume := range vm.Spec.Volumes {
+	for _, volume := range vmi.Spec.Volumes {
 		if volume.Name == "cloudinitdisk" {
 			volume.CloudInitNoCloud.UserDataSecretRef = &corev1.LocalObjectReference{Name: secretName}
 		}
 	}
-	return vm
+	attachNetworks(&vmi, name, vm.Networks)
+	return vmi
 }
 
 type writeFile struct {
@@ -41,35 +52,59 @@ type writeFile struct {
 	Path        string `yaml:"path"`
 	Permissions string `yaml:"permissions"`
 }
+type cloudInitUser struct {
+	Name              string   `yaml:"name"`
+	Sudo              string   `yaml:"sudo"`
+	Shell             string   `yaml:"shell"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
+}
 type cloudInitConfig struct {
 	Network struct {
 		Version int         `yaml:"version"`
 		ID0     interface{} `yaml:"id0"`
 		Dhcp4   bool        `yaml:"dhcp4"`
 	} `yaml:"network"`
-	Mounts     [][]string  `yaml:"mounts"`
-	WriteFiles []writeFile `yaml:"write_files"`
+	Mounts     [][]string    `yaml:"mounts"`
+	WriteFiles []writeFile   `yaml:"write_files"`
+	Users      []interface{} `yaml:"users,omitempty"`
 }
 
-func createUserdata(nextUsername string, nextPassword string, nextCloudBaseUrl string) map[string]string {
+// WebdavCredentials are the credentials used to mount the Nextcloud drive of a student
+type WebdavCredentials struct {
+	Username string
+	Password string
+}
+
+func createUserdata(drive *WebdavCredentials, nextCloudBaseUrl string, collector *CollectorAccount) map[string]string {
 	var Userdata cloudInitConfig
 
 	Userdata.Network.Version = 2
 	Userdata.Network.Dhcp4 = true
-	Userdata.Mounts = [][]string{{
-		nextCloudBaseUrl + "/remote.php/dav/files/" + nextUsername,
-		"/media/MyDrive",
-		"davfs",
-		"_netdev,auto,user,rw,uid=1000,gid=1000",
-		"0",
-		"0"},
-	// New mounts should be added here as []string
+	if drive != nil {
+		Userdata.Mounts = [][]string{{
+			nextCloudBaseUrl + "/remote.php/dav/files/" + drive.Username,
+			"/media/MyDrive",
+			"davfs",
+			"_netdev,auto,user,rw,uid=1000,gid=1000",
+			"0",
+			"0"},
+		// New mounts should be added here as []string
+		}
+		Userdata.WriteFiles = []writeFile{{
+			Content:     "/media/MyDrive " + drive.Username + " " + drive.Password,
+			Path:        "/etc/davfs2/secrets",
+			Permissions: "0600"},
+		// New write_files should be added here as []writeFile
+		}
 	}
-	Userdata.WriteFiles = []writeFile{{
-		Content:     "/media/MyDrive " + nextUsername + " " + nextPassword,
-		Path:        "/etc/davfs2/secrets",
-		Permissions: "0600"},
-	// New write_files should be added here as []writeFile
+	if collector != nil {
+		// the default user is kept, and a further one is added to collect the work of the student
+		Userdata.Users = []interface{}{"default", cloudInitUser{
+			Name:              CollectorUser,
+			Sudo:              collector.Sudo,
+			Shell:             "/bin/sh",
+			SSHAuthorizedKeys: []string{collector.AuthorizedKey},
+		}}
 	}
 
 	out, _ := yaml.Marshal(Userdata)
@@ -79,7 +114,10 @@ func createUserdata(nextUsername string, nextPassword string, nextCloudBaseUrl s
 	return map[string]string{"userdata": headerComment + string(out)}
 }
 
-func CreateSecret(name string, namespace string, nextUsername string, nextPassword string, nextCloudBaseUrl string) corev1.Secret {
+// CreateSecret creates the secret containing the cloud-init configuration of the VM.
+// The Nextcloud drive is mounted only if drive is not nil (i.e. not during exams and in
+// the instances of the teams), and the collector account is created only if collector is not nil.
+func CreateSecret(name string, namespace string, drive *WebdavCredentials, nextCloudBaseUrl string, collector *CollectorAccount) corev1.Secret {
 	secret := corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name + "-secret",
@@ -87,9 +125,9 @@ func CreateSecret(name string, namespace string, nextUsername string, nextPasswo
 		},
 		Data: nil,
 		StringData: createUserdata(
-			nextUsername,
-			nextPassword,
-			nextCloudBaseUrl),
+			drive,
+			nextCloudBaseUrl,
+			collector),
 		Type: corev1.SecretTypeOpaque,
 	}
 
@@ -147,57 +185,45 @@ func CreatePersistentVolumeClaim(name string, namespace string, storageClassName
 	return pvc
 }
 
-func CreateIngress(name string, namespace string, svc corev1.Service, urlUUID string, websiteBaseUrl string) v1beta1.Ingress {
-	url := websiteBaseUrl + "/" + urlUUID
-
-	ingress := v1beta1.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name + "-ingress",
-			Namespace: namespace,
-			Labels:    nil,
-			Annotations: map[string]string{
-				"nginx.ingress.kubernetes.io/rewrite-target":        "/$2",
-				"nginx.ingress.kubernetes.io/proxy-read-timeout":    "3600",
-				"nginx.ingress.kubernetes.io/proxy-send-timeout":    "3600",
-				"nginx.ingress.kubernetes.io/auth-signin":           "https://$host/" + urlUUID + "/oauth2/start?rd=$escaped_request_uri",
-				"nginx.ingress.kubernetes.io/auth-url":              "https://$host/" + urlUUID + "/oauth2/auth",
-				"crownlabs.polito.it/probe-url":                     "https://" + url,
-				"nginx.ingress.kubernetes.io/configuration-snippet": `sub_filter '<head>' '<head> <base href="https://$host/` + urlUUID + `/index.html">';`,
-			},
-		},
-		Spec: v1beta1.IngressSpec{
-			Backend: nil,
-			TLS: []v1beta1.IngressTLS{
-				{
-					Hosts:      []string{websiteBaseUrl},
-					SecretName: "crownlabs-labinstances-secret",
-				},
-			},
-			Rules: []v1beta1.IngressRule{
-				{
-					Host: websiteBaseUrl,
-					IngressRuleValue: v1beta1.IngressRuleValue{
-						HTTP: &v1beta1.HTTPIngressRuleValue{
-							Paths: []v1beta1.HTTPIngressPath{
-								{
-									Path: "/" + urlUUID + "(/|$)(.*)",
-									Backend: v1beta1.IngressBackend{
-										ServiceName: svc.Name,
-										ServicePort: svc.Spec.Ports[0].TargetPort,
-									},
-								},
-							},
-						},
-					},
-				},
-			},
+// CreateRoute creates the route exposing a VM, whose requests are authenticated by the given oauth2-proxy. In
+// the path mode, the VM is exposed at host/urlUUID/vmPath, where vmPath identifies the VM in multi-VM laboratories
+// (and it is empty otherwise), while in the subdomain mode it is exposed at the root of its own host.
+func CreateRoute(name string, namespace string, svc corev1.Service, urlUUID string, vm crownlabsv1alpha1.NamedVm, auth *exposure.RouteAuth, settings crownlabsv1alpha1.IngressSettings) exposure.Route {
+	route := exposure.Route{
+		Name:      name + "-ingress",
+		Namespace: namespace,
+		Annotations: map[string]string{
+			"nginx.ingress.kubernetes.io/proxy-read-timeout": "3600",
+			"nginx.ingress.kubernetes.io/proxy-send-timeout": "3600",
 		},
+		Host:        VmHost(urlUUID, vm, settings),
+		Path:        "/",
+		ServiceName: svc.Name,
+		ServicePort: svc.Spec.Ports[0].Port,
+		Auth:        auth,
 	}
+	if settings.Mode != crownlabsv1alpha1.IngressSubdomainMode {
+		// the applications are unaware of the path, hence it is removed and the base URL is injected in their pages
+		route.Path = "/" + urlUUID + VmUrlPath(vm)
+		route.StripPrefix = true
+		route.BaseHref = route.Path + "/index.html"
+	}
+	route.Annotations["crownlabs.polito.it/probe-url"] = route.URL()
+
+	applyIngressSettings(&route, settings)
+	return route
+}
 
-	return ingress
+// Oauth2RouteAuth returns the oauth2-proxy of an instance, which authenticates the requests to its VMs
+func Oauth2RouteAuth(name string, urlUUID string, settings crownlabsv1alpha1.IngressSettings) *exposure.RouteAuth {
+	return &exposure.RouteAuth{
+		ServiceName: name + "-oauth2-svc",
+		ServicePort: 4180,
+		Path:        Oauth2Prefix(urlUUID, settings),
+	}
 }
 
-func CreateOauth2Deployment(name, namespace, urlUUID, image, clientSecret, providerUrl string) appsv1.Deployment {
+func CreateOauth2Deployment(name, namespace, urlUUID, image, clientID, clientSecret, providerUrl string) appsv1.Deployment {
 
 	cookieUUID := uuid.New().String()
 	id, _ := uuid.New().MarshalBinary()
@@ -231,7 +257,7 @@ func CreateOauth2Deployment(name, namespace, urlUUID, image, clientSecret, provi
 								"--cookie-expire=24h",
 								"--cookie-name=_oauth2_cookie_" + string([]rune(cookieUUID)[:6]),
 								"--provider=keycloak",
-								"--client-id=k8s",
+								"--client-id=" + clientID,
 								"--client-secret=" + clientSecret,
 								"--login-url=" + providerUrl + "/protocol/openid-connect/auth",
 								"--redeem-url=" + providerUrl + "/protocol/openid-connect/token",
@@ -292,50 +318,223 @@ func CreateOauth2Service(name string, namespace string) corev1.Service {
 	return service
 }
 
-func CreateOauth2Ingress(name string, namespace string, svc corev1.Service, urlUUID string, websiteBaseUrl string) v1beta1.Ingress {
+// CreateOauth2Routes creates the routes exposing the oauth2-proxy of an instance at host/urlUUID/oauth2 in the
+// path mode, and at the /oauth2 path of the host of each VM in the subdomain mode.
+func CreateOauth2Routes(name string, namespace string, svc corev1.Service, urlUUID string, vms []crownlabsv1alpha1.NamedVm, settings crownlabsv1alpha1.IngressSettings) []exposure.Route {
+	prefix := Oauth2Prefix(urlUUID, settings)
+	if settings.Mode != crownlabsv1alpha1.IngressSubdomainMode {
+		return []exposure.Route{createOauth2Route(name, namespace, svc, settings.Host, prefix, settings)}
+	}
 
-	ingress := v1beta1.Ingress{
-		TypeMeta: metav1.TypeMeta{},
+	var routes []exposure.Route
+	for _, vm := range vms {
+		routes = append(routes, createOauth2Route(VmResourceName(name, vm), namespace, svc, VmHost(urlUUID, vm, settings), prefix, settings))
+	}
+	return routes
+}
+
+func createOauth2Route(name string, namespace string, svc corev1.Service, host string, prefix string, settings crownlabsv1alpha1.IngressSettings) exposure.Route {
+
+	route := exposure.Route{
+		Name:      name + "-oauth2-ingress",
+		Namespace: namespace,
+		Annotations: map[string]string{
+			"nginx.ingress.kubernetes.io/cors-allow-credentials": "true",
+			"nginx.ingress.kubernetes.io/cors-allow-headers":     "DNT,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Authorization",
+			"nginx.ingress.kubernetes.io/cors-allow-methods":     "PUT, GET, POST, OPTIONS, DELETE, PATCH",
+			"nginx.ingress.kubernetes.io/cors-allow-origin":      "https://*",
+			"nginx.ingress.kubernetes.io/enable-cors":            "true",
+		},
+		Host:        host,
+		Path:        prefix,
+		ServiceName: svc.Name,
+		ServicePort: svc.Spec.Ports[0].Port,
+	}
+
+	applyIngressSettings(&route, settings)
+	return route
+}
+
+// CreateNetworkPolicy creates the NetworkPolicy isolating the VM from the other instances. The ingress traffic
+// is accepted only from the trusted namespaces (e.g. ingress controller and operator), the oauth2-proxy, the
+// other VMs of the same LabInstance and the collector jobs running in collectorNamespace (i.e. the one of the
+// LabTemplate), while the egress traffic is filtered according to the given policy.
+func CreateNetworkPolicy(name string, namespace string, vm crownlabsv1alpha1.NamedVm, egress crownlabsv1alpha1.EgressPolicy, collectorNamespace string) networkingv1.NetworkPolicy {
+	vmName := VmResourceName(name, vm)
+
+	netpol := networkingv1.NetworkPolicy{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name + "-oauth2-ingress",
+			Name:      vmName + "-netpol",
 			Namespace: namespace,
-			Annotations: map[string]string{
-				"nginx.ingress.kubernetes.io/cors-allow-credentials": "true",
-				"nginx.ingress.kubernetes.io/cors-allow-headers":     "DNT,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Authorization",
-				"nginx.ingress.kubernetes.io/cors-allow-methods":     "PUT, GET, POST, OPTIONS, DELETE, PATCH",
-				"nginx.ingress.kubernetes.io/cors-allow-origin":      "https://*",
-				"nginx.ingress.kubernetes.io/enable-cors":            "true",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"name": vmName},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"access-vm": "allowed"},
+							},
+						},
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"app": name},
+							},
+						},
+						{
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"instance-resources": name},
+							},
+						},
+						CollectorPeer(collectorNamespace),
+					},
+				},
 			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
 		},
-		Spec: v1beta1.IngressSpec{
-			TLS: []v1beta1.IngressTLS{
+	}
+
+	switch egress {
+	case crownlabsv1alpha1.EgressCluster:
+		netpol.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{
+			{
+				To: []networkingv1.NetworkPolicyPeer{
+					{
+						NamespaceSelector: &metav1.LabelSelector{},
+					},
+				},
+			},
+		}
+		netpol.Spec.PolicyTypes = append(netpol.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+	case crownlabsv1alpha1.EgressNone:
+		netpol.Spec.PolicyTypes = append(netpol.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+	}
+
+	return netpol
+}
+
+// ExamEgressLabel identifies the namespaces (e.g. hosting the services required by the exams) which can be reached by
+// the instances of the exams, and it can be set only by the cluster administrators
+const ExamEgressLabel = "crownlabs.polito.it/exam-egress"
+
+// RestrictExamEgress restricts the egress traffic of the NetworkPolicy returned by CreateNetworkPolicy(name, ...)
+// for the instances of an exam: they can reach only the DNS service of the cluster and the namespaces labelled with
+// ExamEgressLabel=allowed, rather than any pod in the cluster (e.g. the instances of the other students).
+func RestrictExamEgress(netpol *networkingv1.NetworkPolicy) {
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dnsPort := intstr.FromInt(53)
+	netpol.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{
+		{
+			To: []networkingv1.NetworkPolicyPeer{
 				{
-					Hosts:      []string{websiteBaseUrl},
-					SecretName: "crownlabs-labinstances-secret",
+					NamespaceSelector: &metav1.LabelSelector{},
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+					},
 				},
 			},
-			Rules: []v1beta1.IngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &dnsPort}, {Protocol: &tcp, Port: &dnsPort}},
+		},
+		{
+			To: []networkingv1.NetworkPolicyPeer{
 				{
-					Host: websiteBaseUrl,
-					IngressRuleValue: v1beta1.IngressRuleValue{
-						HTTP: &v1beta1.HTTPIngressRuleValue{
-							Paths: []v1beta1.HTTPIngressPath{
-								{
-									Path: "/" + urlUUID + "/oauth2/.*",
-									Backend: v1beta1.IngressBackend{
-										ServiceName: svc.Name,
-										ServicePort: svc.Spec.Ports[0].TargetPort,
-									},
-								},
-							},
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{ExamEgressLabel: "allowed"},
+					},
+				},
+			},
+		},
+	}
+	netpol.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}
+}
+
+// SealNetworkPolicy restricts the NetworkPolicy returned by CreateNetworkPolicy(name, ...) once the exam is ended:
+// the ingress traffic is accepted only from the oauth2-proxy (restricted to the teachers) and from the collector
+// jobs, hence the VM is no longer reachable from the other pods (e.g. through ssh), while the egress traffic is
+// dropped. It returns false in case the NetworkPolicy was already restricted.
+func SealNetworkPolicy(netpol *networkingv1.NetworkPolicy, name string, collectorNamespace string) bool {
+	spec := networkingv1.NetworkPolicySpec{
+		PodSelector: netpol.Spec.PodSelector,
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			{
+				From: []networkingv1.NetworkPolicyPeer{
+					{
+						PodSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": name},
 						},
 					},
+					CollectorPeer(collectorNamespace),
 				},
 			},
 		},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+	}
+	if equality.Semantic.DeepEqual(netpol.Spec, spec) {
+		return false
+	}
+	netpol.Spec = spec
+	return true
+}
+
+// RestrictOauth2Access configures the oauth2-proxy deployment to grant the access only to the
+// members of the given group. It returns false in case the deployment was already restricted.
+func RestrictOauth2Access(deploy *appsv1.Deployment, group string) bool {
+	return SetOauth2Groups(deploy, []string{group})
+}
+
+// SetOauth2Groups configures the oauth2-proxy deployment to grant the access only to the members
+// of the given groups, replacing the ones previously allowed. It returns false in case the
+// deployment was already configured.
+func SetOauth2Groups(deploy *appsv1.Deployment, groups []string) bool {
+	var groupArgs []string
+	for _, group := range groups {
+		groupArgs = append(groupArgs, "--keycloak-group=/"+strings.TrimPrefix(group, "/"))
+	}
+
+	changed := false
+	containers := deploy.Spec.Template.Spec.Containers
+	for i := range containers {
+		var args, current []string
+		for _, a := range containers[i].Args {
+			if strings.HasPrefix(a, "--keycloak-group=") {
+				current = append(current, a)
+			} else {
+				args = append(args, a)
+			}
+		}
+		if strings.Join(current, " ") != strings.Join(groupArgs, " ") {
+			containers[i].Args = append(args, groupArgs...)
+			changed = true
+		}
 	}
+	return changed
+}
 
-	return ingress
+// SetOauth2Subdomains configures the oauth2-proxy of an instance exposed in the subdomain mode, whose endpoints
+// are served at the root of the host of each VM, limiting the session cookies to these hosts.
+func SetOauth2Subdomains(deploy *appsv1.Deployment, hosts []string) {
+	containers := deploy.Spec.Template.Spec.Containers
+	for i := range containers {
+		var args []string
+		for _, a := range containers[i].Args {
+			switch {
+			case strings.HasPrefix(a, "--proxy-prefix="):
+				args = append(args, "--proxy-prefix=/oauth2")
+			case strings.HasPrefix(a, "--cookie-path="):
+				args = append(args, "--cookie-path=/")
+			case strings.HasPrefix(a, "--cookie-domain="), strings.HasPrefix(a, "--whitelist-domain="):
+			default:
+				args = append(args, a)
+			}
+		}
+		for _, host := range hosts {
+			args = append(args, "--cookie-domain="+host, "--whitelist-domain="+host)
+		}
+		containers[i].Args = args
+	}
 }
 
 // create a resource or update it if already exists
@@ -383,6 +582,19 @@ func CreateOrUpdate(c client.Client, ctx context.Context, log logr.Logger, objec
 				return err
 			}
 		}
+	case corev1.Endpoints:
+		var ep corev1.Endpoints
+		err := c.Get(ctx, types.NamespacedName{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+		}, &ep)
+		if err != nil {
+			err = c.Create(ctx, &obj, &client.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create endpoints "+obj.Name)
+				return err
+			}
+		}
 	case v1beta1.Ingress:
 		var ing v1beta1.Ingress
 		err := c.Get(ctx, types.NamespacedName{
@@ -396,6 +608,32 @@ func CreateOrUpdate(c client.Client, ctx context.Context, log logr.Logger, objec
 				return err
 			}
 		}
+	case networkingv1.Ingress:
+		var ing networkingv1.Ingress
+		err := c.Get(ctx, types.NamespacedName{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+		}, &ing)
+		if err != nil {
+			err = c.Create(ctx, &obj, &client.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create ingress "+obj.Name)
+				return err
+			}
+		}
+	case corev1.ConfigMap:
+		var cm corev1.ConfigMap
+		err := c.Get(ctx, types.NamespacedName{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+		}, &cm)
+		if err != nil {
+			err = c.Create(ctx, &obj, &client.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create configmap "+obj.Name)
+				return err
+			}
+		}
 	case appsv1.Deployment:
 		var deploy appsv1.Deployment
 		err := c.Get(ctx, types.NamespacedName{
@@ -415,6 +653,59 @@ func CreateOrUpdate(c client.Client, ctx context.Context, log logr.Logger, objec
 				return err
 			}
 		}
+	case batchv1.Job:
+		var job batchv1.Job
+		err := c.Get(ctx, types.NamespacedName{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+		}, &job)
+		if err != nil {
+			err = c.Create(ctx, &obj, &client.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create job "+obj.Name)
+				return err
+			}
+		}
+	case networkingv1.NetworkPolicy:
+		var netpol networkingv1.NetworkPolicy
+		err := c.Get(ctx, types.NamespacedName{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+		}, &netpol)
+		if err != nil {
+			err = c.Create(ctx, &obj, &client.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create network policy "+obj.Name)
+				return err
+			}
+		}
+	case unstructured.Unstructured:
+		var u unstructured.Unstructured
+		u.SetGroupVersionKind(obj.GroupVersionKind())
+		err := c.Get(ctx, types.NamespacedName{
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+		}, &u)
+		if err != nil {
+			err = c.Create(ctx, &obj, &client.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create "+obj.GetKind()+" "+obj.GetName())
+				return err
+			}
+		}
+	case crownlabsv1alpha1.LabInstance:
+		var labi crownlabsv1alpha1.LabInstance
+		err := c.Get(ctx, types.NamespacedName{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+		}, &labi)
+		if err != nil {
+			err = c.Create(ctx, &obj, &client.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				log.Error(err, "unable to create labinstance "+obj.Name)
+				return err
+			}
+		}
 	case virtv1.VirtualMachineInstance:
 		var vmi virtv1.VirtualMachineInstance
 		err := c.Get(ctx, types.NamespacedName{
@@ -0,0 +1,114 @@
+package instanceCreation
+
+import (
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/exposure"
+)
+
+const (
+	// DefaultTLSSecretName is the secret containing the certificate shared by the instances
+	DefaultTLSSecretName = "crownlabs-labinstances-secret"
+
+	// The annotations of the namespaces specifying the ingress settings of their LabTemplates
+	IngressHostAnnotation          = "crownlabs.polito.it/ingress-host"
+	IngressTLSSecretAnnotation     = "crownlabs.polito.it/ingress-tls-secret"
+	IngressClusterIssuerAnnotation = "crownlabs.polito.it/ingress-cluster-issuer"
+	IngressClassAnnotation         = "crownlabs.polito.it/ingress-class"
+	IngressGatewayAnnotation       = "crownlabs.polito.it/ingress-gateway"
+	IngressModeAnnotation          = "crownlabs.polito.it/ingress-mode"
+
+	clusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+)
+
+// NamespaceIngressSettings returns the ingress settings specified by the annotations of a namespace
+func NamespaceIngressSettings(annotations map[string]string) crownlabsv1alpha1.IngressSettings {
+	return crownlabsv1alpha1.IngressSettings{
+		Host:          annotations[IngressHostAnnotation],
+		Mode:          crownlabsv1alpha1.IngressMode(annotations[IngressModeAnnotation]),
+		TLSSecretName: annotations[IngressTLSSecretAnnotation],
+		ClusterIssuer: annotations[IngressClusterIssuerAnnotation],
+		IngressClass:  annotations[IngressClassAnnotation],
+		Gateway:       annotations[IngressGatewayAnnotation],
+	}
+}
+
+// MergeIngressSettings merges the given ingress settings, in decreasing order of precedence: each field is
+// taken from the first settings specifying it, while the annotations are merged.
+func MergeIngressSettings(settings ...crownlabsv1alpha1.IngressSettings) crownlabsv1alpha1.IngressSettings {
+	var merged crownlabsv1alpha1.IngressSettings
+	str := func(current *string, value string) {
+		if *current == "" {
+			*current = value
+		}
+	}
+
+	for _, s := range settings {
+		str(&merged.Host, s.Host)
+		str((*string)(&merged.Mode), string(s.Mode))
+		str(&merged.TLSSecretName, s.TLSSecretName)
+		str(&merged.ClusterIssuer, s.ClusterIssuer)
+		str(&merged.IngressClass, s.IngressClass)
+		str(&merged.Gateway, s.Gateway)
+		for key, value := range s.Annotations {
+			if _, ok := merged.Annotations[key]; !ok {
+				if merged.Annotations == nil {
+					merged.Annotations = map[string]string{}
+				}
+				merged.Annotations[key] = value
+			}
+		}
+	}
+	return merged
+}
+
+// IngressTLSSecretName returns the secret containing the certificate of the host of the given settings
+func IngressTLSSecretName(settings crownlabsv1alpha1.IngressSettings) string {
+	switch {
+	case settings.TLSSecretName != "":
+		return settings.TLSSecretName
+	case settings.ClusterIssuer != "":
+		return strings.ReplaceAll(settings.Host, ".", "-") + "-tls"
+	default:
+		return DefaultTLSSecretName
+	}
+}
+
+// VmHost returns the host a VM is exposed at: the one of the settings in the path mode, and the subdomain
+// identified by urlUUID (followed by the name of the VM in multi-VM laboratories) in the subdomain mode.
+func VmHost(urlUUID string, vm crownlabsv1alpha1.NamedVm, settings crownlabsv1alpha1.IngressSettings) string {
+	if settings.Mode != crownlabsv1alpha1.IngressSubdomainMode {
+		return settings.Host
+	}
+	if vm.Name == "" {
+		return urlUUID + "." + settings.Host
+	}
+	return urlUUID + "-" + vm.Name + "." + settings.Host
+}
+
+// Oauth2Prefix returns the path the endpoints of the oauth2-proxy of an instance are served at
+func Oauth2Prefix(urlUUID string, settings crownlabsv1alpha1.IngressSettings) string {
+	if settings.Mode == crownlabsv1alpha1.IngressSubdomainMode {
+		return "/oauth2"
+	}
+	return "/" + urlUUID + "/oauth2"
+}
+
+// applyIngressSettings configures the TLS certificate, the class and the Gateway of a route. In the subdomain
+// mode, the certificate of the issuer is not requested, since a wildcard certificate is required. The annotations
+// of the settings are added, except the reserved ones, which could expose the route without authentication.
+func applyIngressSettings(route *exposure.Route, settings crownlabsv1alpha1.IngressSettings) {
+	route.TLSSecretName = IngressTLSSecretName(settings)
+	route.IngressClass = settings.IngressClass
+	route.Gateway = settings.Gateway
+
+	if settings.ClusterIssuer != "" && settings.Mode != crownlabsv1alpha1.IngressSubdomainMode {
+		route.Annotations[clusterIssuerAnnotation] = settings.ClusterIssuer
+	}
+	for key, value := range settings.Annotations {
+		if !exposure.ReservedAnnotation(key) {
+			route.Annotations[key] = value
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package instanceCreation
+
+import (
+	"fmt"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	"github.com/google/uuid"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+const (
+	// PoolLabel identifies the VMIs of the warm pool of a LabTemplate
+	PoolLabel = "crownlabs.polito.it/pool"
+	// PoolInstanceNameLabel and PoolInstanceNamespaceLabel identify the LabInstance a pooled VMI was handed over to
+	PoolInstanceNameLabel      = "crownlabs.polito.it/pool-instance-name"
+	PoolInstanceNamespaceLabel = "crownlabs.polito.it/pool-instance-namespace"
+	// PoolRevisionLabel identifies the revision of the LabTemplate a pooled VMI has been created from
+	PoolRevisionLabel = "crownlabs.polito.it/pool-revision"
+	// ProvisioningCommand is the script configuring the pooled VMIs once handed over, which is shipped in the VM
+	// images (see the crownlabs role of the ansible playbooks) and reads the directives of ProvisioningInput.
+	ProvisioningCommand = "/usr/local/sbin/crownlabs-provision"
+	// ProvisioningSudo is the sudo rule of the collector account of the pooled VMIs, allowing the provisioning
+	// jobs to run only the provisioning script. It is replaced at handover, together with the key authorized for the account.
+	ProvisioningSudo = "ALL=(root) NOPASSWD: " + ProvisioningCommand
+)
+
+// PoolEnabled returns whether the instances of the LabTemplate are served by a warm pool
+func PoolEnabled(template crownlabsv1alpha1.LabTemplate) bool {
+	return template.Spec.WarmPool != nil && template.Spec.WarmPool.Size > 0 &&
+		len(template.Spec.Vms) == 0 && template.Spec.Exam == nil
+}
+
+// PoolName returns the name prefix of the VMIs and of the resources shared by the warm pool of a LabTemplate
+func PoolName(template crownlabsv1alpha1.LabTemplate) string {
+	return template.Name + "-pool"
+}
+
+// CreatePoolVirtualMachineInstance creates an unassigned VMI of the warm pool of a LabTemplate, in its namespace.
+// The VMI is configured by the cloud-init secret returned by CreateSecret(PooledVmiName(vmi), ...), which authorizes
+// for the collector account a key of the VMI only, stored in the secret returned by
+// CreateCollectorKeySecret(PooledVmiName(vmi), ...) and used to provision the VMI once handed over.
+func CreatePoolVirtualMachineInstance(template crownlabsv1alpha1.LabTemplate) virtv1.VirtualMachineInstance {
+	name := fmt.Sprintf("%v-%.4s", PoolName(template), uuid.New().String())
+	vm := crownlabsv1alpha1.NamedVm{Vm: template.Spec.Vm, VmType: template.Spec.VmType}
+
+	vmi := CreateVirtualMachineInstance(name, template.Namespace, template, vm, "", name+"-secret")
+	vmi.Labels = map[string]string{"name": name, "template-name": template.Name, PoolLabel: template.Name}
+	return vmi
+}
+
+// PooledVmiName returns the name prefix of the resources of a pooled VMI, i.e. its cloud-init and key secrets
+func PooledVmiName(vmi virtv1.VirtualMachineInstance) string {
+	return vmi.Labels["name"]
+}
+
+// PooledVmiIP returns the IP of a pooled VMI, or an empty string if the VMI is not running yet
+func PooledVmiIP(vmi virtv1.VirtualMachineInstance) string {
+	if vmi.Status.Phase != virtv1.Running || len(vmi.Status.Interfaces) == 0 {
+		return ""
+	}
+	return vmi.Status.Interfaces[0].IP
+}
+
+// CreatePoolNetworkPolicy creates the NetworkPolicy isolating the VMIs of the warm pool, according to the egress policy of the LabTemplate
+func CreatePoolNetworkPolicy(template crownlabsv1alpha1.LabTemplate) networkingv1.NetworkPolicy {
+	netpol := CreateNetworkPolicy(PoolName(template), template.Namespace, crownlabsv1alpha1.NamedVm{}, template.Spec.EgressPolicy, template.Namespace)
+	netpol.Spec.PodSelector = metav1.LabelSelector{
+		MatchLabels: map[string]string{PoolLabel: template.Name},
+	}
+	return netpol
+}
+
+// CreatePooledVmEndpoints creates the endpoints of the service returned by CreateService(name, ...), whose selector
+// has been removed, so that it reaches the given pooled VMI (which belongs to a different namespace).
+func CreatePooledVmEndpoints(name string, namespace string, ip string) corev1.Endpoints {
+	endpoints := corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-svc",
+			Namespace: namespace,
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{{IP: ip}},
+				Ports: []corev1.EndpointPort{
+					{Name: "vnc", Port: 6080, Protocol: corev1.ProtocolTCP},
+					{Name: "ssh", Port: 22, Protocol: corev1.ProtocolTCP},
+				},
+			},
+		},
+	}
+
+	return endpoints
+}
+
+// ProvisioningInput returns the directives of the provisioning script configuring a pooled VMI handed over to a
+// LabInstance, in place of cloud-init, one per line in the "<name> <value>" form. The script replaces the key and the
+// sudo rule of the collector account with the ones of the LabInstance (or revokes them, if collector is nil), so that
+// the key of the pooled VMI can no longer be used, and mounts the Nextcloud drive, if any. Since the script builds
+// the sudo rule from the collection path, the key of the pooled VMI grants no more than the collection itself.
+func ProvisioningInput(drive *WebdavCredentials, nextCloudBaseUrl string, collector *CollectorAccount) (string, error) {
+	var lines []string
+	if collector != nil {
+		lines = append(lines, "collector-key "+collector.AuthorizedKey, "collection-path "+collector.CollectionPath)
+	}
+	if drive != nil {
+		lines = append(lines, "drive-url "+nextCloudBaseUrl+"/remote.php/dav/files/"+drive.Username,
+			"drive-user "+drive.Username, "drive-password "+drive.Password)
+	}
+	var input strings.Builder
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			return "", fmt.Errorf("the provisioning directive %v contains a newline", strings.SplitN(line, " ", 2)[0])
+		}
+		input.WriteString(line + "\n")
+	}
+	return input.String(), nil
+}
+
+// CreateProvisioningJob creates the job running the provisioning script in the pooled VMI reachable at host.
+// The job expects the input of the script in the secret returned by CreateProvisioningInputSecret(job.Name, ...),
+// and the key of the pooled VMI in the one returned by CreateCollectorKeySecret(PooledVmiName(vmi), ...).
+func CreateProvisioningJob(name string, namespace string, image string, host string, keySecretName string, labels map[string]string) batchv1.Job {
+	jobName := fmt.Sprintf("%v-provision-%.4s", name, uuid.New().String())
+	command := fmt.Sprintf("ssh -i /keys/%v -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null %v@%v 'sudo %v' < /provision/input",
+		CollectorKeyName, CollectorUser, host, ProvisioningCommand)
+
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: pointer.Int32Ptr(3),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{CollectorPodLabel: "true"},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "provisioner",
+							Image:   image,
+							Command: []string{"/bin/sh", "-c", command},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "keys", MountPath: "/keys", ReadOnly: true},
+								{Name: "provision", MountPath: "/provision", ReadOnly: true},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "keys",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName:  keySecretName,
+									DefaultMode: pointer.Int32Ptr(0400),
+								},
+							},
+						},
+						{
+							Name: "provision",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: jobName + "-input",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return job
+}
+
+// CreateProvisioningInputSecret creates the secret containing the input of the provisioning script of a pooled VMI
+func CreateProvisioningInputSecret(name string, namespace string, input string) corev1.Secret {
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-input",
+			Namespace: namespace,
+		},
+		StringData: map[string]string{"input": input},
+		Type:       corev1.SecretTypeOpaque,
+	}
+
+	return secret
+}
@@ -0,0 +1,30 @@
+package instanceCreation
+
+import (
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// RunningAnnotation stops the VMs of a LabInstance when set to "false", and starts them again when removed
+// or set to "true". The other resources of the instance (e.g. its URL) are preserved while it is stopped.
+const RunningAnnotation = "crownlabs.polito.it/running"
+
+// IsStopped returns whether the VMs of the LabInstance are requested to be stopped
+func IsStopped(labInstance crownlabsv1alpha1.LabInstance) bool {
+	return labInstance.Annotations[RunningAnnotation] == "false"
+}
+
+// SetRunning requests to start or to stop the VMs of the LabInstance. It returns false if the request is already in place.
+func SetRunning(labInstance *crownlabsv1alpha1.LabInstance, running bool) bool {
+	if IsStopped(*labInstance) != running {
+		return false
+	}
+	if labInstance.Annotations == nil {
+		labInstance.Annotations = map[string]string{}
+	}
+	if running {
+		delete(labInstance.Annotations, RunningAnnotation)
+	} else {
+		labInstance.Annotations[RunningAnnotation] = "false"
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package instanceCreation
+
+import (
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// InstancePriority returns the priority tier of a LabInstance: the one of its LabTemplate, which defaults to the
+// exam tier for exams and to the student tier otherwise, raised to the teacher tier if the LabInstance belongs to
+// a teacher of the course, or the one it specifies, if lower.
+func InstancePriority(labInstance crownlabsv1alpha1.LabInstance, template crownlabsv1alpha1.LabTemplate, teacher bool) crownlabsv1alpha1.PriorityTier {
+	tier := TemplatePriority(template)
+	if teacher && PriorityRank(crownlabsv1alpha1.PriorityTeacher) > PriorityRank(tier) {
+		tier = crownlabsv1alpha1.PriorityTeacher
+	}
+	// the LabInstances cannot raise their own priority, since they are created by the students
+	if labInstance.Spec.Priority != "" && PriorityRank(labInstance.Spec.Priority) < PriorityRank(tier) {
+		return labInstance.Spec.Priority
+	}
+	return tier
+}
+
+// TemplatePriority returns the priority tier of the instances of a LabTemplate
+func TemplatePriority(template crownlabsv1alpha1.LabTemplate) crownlabsv1alpha1.PriorityTier {
+	switch {
+	case template.Spec.Priority != "":
+		return template.Spec.Priority
+	case template.Spec.Exam != nil:
+		return crownlabsv1alpha1.PriorityExam
+	default:
+		return crownlabsv1alpha1.PriorityStudent
+	}
+}
+
+// PriorityRank returns the rank of a priority tier, the higher the more important
+func PriorityRank(tier crownlabsv1alpha1.PriorityTier) int {
+	switch tier {
+	case crownlabsv1alpha1.PriorityExam:
+		return 2
+	case crownlabsv1alpha1.PriorityTeacher:
+		return 1
+	default:
+		return 0
+	}
+}
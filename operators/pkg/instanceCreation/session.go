@@ -0,0 +1,45 @@
+package instanceCreation
+
+import (
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// SessionNameLabel and SessionNamespaceLabel identify the LabSession a LabInstance was created for
+	SessionNameLabel      = "crownlabs.polito.it/session-name"
+	SessionNamespaceLabel = "crownlabs.polito.it/session-namespace"
+)
+
+// SessionLabels returns the labels identifying the LabInstances created for the given LabSession
+func SessionLabels(session crownlabsv1alpha1.LabSession) map[string]string {
+	return map[string]string{
+		SessionNameLabel:      session.Name,
+		SessionNamespaceLabel: session.Namespace,
+	}
+}
+
+// CreateSessionLabInstance creates the LabInstance of a student attending a LabSession, in the tenant namespace of the student.
+// LabInstances cannot be owned by the LabSession, since they belong to a different namespace: they are tracked through their labels.
+func CreateSessionLabInstance(session crownlabsv1alpha1.LabSession, studentID string) crownlabsv1alpha1.LabInstance {
+	templateNamespace := session.Spec.LabTemplateNamespace
+	if templateNamespace == "" {
+		templateNamespace = session.Namespace
+	}
+
+	labInstance := crownlabsv1alpha1.LabInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      session.Namespace + "-" + session.Name,
+			Namespace: TenantName(studentID),
+			Labels:    SessionLabels(session),
+		},
+		Spec: crownlabsv1alpha1.LabInstanceSpec{
+			LabTemplateName:      session.Spec.LabTemplateName,
+			LabTemplateNamespace: templateNamespace,
+			StudentID:            studentID,
+		},
+	}
+
+	return labInstance
+}
@@ -0,0 +1,69 @@
+package instanceCreation
+
+import (
+	"fmt"
+	"strings"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+)
+
+// MemberLabelPrefix is the prefix of the labels identifying the students a LabInstance is accounted to
+const MemberLabelPrefix = "member.crownlabs.polito.it/"
+
+// TenantName returns the name of the namespace (and of the Keycloak group) of the given student
+func TenantName(studentID string) string {
+	return strings.ReplaceAll("tenant-"+strings.ToLower(studentID), ".", "-")
+}
+
+// ResolveTeam returns the team owning the LabInstance, with the members declared by the teachers in the LabTemplate,
+// which are never taken from the LabInstance itself. It returns an error if the team is not declared by the
+// LabTemplate, or if the owner of the namespace of the LabInstance is not a member of it.
+func ResolveTeam(labInstance crownlabsv1alpha1.LabInstance, template crownlabsv1alpha1.LabTemplate) (*crownlabsv1alpha1.TeamStatus, error) {
+	if labInstance.Spec.Team == nil {
+		return nil, nil
+	}
+	name := labInstance.Spec.Team.Name
+	for _, team := range template.Spec.Teams {
+		if team.Name != name {
+			continue
+		}
+		for _, studentID := range team.StudentIDs {
+			if TenantName(studentID) == labInstance.Namespace {
+				return &crownlabsv1alpha1.TeamStatus{Name: team.Name, Members: append([]string(nil), team.StudentIDs...), Group: team.Group}, nil
+			}
+		}
+		return nil, fmt.Errorf("the owner of namespace %v is not a member of team %v", labInstance.Namespace, name)
+	}
+	return nil, fmt.Errorf("team %v is not declared by LabTemplate %v", name, template.Name)
+}
+
+// InstanceTenants returns the tenants a LabInstance is accounted to, i.e. the members of its team (as resolved
+// by the operator) or, in case of individual instances, the owner of the namespace the instance belongs to.
+func InstanceTenants(labInstance crownlabsv1alpha1.LabInstance) []string {
+	return TeamTenants(labInstance.Status.Team, labInstance.Namespace)
+}
+
+// TeamTenants returns the tenants of the members of the given team, or the given namespace if team is nil
+func TeamTenants(team *crownlabsv1alpha1.TeamStatus, namespace string) []string {
+	if team != nil && len(team.Members) > 0 {
+		tenants := make([]string, len(team.Members))
+		for i, studentID := range team.Members {
+			tenants[i] = TenantName(studentID)
+		}
+		return tenants
+	}
+	return []string{namespace}
+}
+
+// TeamGroups returns the Keycloak groups granted access to the LabInstance of a team:
+// the group of the team, if any, or the ones of all its members.
+func TeamGroups(team crownlabsv1alpha1.TeamStatus) []string {
+	if team.Group != "" {
+		return []string{team.Group}
+	}
+	groups := make([]string, len(team.Members))
+	for i, studentID := range team.Members {
+		groups[i] = TenantName(studentID)
+	}
+	return groups
+}
@@ -0,0 +1,102 @@
+package instanceCreation
+
+import (
+	"crypto/sha1"
+	"fmt"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+// TemplateVms returns the VMs described by a LabTemplate. Single-VM templates are converted
+// into a list containing a single unnamed VM.
+func TemplateVms(template crownlabsv1alpha1.LabTemplate) []crownlabsv1alpha1.NamedVm {
+	if len(template.Spec.Vms) > 0 {
+		return template.Spec.Vms
+	}
+	return []crownlabsv1alpha1.NamedVm{{
+		Vm:     template.Spec.Vm,
+		VmType: template.Spec.VmType,
+	}}
+}
+
+// VmResourceName returns the name prefix of the resources (e.g. VMI, Service, Ingress) dedicated to a VM,
+// given the one shared by all the resources of the LabInstance.
+func VmResourceName(name string, vm crownlabsv1alpha1.NamedVm) string {
+	if vm.Name == "" {
+		return name
+	}
+	return name + "-" + vm.Name
+}
+
+// VmUrlPath returns the path appended to the URL of the LabInstance to reach the given VM.
+func VmUrlPath(vm crownlabsv1alpha1.NamedVm) string {
+	if vm.Name == "" {
+		return ""
+	}
+	return "/" + vm.Name
+}
+
+// CreateNetworkAttachmentDefinition creates the Multus network implementing a private network of the LabInstance.
+// The network is a linux bridge local to the node, hence the VMs attached to it must be scheduled on the same node.
+func CreateNetworkAttachmentDefinition(name string, namespace string, network crownlabsv1alpha1.LabNetwork) unstructured.Unstructured {
+	nadName := name + "-" + network.Name
+	// the name of linux bridges is limited to 15 characters
+	hash := sha1.Sum([]byte(namespace + "/" + nadName))
+	config := fmt.Sprintf(`{"cniVersion":"0.3.1","name":"%v","type":"bridge","bridge":"cl%x","ipam":{}}`, nadName, hash[:6])
+
+	nad := unstructured.Unstructured{}
+	nad.SetAPIVersion("k8s.cni.cncf.io/v1")
+	nad.SetKind("NetworkAttachmentDefinition")
+	nad.SetName(nadName)
+	nad.SetNamespace(namespace)
+	nad.Object["spec"] = map[string]interface{}{"config": config}
+
+	return nad
+}
+
+// attachNetworks connects the VMI to the given private networks of the LabInstance, preserving the pod network.
+func attachNetworks(vmi *virtv1.VirtualMachineInstance, name string, networks []string) {
+	if len(networks) == 0 {
+		return
+	}
+
+	// the pod network is implicitly added only if no network is specified
+	if len(vmi.Spec.Networks) == 0 {
+		vmi.Spec.Networks = []virtv1.Network{*virtv1.DefaultPodNetwork()}
+		vmi.Spec.Domain.Devices.Interfaces = []virtv1.Interface{*virtv1.DefaultBridgeNetworkInterface()}
+	}
+
+	for _, network := range networks {
+		vmi.Spec.Networks = append(vmi.Spec.Networks, virtv1.Network{
+			Name: network,
+			NetworkSource: virtv1.NetworkSource{
+				Multus: &virtv1.MultusNetwork{NetworkName: name + "-" + network},
+			},
+		})
+		vmi.Spec.Domain.Devices.Interfaces = append(vmi.Spec.Domain.Devices.Interfaces, virtv1.Interface{
+			Name: network,
+			InterfaceBindingMethod: virtv1.InterfaceBindingMethod{
+				Bridge: &virtv1.InterfaceBridge{},
+			},
+		})
+	}
+
+	// all the VMs of the LabInstance are scheduled on the same node of the first one
+	vmi.Spec.Affinity = &corev1.Affinity{
+		PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+				{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"instance-resources": name},
+					},
+					TopologyKey: corev1.LabelHostname,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,304 @@
+// Package keycloak implements a client of the admin REST API of Keycloak, used to read and provision the users
+// and the groups of the realm of CrownLabs.
+package keycloak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pageSize is the number of items requested for each page of the lists
+const pageSize = 100
+
+// User is a user of the realm
+type User struct {
+	ID         string              `json:"id,omitempty"`
+	Username   string              `json:"username"`
+	Email      string              `json:"email,omitempty"`
+	FirstName  string              `json:"firstName,omitempty"`
+	LastName   string              `json:"lastName,omitempty"`
+	Enabled    bool                `json:"enabled"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+// Group is a top-level group of the realm
+type Group struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+}
+
+// Role is a role of a client of the realm
+type Role struct {
+	ID         string `json:"id,omitempty"`
+	Name       string `json:"name"`
+	ClientRole bool   `json:"clientRole,omitempty"`
+}
+
+// Client invokes the admin REST API of Keycloak, authenticating either with the credentials of an admin user of the
+// master realm (through the admin-cli client) or, if a client secret is specified, with a service account.
+type Client struct {
+	// BaseURL is the URL of Keycloak (e.g. https://auth.crownlabs.polito.it/auth)
+	BaseURL string
+	// Realm is the realm managed by the client
+	Realm        string
+	Username     string
+	Password     string
+	ClientID     string
+	ClientSecret string
+	HTTP         *http.Client
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// Error is an unexpected response of Keycloak
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("keycloak: status %v: %v", e.StatusCode, e.Message)
+}
+
+// IsNotFound returns whether the error is caused by a missing resource
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// IsConflict returns whether the error is caused by a resource which already exists
+func IsConflict(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusConflict
+}
+
+// Groups returns the top-level groups of the realm
+func (c *Client) Groups(ctx context.Context) ([]Group, error) {
+	var groups []Group
+	for first := 0; ; first += pageSize {
+		var page []Group
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/groups?first=%v&max=%v", first, pageSize), nil, &page); err != nil {
+			return nil, err
+		}
+		groups = append(groups, page...)
+		if len(page) < pageSize {
+			return groups, nil
+		}
+	}
+}
+
+// GroupMembers returns the members of the group with the given ID
+func (c *Client) GroupMembers(ctx context.Context, groupID string) ([]User, error) {
+	var users []User
+	for first := 0; ; first += pageSize {
+		var page []User
+		path := fmt.Sprintf("/groups/%v/members?first=%v&max=%v", url.PathEscape(groupID), first, pageSize)
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		users = append(users, page...)
+		if len(page) < pageSize {
+			return users, nil
+		}
+	}
+}
+
+// Group returns the top-level group with the given name, or nil if it does not exist
+func (c *Client) Group(ctx context.Context, name string) (*Group, error) {
+	var groups []Group
+	if err := c.do(ctx, http.MethodGet, "/groups?search="+url.QueryEscape(name), nil, &groups); err != nil {
+		return nil, err
+	}
+	// the search matches also the groups whose name contains the given one
+	for i := range groups {
+		if groups[i].Name == name {
+			return &groups[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateGroup creates a top-level group with the given name, returning it
+func (c *Client) CreateGroup(ctx context.Context, name string) (*Group, error) {
+	if err := c.do(ctx, http.MethodPost, "/groups", &Group{Name: name}, nil); err != nil && !IsConflict(err) {
+		return nil, err
+	}
+	group, err := c.Group(ctx, name)
+	if err == nil && group == nil {
+		err = fmt.Errorf("keycloak: group %v not found after its creation", name)
+	}
+	return group, err
+}
+
+// AddGroupMember adds the user with the given ID to the group with the given ID
+func (c *Client) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/users/%v/groups/%v", url.PathEscape(userID), url.PathEscape(groupID)), nil, nil)
+}
+
+// RemoveGroupMember removes the user with the given ID from the group with the given ID
+func (c *Client) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/users/%v/groups/%v", url.PathEscape(userID), url.PathEscape(groupID)), nil, nil)
+}
+
+// User returns the user with the given username, or nil if it does not exist
+func (c *Client) User(ctx context.Context, username string) (*User, error) {
+	var users []User
+	if err := c.do(ctx, http.MethodGet, "/users?exact=true&username="+url.QueryEscape(username), nil, &users); err != nil {
+		return nil, err
+	}
+	// the usernames are stored lower-cased, and older versions of Keycloak ignore the exact parameter
+	for i := range users {
+		if strings.EqualFold(users[i].Username, username) {
+			return &users[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ClientUUID returns the internal ID of the client with the given client ID (e.g. k8s)
+func (c *Client) ClientUUID(ctx context.Context, clientID string) (string, error) {
+	var clients []struct {
+		ID       string `json:"id"`
+		ClientID string `json:"clientId"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/clients?clientId="+url.QueryEscape(clientID), nil, &clients); err != nil {
+		return "", err
+	}
+	for _, client := range clients {
+		if client.ClientID == clientID {
+			return client.ID, nil
+		}
+	}
+	return "", &Error{StatusCode: http.StatusNotFound, Message: "client " + clientID + " not found"}
+}
+
+// CreateClientRole creates the role with the given name in the client with the given internal ID, if it does not
+// exist yet, returning it
+func (c *Client) CreateClientRole(ctx context.Context, clientUUID, name string) (*Role, error) {
+	path := "/clients/" + url.PathEscape(clientUUID) + "/roles"
+	if err := c.do(ctx, http.MethodPost, path, &Role{Name: name, ClientRole: true}, nil); err != nil && !IsConflict(err) {
+		return nil, err
+	}
+	var role Role
+	if err := c.do(ctx, http.MethodGet, path+"/"+url.PathEscape(name), nil, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GroupClientRoles returns the roles of the client with the given internal ID mapped to the group with the given ID
+func (c *Client) GroupClientRoles(ctx context.Context, groupID, clientUUID string) ([]Role, error) {
+	var roles []Role
+	path := fmt.Sprintf("/groups/%v/role-mappings/clients/%v", url.PathEscape(groupID), url.PathEscape(clientUUID))
+	return roles, c.do(ctx, http.MethodGet, path, nil, &roles)
+}
+
+// AddGroupClientRoles maps the given roles of the client with the given internal ID to the group with the given ID,
+// so that they are granted to all its members
+func (c *Client) AddGroupClientRoles(ctx context.Context, groupID, clientUUID string, roles []Role) error {
+	path := fmt.Sprintf("/groups/%v/role-mappings/clients/%v", url.PathEscape(groupID), url.PathEscape(clientUUID))
+	return c.do(ctx, http.MethodPost, path, roles, nil)
+}
+
+// do invokes the given path of the admin API of the realm, encoding the body and decoding the response as JSON
+func (c *Client) do(ctx context.Context, method, path string, body, target interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL()+"/admin/realms/"+url.PathEscape(c.Realm)+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, target)
+}
+
+func (c *Client) send(req *http.Request, target interface{}) error {
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	if target == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// accessToken returns a valid access token, requesting a new one when the cached one is about to expire
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" && time.Until(c.expiry) > 10*time.Second {
+		return c.token, nil
+	}
+
+	form := url.Values{}
+	realm := c.Realm
+	if c.ClientSecret != "" {
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	} else {
+		// the admin users belong to the master realm
+		realm = "master"
+		form.Set("grant_type", "password")
+		form.Set("client_id", "admin-cli")
+		form.Set("username", c.Username)
+		form.Set("password", c.Password)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.baseURL()+"/realms/"+url.PathEscape(realm)+"/protocol/openid-connect/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := c.send(req, &token); err != nil {
+		return "", fmt.Errorf("unable to authenticate to keycloak: %v", err)
+	}
+	c.token, c.expiry = token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn)*time.Second)
+	return c.token, nil
+}
+
+func (c *Client) baseURL() string {
+	return strings.TrimSuffix(c.BaseURL, "/")
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,293 @@
+// Package proxy implements the reverse proxy run by the operator to expose the instances, independently of the
+// ingress controller. It removes the prefix of the routes, authenticates the requests through the oauth2-proxy
+// of the instances and injects the base URL in the HTML pages, which are otherwise delegated to the annotations
+// of the nginx ingress controller.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/exposure"
+)
+
+const (
+	// the timeout of the requests to the oauth2-proxy
+	authTimeout = 10 * time.Second
+	// the timeout of the graceful shutdown of the server
+	shutdownTimeout = 5 * time.Second
+	// the index of the ConfigMaps describing the routes, by host and first segment of the path
+	routeField = "proxyRoute"
+)
+
+// Proxy forwards the requests to the services of the instances, according to the routes described by the
+// ConfigMaps created by the proxy exposer
+type Proxy struct {
+	// Addr is the address the proxy listens on
+	Addr string
+	// Resolve returns the address (host:port) of a service
+	Resolve func(namespace, service string, port int32) string
+
+	reader    client.Reader
+	log       logr.Logger
+	transport http.RoundTripper
+	auth      *http.Client
+}
+
+// New returns a proxy listening on the given address, which reads the routes through the given reader
+func New(addr string, reader client.Reader, log logr.Logger) *Proxy {
+	return &Proxy{
+		Addr:      addr,
+		Resolve:   clusterAddress,
+		reader:    reader,
+		log:       log,
+		transport: http.DefaultTransport,
+		auth:      &http.Client{Timeout: authTimeout},
+	}
+}
+
+// IndexRoutes indexes the ConfigMaps describing the routes by host and first segment of the path, so that the
+// routes possibly matching each request are retrieved without listing all of them. It must be called before the
+// cache of the reader of the proxy is started.
+func IndexRoutes(indexer client.FieldIndexer) error {
+	return indexer.IndexField(context.Background(), &corev1.ConfigMap{}, routeField, func(obj runtime.Object) []string {
+		configMap := obj.(*corev1.ConfigMap)
+		if configMap.Labels[exposure.ProxyRouteLabel] != "true" {
+			return nil
+		}
+		return []string{routeKey(configMap.Data["host"], configMap.Data["path"])}
+	})
+}
+
+// routeKey returns the key of the index of the routes, i.e. the host followed by the first segment of the path
+func routeKey(host, path string) string {
+	return host + "/" + strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+// clusterAddress returns the address of a service through the DNS of the cluster
+func clusterAddress(namespace, service string, port int32) string {
+	return net.JoinHostPort(service+"."+namespace+".svc", strconv.Itoa(int(port)))
+}
+
+// NeedLeaderElection allows all the replicas of the operator to serve the requests
+func (p *Proxy) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the requests until the stop channel is closed
+func (p *Proxy) Start(stop <-chan struct{}) error {
+	server := &http.Server{Addr: p.Addr, Handler: p}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, err := p.route(r.Context(), r.Host, r.URL.Path)
+	if err != nil {
+		p.log.Error(err, "unable to retrieve the routes")
+		http.Error(w, "unable to retrieve the routes", http.StatusBadGateway)
+		return
+	}
+	if route == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if route.Auth != nil && !p.authenticate(w, r, route) {
+		return
+	}
+
+	target := p.Resolve(route.Namespace, route.ServiceName, route.ServicePort)
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = target
+			if route.StripPrefix {
+				req.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(req.URL.Path, route.Path), "/")
+				req.URL.RawPath = ""
+			}
+			if route.BaseHref != "" {
+				// the pages must not be compressed to inject the base URL
+				req.Header.Del("Accept-Encoding")
+			}
+			req.Header.Set("X-Forwarded-Host", r.Host)
+			req.Header.Set("X-Forwarded-Prefix", route.Path)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if route.BaseHref == "" {
+				return nil
+			}
+			return injectBaseHref(resp, "https://"+r.Host+route.BaseHref)
+		},
+		Transport:     p.transport,
+		FlushInterval: 100 * time.Millisecond,
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// route returns the route with the longest path matching the request, nil if no route matches. Only the routes of
+// the host whose first segment matches the one of the request, or whose path is the root, are retrieved from the index.
+func (p *Proxy) route(ctx context.Context, host, path string) (*exposure.Route, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var match *exposure.Route
+	keys := []string{routeKey(host, path)}
+	if root := routeKey(host, "/"); root != keys[0] {
+		keys = append(keys, root)
+	}
+	for _, key := range keys {
+
+		var configMaps corev1.ConfigMapList
+		if err := p.reader.List(ctx, &configMaps, client.MatchingLabels{exposure.ProxyRouteLabel: "true"},
+			client.MatchingFields{routeField: key}); err != nil {
+			return nil, err
+		}
+		for i := range configMaps.Items {
+			route, err := exposure.ProxyRoute(configMaps.Items[i])
+			if err != nil {
+				p.log.Error(err, "ignoring invalid route")
+				continue
+			}
+			if route.Host != host {
+				continue
+			}
+			if prefix := strings.TrimSuffix(route.Path, "/"); path != prefix && !strings.HasPrefix(path, prefix+"/") {
+				continue
+			}
+			if match != nil && len(route.Path) <= len(match.Path) {
+				continue
+			}
+			trusted, err := p.trusted(ctx, &configMaps.Items[i], route)
+			if err != nil {
+				return nil, err
+			}
+			if !trusted {
+				p.log.Info("ignoring route not created for a LabInstance", "route", route.Namespace+"/"+route.Name)
+				continue
+			}
+			match = &route
+		}
+	}
+	return match, nil
+}
+
+// trusted returns whether the ConfigMap describing a route has been created for a LabInstance, i.e. whether it is
+// controlled by an existing LabInstance of its namespace and it exposes one of the URLs of the LabInstance, which are
+// reported in its status by the operator. Otherwise, any user allowed to create ConfigMaps could expose arbitrary
+// services, or intercept the requests (and the cookies) directed to the other instances.
+func (p *Proxy) trusted(ctx context.Context, configMap *corev1.ConfigMap, route exposure.Route) (bool, error) {
+	owner := metav1.GetControllerOf(configMap)
+	if owner == nil || owner.APIVersion != crownlabsalpha1.GroupVersion.String() || owner.Kind != "LabInstance" {
+		return false, nil
+	}
+	var labInstance crownlabsalpha1.LabInstance
+	if err := p.reader.Get(ctx, types.NamespacedName{Namespace: configMap.Namespace, Name: owner.Name}, &labInstance); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return labInstance.UID == owner.UID && exposesInstance(&labInstance, route), nil
+}
+
+// exposesInstance returns whether the route exposes one of the URLs of the LabInstance, i.e. whether it has the host
+// of one of them and, in the path mode, the same first segment of the path, which is the UUID of the instance.
+func exposesInstance(labInstance *crownlabsalpha1.LabInstance, route exposure.Route) bool {
+	urls := []string{labInstance.Status.Url}
+	for _, vm := range labInstance.Status.Vms {
+		urls = append(urls, vm.Url)
+	}
+	for _, raw := range urls {
+		instanceUrl, err := url.Parse(raw)
+		if raw == "" || err != nil || instanceUrl.Host == "" || instanceUrl.Host != route.Host {
+			continue
+		}
+		if strings.Trim(instanceUrl.Path, "/") == "" || routeKey(instanceUrl.Host, instanceUrl.Path) == routeKey(route.Host, route.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate verifies the request through the oauth2-proxy of the route, redirecting the unauthenticated users
+// to the login page. It returns whether the request can be forwarded.
+func (p *Proxy) authenticate(w http.ResponseWriter, r *http.Request, route *exposure.Route) bool {
+	authUrl := "http://" + p.Resolve(route.Namespace, route.Auth.ServiceName, route.Auth.ServicePort) + route.Auth.Path + "/auth"
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, authUrl, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	for _, header := range []string{"Cookie", "Authorization"} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	req.Header.Set("X-Original-URI", r.URL.RequestURI())
+	req.Header.Set("X-Forwarded-Host", r.Host)
+
+	resp, err := p.auth.Do(req)
+	if err != nil {
+		p.log.Error(err, "unable to authenticate the request", "route", route.Namespace+"/"+route.Name)
+		http.Error(w, "unable to authenticate the request", http.StatusBadGateway)
+		return false
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true
+	case resp.StatusCode == http.StatusUnauthorized:
+		signin := "https://" + r.Host + route.Auth.Path + "/start?rd=" + url.QueryEscape(r.URL.RequestURI())
+		http.Redirect(w, r, signin, http.StatusFound)
+	case resp.StatusCode == http.StatusForbidden:
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, fmt.Sprintf("unexpected authentication status %v", resp.StatusCode), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// injectBaseHref adds the base element to the head of the uncompressed HTML pages
+func injectBaseHref(resp *http.Response, baseHref string) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	body = bytes.Replace(body, []byte("<head>"), []byte(`<head> <base href="`+baseHref+`">`), 1)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	crownlabsalpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/netgroup-polito/CrownLabs/operators/pkg/exposure"
+)
+
+func TestProxy(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head><title>" + r.URL.Path + "</title></head></html>"))
+	}))
+	defer app.Close()
+	oauth2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/uuid/oauth2/auth" && r.Header.Get("Cookie") == "session=valid":
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/uuid/oauth2/auth":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			_, _ = w.Write([]byte("oauth2 " + r.URL.Path))
+		}
+	}))
+	defer oauth2.Close()
+
+	exposer, err := exposure.New(exposure.Proxy, nil)
+	assert.NoError(t, err)
+	auth := &exposure.RouteAuth{ServiceName: "oauth2-svc", ServicePort: 4180, Path: "/uuid/oauth2"}
+	labInstance := &crownlabsalpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Name: "instance", Namespace: "ns", UID: "instance-uid"},
+		Status: crownlabsalpha1.LabInstanceStatus{Url: "https://crownlabs.polito.it/uuid", Vms: []crownlabsalpha1.VmStatus{
+			{Name: "vm", Url: "https://crownlabs.polito.it/uuid"}, {Name: "subdomain", Url: "https://uuid.labs.example.com"}}}}
+	ownerRef := []metav1.OwnerReference{*metav1.NewControllerRef(labInstance, crownlabsalpha1.GroupVersion.WithKind("LabInstance"))}
+	spoofedRef := []metav1.OwnerReference{*metav1.NewControllerRef(&crownlabsalpha1.LabInstance{ObjectMeta: metav1.ObjectMeta{Name: "instance", UID: "other-uid"}},
+		crownlabsalpha1.GroupVersion.WithKind("LabInstance"))}
+	objects := []runtime.Object{labInstance}
+	for _, route := range []exposure.Route{
+		{Name: "vm", Namespace: "ns", Host: "crownlabs.polito.it", Path: "/uuid", StripPrefix: true,
+			ServiceName: "vm-svc", ServicePort: 6080, Auth: auth, BaseHref: "/uuid/index.html", OwnerReferences: ownerRef},
+		{Name: "oauth2", Namespace: "ns", Host: "crownlabs.polito.it", Path: "/uuid/oauth2", ServiceName: "oauth2-svc", ServicePort: 4180, OwnerReferences: ownerRef},
+		{Name: "subdomain", Namespace: "ns", Host: "uuid.labs.example.com", Path: "/", ServiceName: "vm-svc", ServicePort: 6080, OwnerReferences: ownerRef},
+		// the routes not created for a LabInstance must not intercept the requests of the instances
+		{Name: "unowned", Namespace: "ns", Host: "crownlabs.polito.it", Path: "/uuid/unowned", ServiceName: "oauth2-svc", ServicePort: 4180},
+		{Name: "spoofed", Namespace: "ns", Host: "crownlabs.polito.it", Path: "/uuid/spoofed", ServiceName: "oauth2-svc", ServicePort: 4180, OwnerReferences: spoofedRef},
+		// the routes of a LabInstance must not expose URLs different from the ones of the LabInstance
+		{Name: "forged", Namespace: "ns", Host: "crownlabs.polito.it", Path: "/victim", ServiceName: "oauth2-svc", ServicePort: 4180, OwnerReferences: ownerRef},
+		{Name: "any-host", Namespace: "ns", Path: "/other", ServiceName: "oauth2-svc", ServicePort: 4180, OwnerReferences: ownerRef},
+	} {
+		object, err := exposer.Object(route)
+		assert.NoError(t, err)
+		configMap := object.(corev1.ConfigMap)
+		objects = append(objects, &configMap)
+	}
+
+	s := runtime.NewScheme()
+	assert.NoError(t, scheme.AddToScheme(s))
+	assert.NoError(t, crownlabsalpha1.AddToScheme(s))
+	proxy := New(":0", fake.NewFakeClientWithScheme(s, objects...), logr.Discard())
+	proxy.Resolve = func(namespace, service string, port int32) string {
+		if service == "oauth2-svc" {
+			return strings.TrimPrefix(oauth2.URL, "http://")
+		}
+		return strings.TrimPrefix(app.URL, "http://")
+	}
+	getHost := func(host, path, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+		return recorder
+	}
+	get := func(path, cookie string) *httptest.ResponseRecorder {
+		return getHost("crownlabs.polito.it", path, cookie)
+	}
+
+	resp := get("/uuid/vnc.html?resize=scale", "")
+	assert.Equal(t, resp.Code, http.StatusFound, "The unauthenticated users should be redirected to the login.")
+	assert.Equal(t, resp.Header().Get("Location"), "https://crownlabs.polito.it/uuid/oauth2/start?rd=%2Fuuid%2Fvnc.html%3Fresize%3Dscale")
+
+	resp = get("/uuid/vnc.html", "session=valid")
+	assert.Equal(t, resp.Code, http.StatusOK)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, string(body), `<html><head> <base href="https://crownlabs.polito.it/uuid/index.html"><title>/vnc.html</title></head></html>`,
+		"The prefix should be removed and the base URL injected.")
+
+	resp = get("/uuid", "session=valid")
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "<title>/</title>")
+
+	resp = get("/uuid/oauth2/start", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, string(body), "oauth2 /uuid/oauth2/start", "The longest route should match, without removing the prefix.")
+
+	for _, path := range []string{"/uuid/unowned", "/uuid/spoofed"} {
+		resp = get(path, "session=valid")
+		body, _ = ioutil.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "<title>"+strings.TrimPrefix(path, "/uuid")+"</title>", "The untrusted routes should be ignored.")
+	}
+
+	assert.Equal(t, get("/other/vnc.html", "session=valid").Code, http.StatusNotFound)
+	assert.Equal(t, get("/victim", "session=valid").Code, http.StatusNotFound, "The routes not exposing the LabInstance should be ignored.")
+	assert.Equal(t, get("/uuidx", "session=valid").Code, http.StatusNotFound)
+
+	resp = getHost("uuid.labs.example.com", "/app/index.html", "")
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, string(body), "<html><head><title>/app/index.html</title></head></html>", "The routes of the subdomains should match their host.")
+}
+
+func TestRouteKey(t *testing.T) {
+	assert.Equal(t, routeKey("crownlabs.polito.it", "/uuid/oauth2/start"), "crownlabs.polito.it/uuid")
+	assert.Equal(t, routeKey("crownlabs.polito.it", "/uuid"), "crownlabs.polito.it/uuid")
+	assert.Equal(t, routeKey("uuid.labs.example.com", "/"), "uuid.labs.example.com/")
+}
@@ -0,0 +1,233 @@
+// Package readiness verifies whether the VMs of the LabInstances are ready to be used, according to
+// the readiness checks of their LabTemplate.
+package readiness
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+// The results of the readiness checks
+const (
+	Probing = "Probing"
+	Passed  = "Passed"
+	Failed  = "Failed"
+)
+
+const (
+	vncPort = 6080
+	sshPort = 22
+)
+
+// Checks returns the readiness checks of a VM of the given LabTemplate, with the defaults applied
+func Checks(template crownlabsv1alpha1.LabTemplate, vm crownlabsv1alpha1.NamedVm) []crownlabsv1alpha1.ReadinessCheck {
+	checks := vm.ReadinessChecks
+	if len(checks) == 0 {
+		checks = template.Spec.ReadinessChecks
+	}
+	if len(checks) == 0 {
+		checks = []crownlabsv1alpha1.ReadinessCheck{{Type: crownlabsv1alpha1.ReadinessTCP}}
+	}
+
+	result := make([]crownlabsv1alpha1.ReadinessCheck, len(checks))
+	for i, check := range checks {
+		result[i] = withDefaults(check, vm.VmType)
+	}
+	return result
+}
+
+func withDefaults(check crownlabsv1alpha1.ReadinessCheck, vmType crownlabsv1alpha1.VmType) crownlabsv1alpha1.ReadinessCheck {
+	if check.Port == 0 {
+		switch {
+		case check.Type == crownlabsv1alpha1.ReadinessSSH, check.Type == crownlabsv1alpha1.ReadinessTCP && vmType == crownlabsv1alpha1.TypeCLI:
+			check.Port = sshPort
+		default:
+			check.Port = vncPort
+		}
+	}
+	if check.Type == crownlabsv1alpha1.ReadinessHTTP {
+		if check.Path == "" {
+			check.Path = "/"
+		}
+		if check.ExpectedStatus == 0 {
+			check.ExpectedStatus = http.StatusOK
+		}
+	}
+	if check.TimeoutSeconds == 0 {
+		check.TimeoutSeconds = 1
+	}
+	if check.PeriodSeconds == 0 {
+		check.PeriodSeconds = 1
+	}
+	if check.FailureThreshold == 0 {
+		check.FailureThreshold = 120
+	}
+	return check
+}
+
+// Prober executes the readiness checks of a VM
+type Prober struct {
+	// Host is the address the VM is reachable at, i.e. the IP of the VMI, since its service exposes only the vnc
+	// and ssh ports while the checks may target any port
+	Host string
+	// GetVmi retrieves the current state of the VMI, required by the guest-agent and kubevirt checks
+	GetVmi func(ctx context.Context) (*virtv1.VirtualMachineInstance, error)
+}
+
+// Run executes the given checks in order, retrying each one until it succeeds or the failure threshold is reached.
+// The report function is invoked when each check starts and with the final result, which is also returned.
+func (p Prober) Run(ctx context.Context, checks []crownlabsv1alpha1.ReadinessCheck,
+	report func(status crownlabsv1alpha1.ReadinessStatus)) crownlabsv1alpha1.ReadinessStatus {
+
+	var status crownlabsv1alpha1.ReadinessStatus
+	for _, check := range checks {
+		report(crownlabsv1alpha1.ReadinessStatus{Result: Probing, Check: check.Type, LastProbeTime: now()})
+		status = p.retry(ctx, check)
+		if status.Result == Failed {
+			break
+		}
+	}
+	report(status)
+	return status
+}
+
+// retry executes a check until it succeeds or the failure threshold is reached
+func (p Prober) retry(ctx context.Context, check crownlabsv1alpha1.ReadinessCheck) crownlabsv1alpha1.ReadinessStatus {
+	status := crownlabsv1alpha1.ReadinessStatus{Result: Failed, Check: check.Type}
+	if !sleep(ctx, time.Duration(check.InitialDelaySeconds)*time.Second) {
+		status.Reason = ctx.Err().Error()
+		return status
+	}
+
+	for status.Attempts < check.FailureThreshold {
+		status.Attempts++
+		err := p.Probe(ctx, check)
+		status.LastProbeTime = now()
+		if err == nil {
+			status.Result = Passed
+			status.Reason = ""
+			return status
+		}
+		status.Reason = err.Error()
+		if !sleep(ctx, time.Duration(check.PeriodSeconds)*time.Second) {
+			break
+		}
+	}
+	return status
+}
+
+// Probe executes a single attempt of the given check
+func (p Prober) Probe(ctx context.Context, check crownlabsv1alpha1.ReadinessCheck) error {
+	timeout := time.Duration(check.TimeoutSeconds) * time.Second
+	address := net.JoinHostPort(p.Host, strconv.Itoa(int(check.Port)))
+
+	switch check.Type {
+	case crownlabsv1alpha1.ReadinessTCP:
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case crownlabsv1alpha1.ReadinessHTTP:
+		return probeHTTP(ctx, "http://"+address+check.Path, int(check.ExpectedStatus), timeout)
+	case crownlabsv1alpha1.ReadinessSSH:
+		return probeSSH(address, timeout)
+	case crownlabsv1alpha1.ReadinessGuestAgent:
+		return p.probeCondition(ctx, virtv1.VirtualMachineInstanceAgentConnected, "the guest agent is not connected")
+	case crownlabsv1alpha1.ReadinessKubeVirt:
+		return p.probeCondition(ctx, virtv1.VirtualMachineInstanceReady, "the VMI is not ready")
+	default:
+		return fmt.Errorf("unknown readiness check %v", check.Type)
+	}
+}
+
+func probeHTTP(ctx context.Context, url string, expectedStatus int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != expectedStatus {
+		return fmt.Errorf("GET %v returned status %v, expected %v", url, resp.StatusCode, expectedStatus)
+	}
+	return nil
+}
+
+func probeSSH(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("unable to read the SSH banner from %v: %v", address, err)
+	}
+	if !strings.HasPrefix(banner, "SSH-") {
+		return fmt.Errorf("%v replied with an invalid SSH banner", address)
+	}
+	return nil
+}
+
+func (p Prober) probeCondition(ctx context.Context, conditionType virtv1.VirtualMachineInstanceConditionType, reason string) error {
+	if p.GetVmi == nil {
+		return fmt.Errorf("the VMI is not available")
+	}
+	vmi, err := p.GetVmi(ctx)
+	if err != nil {
+		return err
+	}
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Type == conditionType {
+			if condition.Status == corev1.ConditionTrue {
+				return nil
+			}
+			if condition.Message != "" {
+				return fmt.Errorf("%v: %v", reason, condition.Message)
+			}
+		}
+	}
+	return errors.New(reason)
+}
+
+// sleep waits for the given duration, returning false if the context is cancelled in the meanwhile
+func sleep(ctx context.Context, duration time.Duration) bool {
+	if duration <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func now() *metav1.Time {
+	t := metav1.Now()
+	return &t
+}
@@ -0,0 +1,115 @@
+package readiness
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+// listen returns the host and the port of a listener replying with the given banner to each connection
+func listen(t *testing.T, banner string) (string, int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(banner))
+			conn.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	parsed, _ := strconv.Atoi(port)
+	return host, int32(parsed)
+}
+
+func TestChecks(t *testing.T) {
+	template := crownlabsv1alpha1.LabTemplate{}
+	checks := Checks(template, crownlabsv1alpha1.NamedVm{VmType: crownlabsv1alpha1.TypeCLI})
+	assert.Equal(t, len(checks), 1)
+	assert.Equal(t, checks[0].Type, crownlabsv1alpha1.ReadinessTCP, "A TCP check should be executed by default.")
+	assert.Equal(t, checks[0].Port, int32(22), "The SSH port of CLI VMs should be checked by default.")
+	assert.Equal(t, checks[0].FailureThreshold, int32(120))
+
+	template.Spec.ReadinessChecks = []crownlabsv1alpha1.ReadinessCheck{{Type: crownlabsv1alpha1.ReadinessHTTP}}
+	checks = Checks(template, crownlabsv1alpha1.NamedVm{})
+	assert.Equal(t, checks[0].Port, int32(6080))
+	assert.Equal(t, checks[0].Path, "/")
+	assert.Equal(t, checks[0].ExpectedStatus, int32(http.StatusOK))
+
+	vm := crownlabsv1alpha1.NamedVm{ReadinessChecks: []crownlabsv1alpha1.ReadinessCheck{{Type: crownlabsv1alpha1.ReadinessSSH}}}
+	checks = Checks(template, vm)
+	assert.Equal(t, checks[0].Type, crownlabsv1alpha1.ReadinessSSH, "The checks of the VM should override the ones of the template.")
+	assert.Equal(t, checks[0].Port, int32(22))
+}
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+	host, sshPort := listen(t, "SSH-2.0-OpenSSH_8.2\r\n")
+	_, otherPort := listen(t, "220 smtp ready\r\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	httpPort, _ := strconv.Atoi(port)
+
+	prober := Prober{Host: host}
+	check := func(checkType crownlabsv1alpha1.ReadinessCheckType, port int32, path string) crownlabsv1alpha1.ReadinessCheck {
+		return withDefaults(crownlabsv1alpha1.ReadinessCheck{Type: checkType, Port: port, Path: path}, crownlabsv1alpha1.TypeGUI)
+	}
+
+	assert.NoError(t, prober.Probe(ctx, check(crownlabsv1alpha1.ReadinessTCP, sshPort, "")))
+	assert.NoError(t, prober.Probe(ctx, check(crownlabsv1alpha1.ReadinessSSH, sshPort, "")))
+	assert.Error(t, prober.Probe(ctx, check(crownlabsv1alpha1.ReadinessSSH, otherPort, "")), "Services other than SSH should not pass the ssh check.")
+	assert.NoError(t, prober.Probe(ctx, check(crownlabsv1alpha1.ReadinessHTTP, int32(httpPort), "/ready")))
+	assert.Error(t, prober.Probe(ctx, check(crownlabsv1alpha1.ReadinessHTTP, int32(httpPort), "/")), "Unexpected status codes should fail the http check.")
+}
+
+func TestProbeConditions(t *testing.T) {
+	vmi := virtv1.VirtualMachineInstance{}
+	prober := Prober{GetVmi: func(ctx context.Context) (*virtv1.VirtualMachineInstance, error) { return &vmi, nil }}
+	agent := crownlabsv1alpha1.ReadinessCheck{Type: crownlabsv1alpha1.ReadinessGuestAgent}
+	ready := crownlabsv1alpha1.ReadinessCheck{Type: crownlabsv1alpha1.ReadinessKubeVirt}
+
+	assert.Error(t, prober.Probe(context.Background(), agent))
+	vmi.Status.Conditions = []virtv1.VirtualMachineInstanceCondition{
+		{Type: virtv1.VirtualMachineInstanceAgentConnected, Status: corev1.ConditionTrue},
+		{Type: virtv1.VirtualMachineInstanceReady, Status: corev1.ConditionFalse},
+	}
+	assert.NoError(t, prober.Probe(context.Background(), agent))
+	assert.Error(t, prober.Probe(context.Background(), ready))
+}
+
+func TestRun(t *testing.T) {
+	host, port := listen(t, "SSH-2.0-OpenSSH_8.2\r\n")
+	checks := []crownlabsv1alpha1.ReadinessCheck{
+		{Type: crownlabsv1alpha1.ReadinessSSH, Port: port, TimeoutSeconds: 1, PeriodSeconds: 1, FailureThreshold: 1},
+		{Type: crownlabsv1alpha1.ReadinessGuestAgent, TimeoutSeconds: 1, PeriodSeconds: 1, FailureThreshold: 2},
+	}
+
+	var reported []crownlabsv1alpha1.ReadinessStatus
+	result := Prober{Host: host}.Run(context.Background(), checks, func(status crownlabsv1alpha1.ReadinessStatus) {
+		reported = append(reported, status)
+	})
+	assert.Equal(t, result.Result, Failed)
+	assert.Equal(t, result.Check, crownlabsv1alpha1.ReadinessGuestAgent, "The failing check should be reported.")
+	assert.Equal(t, result.Attempts, int32(2), "The check should be retried up to the failure threshold.")
+	assert.NotEmpty(t, result.Reason)
+	assert.Equal(t, len(reported), 3, "The start of each check and the final result should be reported.")
+	assert.Equal(t, reported[0].Result, Probing)
+}
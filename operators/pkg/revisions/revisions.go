@@ -0,0 +1,172 @@
+// Package revisions records the revisions of the specifications of the LabTemplates as ControllerRevisions, as
+// Kubernetes does for the StatefulSets, so that the LabInstances are created from a known revision, which is not
+// affected by the later changes of the LabTemplate, and the changes can be rolled out and rolled back.
+package revisions
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// TemplateLabel identifies the ControllerRevisions of a LabTemplate
+	TemplateLabel = "crownlabs.polito.it/labtemplate"
+	// HashAnnotation is the hash of the specification recorded by a ControllerRevision
+	HashAnnotation = "crownlabs.polito.it/spec-hash"
+	// DefaultHistoryLimit is the number of previous revisions kept, unless specified by the LabTemplate
+	DefaultHistoryLimit = 10
+)
+
+// Name returns the name of the ControllerRevision of the given revision of the LabTemplate
+func Name(template *crownlabsv1alpha1.LabTemplate, revision int64) string {
+	return template.Name + "-r" + strconv.FormatInt(revision, 10)
+}
+
+// recorded returns the part of the specification recorded by the revisions, i.e. without the fields controlling
+// the releases, the warm pool and the teams, and without the policies of the teachers (the exam, the collection,
+// the egress policy and the priority tier), whose changes apply to all the revisions
+func recorded(spec crownlabsv1alpha1.LabTemplateSpec) crownlabsv1alpha1.LabTemplateSpec {
+	keepLive(&spec, crownlabsv1alpha1.LabTemplateSpec{})
+	return spec
+}
+
+// keepLive replaces the fields not recorded by the revisions with the ones of the live specification
+func keepLive(spec *crownlabsv1alpha1.LabTemplateSpec, live crownlabsv1alpha1.LabTemplateSpec) {
+	spec.Release, spec.RevisionHistoryLimit, spec.WarmPool, spec.Teams = live.Release, live.RevisionHistoryLimit, live.WarmPool, live.Teams
+	spec.Exam, spec.Collection, spec.EgressPolicy, spec.Priority = live.Exam, live.Collection, live.EgressPolicy, live.Priority
+}
+
+// Hash returns the hash of the part of the specification recorded by the revisions
+func Hash(spec crownlabsv1alpha1.LabTemplateSpec) (string, error) {
+	data, err := json.Marshal(recorded(spec))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16], nil
+}
+
+// List returns the revisions of the LabTemplate, sorted from the oldest one
+func List(ctx context.Context, c client.Client, template *crownlabsv1alpha1.LabTemplate) ([]appsv1.ControllerRevision, error) {
+	var revisions appsv1.ControllerRevisionList
+	if err := c.List(ctx, &revisions, client.InNamespace(template.Namespace), client.MatchingLabels{TemplateLabel: template.Name}); err != nil {
+		return nil, err
+	}
+	sort.Slice(revisions.Items, func(i, j int) bool { return revisions.Items[i].Revision < revisions.Items[j].Revision })
+	return revisions.Items, nil
+}
+
+// Record records the current specification of the LabTemplate as a new revision, unless it matches the latest one,
+// returning the latest revision. The revisions are owned by the LabTemplate, hence they are deleted together with it.
+func Record(ctx context.Context, c client.Client, scheme *runtime.Scheme, template *crownlabsv1alpha1.LabTemplate) (int64, error) {
+	hash, err := Hash(template.Spec)
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := List(ctx, c, template)
+	if err != nil {
+		return 0, err
+	}
+	latest := int64(1)
+	if n := len(revisions); n > 0 {
+		if revisions[n-1].Annotations[HashAnnotation] == hash {
+			return revisions[n-1].Revision, nil
+		}
+		latest = revisions[n-1].Revision + 1
+	}
+
+	data, err := json.Marshal(recorded(template.Spec))
+	if err != nil {
+		return 0, err
+	}
+	revision := &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        Name(template, latest),
+			Namespace:   template.Namespace,
+			Labels:      map[string]string{TemplateLabel: template.Name},
+			Annotations: map[string]string{HashAnnotation: hash},
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: latest,
+	}
+	if err := controllerutil.SetControllerReference(template, revision, scheme); err != nil {
+		return 0, err
+	}
+	if err := c.Create(ctx, revision); err != nil {
+		// the revision may have been concurrently recorded by another controller
+		var existing appsv1.ControllerRevision
+		if errors.IsAlreadyExists(err) && c.Get(ctx, types.NamespacedName{Namespace: revision.Namespace, Name: revision.Name}, &existing) == nil &&
+			existing.Annotations[HashAnnotation] == hash {
+			return latest, nil
+		}
+		return 0, err
+	}
+	return latest, nil
+}
+
+// Released returns the revision the new LabInstances are created from: the one released by the LabTemplate, if
+// specified, or the latest one otherwise, which is recorded if needed
+func Released(ctx context.Context, c client.Client, scheme *runtime.Scheme, template *crownlabsv1alpha1.LabTemplate) (int64, error) {
+	if template.Spec.Release != nil {
+		return *template.Spec.Release, nil
+	}
+	return Record(ctx, c, scheme, template)
+}
+
+// Apply replaces the specification of the LabTemplate with the one recorded by the given revision, keeping the
+// fields not recorded by the revisions. It returns a NotFound error if the revision does not exist.
+func Apply(ctx context.Context, c client.Client, template *crownlabsv1alpha1.LabTemplate, revision int64) error {
+	var recordedRevision appsv1.ControllerRevision
+	if err := c.Get(ctx, types.NamespacedName{Namespace: template.Namespace, Name: Name(template, revision)}, &recordedRevision); err != nil {
+		return err
+	}
+	var spec crownlabsv1alpha1.LabTemplateSpec
+	if err := json.Unmarshal(recordedRevision.Data.Raw, &spec); err != nil {
+		return fmt.Errorf("invalid revision %v of LabTemplate %v: %v", revision, template.Name, err)
+	}
+	keepLive(&spec, template.Spec)
+	template.Spec = spec
+	return nil
+}
+
+// Prune deletes the oldest revisions exceeding the history limit of the LabTemplate, except the latest one, the
+// released one and the ones in use (i.e. the ones of the existing LabInstances). It returns the deleted revisions.
+func Prune(ctx context.Context, c client.Client, template *crownlabsv1alpha1.LabTemplate, inUse map[int64]bool) ([]int64, error) {
+	revisions, err := List(ctx, c, template)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	limit := DefaultHistoryLimit
+	if template.Spec.RevisionHistoryLimit != nil {
+		limit = int(*template.Spec.RevisionHistoryLimit)
+	}
+
+	var candidates []appsv1.ControllerRevision
+	for _, revision := range revisions[:len(revisions)-1] {
+		if inUse[revision.Revision] || (template.Spec.Release != nil && *template.Spec.Release == revision.Revision) {
+			continue
+		}
+		candidates = append(candidates, revision)
+	}
+	var deleted []int64
+	for i := 0; i < len(candidates)-limit; i++ {
+		if err := c.Delete(ctx, &candidates[i]); client.IgnoreNotFound(err) != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, candidates[i].Revision)
+	}
+	return deleted, nil
+}
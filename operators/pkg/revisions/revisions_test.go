@@ -0,0 +1,102 @@
+package revisions
+
+import (
+	"context"
+	"testing"
+
+	crownlabsv1alpha1 "github.com/netgroup-polito/CrownLabs/operators/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	virtv1 "kubevirt.io/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func image(template *crownlabsv1alpha1.LabTemplate) string {
+	return template.Spec.Vm.Spec.Volumes[0].ContainerDisk.Image
+}
+
+func setImage(template *crownlabsv1alpha1.LabTemplate, image string) {
+	template.Spec.Vm.Spec.Volumes = []virtv1.Volume{{Name: "containerdisk",
+		VolumeSource: virtv1.VolumeSource{ContainerDisk: &virtv1.ContainerDiskSource{Image: image}}}}
+}
+
+func TestRevisions(t *testing.T) {
+	s := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(s))
+	assert.NoError(t, crownlabsv1alpha1.AddToScheme(s))
+	template := &crownlabsv1alpha1.LabTemplate{ObjectMeta: metav1.ObjectMeta{Namespace: "course-swnet", Name: "swnet-lab1", UID: "uid"}}
+	setImage(template, "image:v1")
+	c := fake.NewFakeClientWithScheme(s, template)
+	ctx := context.Background()
+
+	revision, err := Record(ctx, c, s, template)
+	assert.NoError(t, err)
+	assert.Equal(t, revision, int64(1))
+	revision, err = Record(ctx, c, s, template)
+	assert.NoError(t, err)
+	assert.Equal(t, revision, int64(1), "No revision should be recorded when the specification is unchanged.")
+
+	// the changes of the fields not recorded by the revisions do not create new revisions
+	template.Spec.WarmPool = &crownlabsv1alpha1.WarmPoolSpec{Size: 2}
+	template.Spec.Exam = &crownlabsv1alpha1.ExamProfile{}
+	revision, err = Record(ctx, c, s, template)
+	assert.NoError(t, err)
+	assert.Equal(t, revision, int64(1))
+
+	setImage(template, "image:v2")
+	revision, err = Record(ctx, c, s, template)
+	assert.NoError(t, err)
+	assert.Equal(t, revision, int64(2))
+	recorded, err := List(ctx, c, template)
+	assert.NoError(t, err)
+	assert.Len(t, recorded, 2)
+	assert.Equal(t, recorded[1].Name, "swnet-lab1-r2")
+	assert.Equal(t, recorded[1].OwnerReferences[0].Name, "swnet-lab1", "The revisions should be owned by the LabTemplate.")
+
+	// the instances are created from the released revision, which rolls back the change
+	release := int64(1)
+	template.Spec.Release = &release
+	released, err := Released(ctx, c, s, template)
+	assert.NoError(t, err)
+	assert.Equal(t, released, int64(1))
+	applied := template.DeepCopy()
+	assert.NoError(t, Apply(ctx, c, applied, released))
+	assert.Equal(t, image(applied), "image:v1")
+	assert.Equal(t, applied.Spec.WarmPool.Size, int32(2), "The fields not recorded by the revisions should be kept.")
+	assert.NotNil(t, applied.Spec.Exam, "The exam should always be the one of the live LabTemplate.")
+	assert.Equal(t, *applied.Spec.Release, int64(1))
+	assert.True(t, errors.IsNotFound(Apply(ctx, c, template.DeepCopy(), 5)))
+
+	// the oldest revisions are pruned, except the released one and the ones in use
+	for i, tag := range []string{"v3", "v4", "v5"} {
+		setImage(template, "image:"+tag)
+		revision, err = Record(ctx, c, s, template)
+		assert.NoError(t, err)
+		assert.Equal(t, revision, int64(i+3))
+	}
+	limit := int32(1)
+	template.Spec.RevisionHistoryLimit = &limit
+	deleted, err := Prune(ctx, c, template, map[int64]bool{3: true})
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, []int64{2})
+	recorded, err = List(ctx, c, template)
+	assert.NoError(t, err)
+	var kept []int64
+	for _, r := range recorded {
+		kept = append(kept, r.Revision)
+	}
+	assert.Equal(t, kept, []int64{1, 3, 4, 5})
+
+	// the revisions of other LabTemplates are not affected
+	other := &crownlabsv1alpha1.LabTemplate{ObjectMeta: metav1.ObjectMeta{Namespace: "course-swnet", Name: "swnet-lab2", UID: "other"}}
+	revision, err = Record(ctx, c, s, other)
+	assert.NoError(t, err)
+	assert.Equal(t, revision, int64(1))
+	var all appsv1.ControllerRevisionList
+	assert.NoError(t, c.List(ctx, &all))
+	assert.Len(t, all.Items, 5)
+}
@@ -0,0 +1,356 @@
+// Package tracing records the spans of the creation of the LabInstances, and exports them to an OpenTelemetry
+// collector through the OTLP/HTTP protocol (JSON encoding). All the spans concerning the same generation of a
+// LabInstance belong to the same trace, from the creation of the instance to the readiness of its VMs.
+//
+// The package implements a minimal exporter, rather than relying on the OpenTelemetry SDK and its otlptracehttp
+// exporter: the SDK requires a newer Go toolchain than the one of the operator (go 1.13), and its exporters depend on
+// versions of grpc and protobuf incompatible with the ones required by Kubernetes v0.19 and controller-runtime v0.6.
+// Only the subset of the OTLP/JSON schema required to describe the spans is implemented, following the JSON mapping of
+// the opentelemetry-proto messages (trace/v1/trace.proto): field names in lowerCamelCase, trace and span IDs encoded as
+// hexadecimal strings, 64-bit integers encoded as decimal strings and enumerations encoded as integers.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// tracesPath is the path of the OTLP/HTTP endpoint receiving the traces
+	tracesPath = "/v1/traces"
+	// batchSize and flushPeriod bound the number of spans and the time they are buffered before being exported
+	batchSize   = 256
+	flushPeriod = 5 * time.Second
+	// queueSize is the maximum number of buffered spans: the exceeding ones are dropped
+	queueSize = 4096
+)
+
+// Tracer creates the spans and exports them to an OTLP collector. A nil Tracer is valid, and disables the tracing.
+type Tracer struct {
+	Endpoint    string
+	ServiceName string
+	Log         logr.Logger
+	Client      *http.Client
+
+	queue chan *Span
+}
+
+// NewTracer creates a tracer exporting the spans to the OTLP/HTTP collector at the given endpoint
+// (e.g. http://localhost:4318). It returns nil, disabling the tracing, if the endpoint is empty.
+func NewTracer(endpoint string, serviceName string, log logr.Logger) *Tracer {
+	if endpoint == "" {
+		return nil
+	}
+	return &Tracer{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		ServiceName: serviceName,
+		Log:         log,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, queueSize),
+	}
+}
+
+// Span is an operation of the creation of a LabInstance
+type Span struct {
+	tracer     *Tracer
+	lock       sync.Mutex
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	start      time.Time
+	end        time.Time
+	lastStep   time.Time
+	attributes map[string]string
+	failed     bool
+	message    string
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of the context carrying the given span, which is the parent of the spans started from it
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by the context, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// InstanceIDs returns the trace ID and the root span ID of the given generation of a LabInstance. They are derived
+// from the UID of the instance, so that the spans of different reconciliations belong to the same trace.
+func InstanceIDs(uid types.UID, generation int64) ([16]byte, [8]byte) {
+	sum := sha256.Sum256([]byte(string(uid) + "/" + strconv.FormatInt(generation, 10)))
+	var traceID [16]byte
+	var rootID [8]byte
+	copy(traceID[:], sum[:16])
+	copy(rootID[:], sum[16:24])
+	return traceID, rootID
+}
+
+// InstanceContext returns a copy of the context whose spans are children of the root span of the given generation
+// of a LabInstance. The root span itself is exported by EndInstance.
+func (t *Tracer) InstanceContext(ctx context.Context, uid types.UID, generation int64) context.Context {
+	if t == nil {
+		return ctx
+	}
+	traceID, rootID := InstanceIDs(uid, generation)
+	return ContextWithSpan(ctx, &Span{tracer: t, traceID: traceID, spanID: rootID})
+}
+
+// EndInstance exports the root span of the given generation of a LabInstance, started at the given time
+func (t *Tracer) EndInstance(uid types.UID, generation int64, name string, start time.Time, failure string, attributes map[string]string) {
+	if t == nil {
+		return
+	}
+	traceID, rootID := InstanceIDs(uid, generation)
+	span := &Span{tracer: t, traceID: traceID, spanID: rootID, name: name, start: start, attributes: attributes}
+	if failure != "" {
+		span.Fail(failure)
+	}
+	span.End()
+}
+
+// StartSpan starts a span with the given name, child of the span carried by the context (if any), and returns a copy of
+// the context carrying the new span.
+func (t *Tracer) StartSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := t.newSpan(SpanFromContext(ctx), name, time.Now(), attributes)
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) newSpan(parent *Span, name string, start time.Time, attributes map[string]string) *Span {
+	span := &Span{tracer: t, name: name, start: start, lastStep: start, attributes: attributes}
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+	return span
+}
+
+// Step records a child span, named after the step, lasting from the end of the previous step (or from the start of
+// the span) to now. It allows to trace sequences of operations whose completion is already reported (e.g. through
+// the status of the LabInstance) without wrapping each of them in a span.
+func (s *Span) Step(name string, failed bool) {
+	if s == nil || s.tracer == nil {
+		return
+	}
+	s.lock.Lock()
+	now := time.Now()
+	start := s.lastStep
+	if start.IsZero() {
+		start = now
+	}
+	s.lastStep = now
+	s.lock.Unlock()
+
+	step := s.tracer.newSpan(s, name, start, nil)
+	if failed {
+		step.Fail(name)
+	}
+	step.end = now
+	s.tracer.enqueue(step)
+}
+
+// Fail marks the span as failed, with the given message
+func (s *Span) Fail(message string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failed = true
+	s.message = message
+}
+
+// End completes the span and queues it to be exported
+func (s *Span) End() {
+	if s == nil || s.tracer == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+	s.lock.Unlock()
+	s.tracer.enqueue(s)
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		t.Log.Info("Tracing queue full, span " + span.name + " dropped")
+	}
+}
+
+// Start exports the queued spans until the stop channel is closed. It implements the manager.Runnable interface.
+func (t *Tracer) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(flushPeriod)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			t.Log.Error(err, fmt.Sprintf("unable to export %v spans", len(batch)))
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			// the spans already queued are exported before stopping
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			flush()
+			return nil
+		}
+	}
+}
+
+// export sends the given spans to the collector
+func (t *Tracer) export(spans []*Span) error {
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Post(t.Endpoint+tracesPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("the collector replied with status %v", resp.Status)
+	}
+	return nil
+}
+
+// The following types are the subset of the OTLP/JSON schema required to export the spans. Each of them maps the
+// homonymous message of opentelemetry-proto (ExportTraceServiceRequest, ResourceSpans, Resource, ScopeSpans,
+// InstrumentationScope, Span, KeyValue, AnyValue and Status), and TestExportSchema checks that they do not diverge.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanJSON struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	statusOk         = 1
+	statusError      = 2
+)
+
+func (t *Tracer) request(spans []*Span) exportRequest {
+	converted := make([]spanJSON, 0, len(spans))
+	for _, span := range spans {
+		span.lock.Lock()
+		s := spanJSON{
+			TraceID:           hex.EncodeToString(span.traceID[:]),
+			SpanID:            hex.EncodeToString(span.spanID[:]),
+			Name:              span.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        attributes(span.attributes),
+			Status:            status{Code: statusOk},
+		}
+		if span.parentID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.parentID[:])
+		}
+		if span.failed {
+			s.Status = status{Code: statusError, Message: span.message}
+		}
+		span.lock.Unlock()
+		converted = append(converted, s)
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: attributes(map[string]string{"service.name": t.ServiceName})},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "crownlabs.polito.it/laboratory-operator"}, Spans: converted}},
+	}}}
+}
+
+func attributes(values map[string]string) []keyValue {
+	var result []keyValue
+	for key, value := range values {
+		result = append(result, keyValue{Key: key, Value: anyValue{StringValue: value}})
+	}
+	return result
+}
@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestNilTracer(t *testing.T) {
+	tracer := NewTracer("", "test", ctrl.Log)
+	assert.Nil(t, tracer, "The tracing should be disabled without an endpoint.")
+
+	ctx, span := tracer.StartSpan(context.Background(), "reconcile", nil)
+	span.Step("SecretCreated", false)
+	span.Fail("failure")
+	span.End()
+	tracer.EndInstance("uid", 1, "labinstance", time.Now(), "", nil)
+	assert.Nil(t, SpanFromContext(ctx))
+}
+
+func TestInstanceIDs(t *testing.T) {
+	trace1, root1 := InstanceIDs("uid", 1)
+	trace2, root2 := InstanceIDs("uid", 1)
+	trace3, _ := InstanceIDs("uid", 2)
+	assert.Equal(t, trace1, trace2, "The spans of the same generation should belong to the same trace.")
+	assert.Equal(t, root1, root2)
+	assert.NotEqual(t, trace1, trace3, "Each generation should be traced separately.")
+}
+
+func TestExport(t *testing.T) {
+	requests := make(chan exportRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, tracesPath)
+		var request exportRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests <- request
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(server.URL+"/", "test", ctrl.Log)
+	ctx := tracer.InstanceContext(context.Background(), "uid", 1)
+	_, span := tracer.StartSpan(ctx, "reconcile", map[string]string{"crownlabs.labinstance.name": "instance"})
+	span.Step("SecretCreated", false)
+	span.Step("ServiceNotCreated", true)
+	span.End()
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.NoError(t, tracer.Start(stop))
+
+	request := <-requests
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, len(spans), 3)
+
+	traceID, rootID := InstanceIDs("uid", 1)
+	reconcile := spans[2]
+	assert.Equal(t, reconcile.Name, "reconcile")
+	assert.Equal(t, reconcile.TraceID, hex.EncodeToString(traceID[:]))
+	assert.Equal(t, reconcile.ParentSpanID, hex.EncodeToString(rootID[:]), "The reconciliation should be a child of the root span of the instance.")
+	assert.Equal(t, spans[0].ParentSpanID, reconcile.SpanID, "The steps should be children of the reconciliation.")
+	assert.Equal(t, spans[0].Status.Code, statusOk)
+	assert.Equal(t, spans[1].Status.Code, statusError)
+}
+
+// otlpSchema describes the fields of the opentelemetry-proto messages (trace/v1/trace.proto) exported by the tracer,
+// with the kind of their JSON encoding: a nested message (or a list of messages) is referred to by its name.
+var otlpSchema = map[string]map[string]string{
+	"ExportTraceServiceRequest": {"resourceSpans": "[]ResourceSpans"},
+	"ResourceSpans":             {"resource": "Resource", "scopeSpans": "[]ScopeSpans", "schemaUrl": "string"},
+	"Resource":                  {"attributes": "[]KeyValue", "droppedAttributesCount": "uint32"},
+	"ScopeSpans":                {"scope": "InstrumentationScope", "spans": "[]Span", "schemaUrl": "string"},
+	"InstrumentationScope": {
+		"name": "string", "version": "string", "attributes": "[]KeyValue", "droppedAttributesCount": "uint32",
+	},
+	"Span": {
+		"traceId": "traceId", "spanId": "spanId", "traceState": "string", "parentSpanId": "spanId", "flags": "uint32",
+		"name": "string", "kind": "SpanKind", "startTimeUnixNano": "fixed64", "endTimeUnixNano": "fixed64",
+		"attributes": "[]KeyValue", "droppedAttributesCount": "uint32", "events": "[]Event",
+		"droppedEventsCount": "uint32", "links": "[]Link", "droppedLinksCount": "uint32", "status": "Status",
+	},
+	"KeyValue": {"key": "string", "value": "AnyValue"},
+	"AnyValue": {"stringValue": "string"},
+	"Status":   {"message": "string", "code": "StatusCode"},
+}
+
+// checkSchema checks that the given JSON value is a valid encoding of the given kind of the OTLP schema
+func checkSchema(t *testing.T, path string, kind string, value interface{}) {
+	switch kind {
+	case "string":
+		assert.IsType(t, "", value, path)
+	case "traceId", "spanId":
+		length := map[string]int{"traceId": 32, "spanId": 16}[kind]
+		assert.Regexp(t, regexp.MustCompile("^[0-9a-f]{"+strconv.Itoa(length)+"}$"), value, path+" should be hex encoded")
+	case "fixed64":
+		// the 64-bit integers are encoded as decimal strings
+		text, ok := value.(string)
+		assert.True(t, ok, path+" should be a string")
+		_, err := strconv.ParseUint(text, 10, 64)
+		assert.NoError(t, err, path)
+	case "uint32", "SpanKind", "StatusCode":
+		number, ok := value.(float64)
+		assert.True(t, ok, path+" should be a number")
+		limit := map[string]float64{"uint32": 1 << 32, "SpanKind": 6, "StatusCode": 3}[kind]
+		assert.True(t, number >= 0 && number < limit && number == float64(int64(number)), path+" out of range")
+	default:
+		if len(kind) > 2 && kind[:2] == "[]" {
+			items, ok := value.([]interface{})
+			assert.True(t, ok, path+" should be a list")
+			for i, item := range items {
+				checkSchema(t, path+"["+strconv.Itoa(i)+"]", kind[2:], item)
+			}
+			return
+		}
+		fields, known := otlpSchema[kind]
+		assert.True(t, known, path+": unexpected message "+kind)
+		object, ok := value.(map[string]interface{})
+		assert.True(t, ok, path+" should be an object")
+		for name, field := range object {
+			fieldKind, found := fields[name]
+			if assert.True(t, found, path+"."+name+" is not a field of "+kind) {
+				checkSchema(t, path+"."+name, fieldKind, field)
+			}
+		}
+	}
+}
+
+func TestExportSchema(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Header.Get("Content-Type"), "application/json")
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(server.URL, "test", ctrl.Log)
+	ctx := tracer.InstanceContext(context.Background(), "uid", 1)
+	_, span := tracer.StartSpan(ctx, "reconcile", map[string]string{"crownlabs.labinstance.name": "instance"})
+	span.Step("VmiNotCreated", true)
+	span.End()
+	tracer.EndInstance("uid", 1, "labinstance", time.Now(), "failure", nil)
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.NoError(t, tracer.Start(stop))
+
+	var request map[string]interface{}
+	assert.NoError(t, json.Unmarshal(<-bodies, &request))
+	checkSchema(t, "request", "ExportTraceServiceRequest", request)
+
+	// the required fields of the spans are always present
+	spans := request["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	assert.Equal(t, len(spans), 3)
+	for _, span := range spans {
+		for _, field := range []string{"traceId", "spanId", "name", "kind", "startTimeUnixNano", "endTimeUnixNano", "status"} {
+			assert.Contains(t, span, field)
+		}
+	}
+}